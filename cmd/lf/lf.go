@@ -15,6 +15,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	secrand "crypto/rand"
@@ -28,6 +29,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"math/big"
 	"net"
 	"net/http"
@@ -35,6 +37,7 @@ import (
 	"os"
 	"os/signal"
 	"path"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -170,15 +173,39 @@ Commands:
     database                              Test DAG and database (long!)
   makegenesis                             Create a private database (see docs)
   node-bootstrap <url>                    Bootstrap new node from existing
+                                          (re-run to resume if interrupted)
   node-start [-...]                       Start a full LF node
-    -p2p <port>                           P2P TCP port (default: ` + lfDefaultP2PPortStr + `)
-    -http <port>                          HTTP TCP port (default: ` + lfDefaultHTTPPortStr + `)
+    -p2p <[ip:]port[,...]>                P2P address(es) (default: ` + lfDefaultP2PPortStr + `)
+    -http <[ip:]port|unix:path[,...]>     HTTP address(es) (default: ` + lfDefaultHTTPPortStr + `)
     -oracle                               Use spare CPU to publish commentary
     -loglevel <normal|verbose|trace>      Node log level
     -logstderr                            Log to stderr, not HOME/node.log
+    -logformat <text|json>                Log format (json: one object per line)
     -letsencrypt <host[,host]>            Run LetsEncrypt HTTPS on port 443
+    -tlscert <pem file>                   Serve HTTP API over TLS (with -tlskey)
+    -tlskey <pem file>                    Private key for -tlscert
+    -tlsclientca <pem file>               Require client certs from this CA
+    -cors <origin[,origin]>               Allow browser origins (* for any)
     -localtest                            Disable P2P and ignore proof of work
-  node-connect <ip> <port> <identity>     Tell node to try a P2P endpoint
+    (Flags override HOME/node.json, SIGHUP reloads it and API tokens)
+  node-connect <ip[%zone]> <port> <id>    Tell node to try a P2P endpoint
+  node-token <operation> [...]
+    list                                  List node's named API tokens
+    create [-rate <n>] <name> <scopes>    Create token (scopes comma separated)
+    revoke <name>                         Revoke token
+  node-peers <operation> [...]
+    list                                  List known and connected peers
+    pin <id> [<ip[%zone]> <port>]         Always keep peer connected
+    unpin <id>                            Stop keeping peer connected
+    drop <id>                             Disconnect peer (it may reconnect)
+    forget <id>                           Remove peer from node's peers.json
+    ban <id>                              Disconnect and refuse peer
+    unban <id>                            Lift ban on peer
+  proxy [-...]                            Run a caching proxy for remote nodes
+    -http <port>                          HTTP TCP port (default: ` + lfDefaultHTTPPortStr + `)
+    -ttl <seconds>                        Query/owner cache time (default: 10)
+    -verify                               Check results locally (genesis.lf)
+    -url <url[,url,...]>                  Override configured node URLs
   status                                  Get status from remote node/proxy
   set [-...] [name[#ord]...] <value>      Set a value in the data store
     -file                                 Value is a file path ("-" for stdin)
@@ -187,12 +214,25 @@ Commands:
     -url <url[,url,...]>                  Override configured node/proxy URLs
     -nowork                               Abort if an auth cert doesn't exist
     -pulse                                Generate pulse if value unchanged
+    -worktoken <token>                    Have node do work (worktoken.secret)
+    -seltype <bp160|ed25519>              Selector type (default: bp160)
   get [-...] <name[#start[#end]]> [...]   Find by selector (optional range)
     -mask <key>                           Override default masking key
     -tstart <time>                        Constrain to after this time
     -tend <time>                          Constrain to before this time
     -open                                 Include entries with extra selectors
     -raw                                  Dump raw un-escaped value(s) only
+    -seltype <bp160|ed25519>              Selector type (default: bp160)
+    -trust <policy[:parameters]>          Trust policy for ranking (see below)
+    -explain                              Show all candidates and diagnostics
+    -filter <expression>                  Filter JSON values (reveals mask key)
+    -quorum <n>                           Require n nodes to agree on results
+    -verify                               Check results locally (genesis.lf)
+    -url <url[,url,...]>                  Override configured node/proxy URLs
+  estimate [-...] <value size> [selectors] Estimate proof of work for a record
+    -links <count>                        Link count (default: network min)
+    -local                                Benchmark this machine, not a node
+    -seltype <bp160|ed25519>              Selector type (default: bp160)
     -url <url[,url,...]>                  Override configured node/proxy URLs
   owner <operation> [...]
     list                                  List owners
@@ -212,10 +252,13 @@ Commands:
     add <url>                             Add a URL
     delete <url>                          Delete a URL
     default <url>                         Move URL to front (first to try)
+    tls [<ca file|-> [<cert> <key>]]      Set client TLS (no args to show)
   oracle
     list                                  List trusted oracles
-    add <@oracle>                         Add trusted oracle
+    add <@oracle> [weight]                Add oracle or set weight (default 1)
     delete <@oracle>                      Delete trusted oracle
+    flag <=hash|@owner> <assertion>       Have local oracle node comment
+    comments <=hash|@owner>               Show comments from node and oracles
 
 Global options must precede commands, while command options must come after
 the command name.
@@ -230,6 +273,43 @@ Time can be specified in either RFC1123 format or as numeric Unix time.
 RFC1123 format looks like "` + time.Now().Format(time.RFC1123) + `" while
 Unix time is a decimal number indicating seconds since the Unix epoch.
 
+Trust policies for get -trust are default, cert-first, oldest-claim-wins,
+ca:<serial[,serial]>, weight-threshold:<weight>, and blend:<component=n[,...]>
+where components are local, oracle, signature, weight, and age.
+
+Filter expressions for get -filter compare JSON paths to JSON literals, e.g.
+'$.status == "active" && $.items[0].count >= 2'. Filtering is done by the
+node so the masking key is sent along with the query.
+
+Assertions for oracle flag about records (=hash) are collides-with-claimed-id,
+spam, and canonical. Assertions about owners (@owner) are malicious and
+vouched-for. Flags are sent to the first node URL that accepts them, which must
+be a local node running with -oracle.
+
+API token scopes for node-token create are read-only, makerecord, connect,
+oracle-flag, and admin. Rate limits are in requests per second (default: no
+limit). Token secrets are shown only once when created. Node URLs can include
+a token as user info, e.g. https://<token>@host/, which is sent as a bearer
+token. The node-token and node-peers commands use HOME/authtoken.secret for
+URLs without one.
+
+URLs may use https+mtls:// for HTTPS nodes that require a client certificate.
+The certificate and key set with url tls are only sent to https+mtls URLs. CA
+files are trusted in addition to system roots for all HTTPS URLs. Use - in
+place of a CA file to trust only system roots. Nodes started with -tlscert
+point their own client.json at https:// (or https+mtls://) 127.0.0.1, ::1, or
+localhost, whichever the certificate covers. If it covers none of these, the
+client.json verifies local nodes against the certificate's first DNS name.
+TLS settings are global to a process, so one CA file and client certificate
+apply to every URL.
+
+Browser apps on origins allowed with -cors can use the HTTP API and the
+WebSocket endpoint at /v1/ws, which carries JSON request/response frames
+(see /v1/openapi.json for WebSocketRequest and WebSocketResponse). Browser
+requests are never trusted for coming from localhost, so privileged calls
+need an API token, and privileged paths are only open to origins listed by
+name (not *).
+
 Default home path is ` + lfDefaultPath + ` unless overriden with -path.
 
 Owner certificate note: CSRs can thus certificate authorizations currently
@@ -242,6 +322,77 @@ support will be added.
 
 //////////////////////////////////////////////////////////////////////////////
 
+const (
+	bootstrapMaxRetries = 10
+	bootstrapRetryDelay = 5 * time.Second
+)
+
+// bootstrapState is saved to bootstrap.lf.state after each verified export checkpoint so node-bootstrap can resume.
+type bootstrapState struct {
+	URL    string // URL of node being bootstrapped from
+	Offset uint64 // Export offset of last verified checkpoint
+	Size   int64  // Size of bootstrap.lf.partial at last verified checkpoint
+}
+
+// bootstrapDownload downloads records from an export stream, appending them to the partial bootstrap file at each verified checkpoint.
+// It returns true once the end of the stream is reached, or false and the error that interrupted it along with whether any checkpoints were reached.
+func bootstrapDownload(remote lf.RemoteNode, partialPath, statePath string, state *bootstrapState) (done bool, progressed bool, err error) {
+	out, err := os.OpenFile(partialPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+	defer out.Close()
+	if err = out.Truncate(state.Size); err != nil { // discard anything written after the last verified checkpoint
+		return
+	}
+	if _, err = out.Seek(state.Size, io.SeekStart); err != nil {
+		return
+	}
+
+	stream, err := remote.Export(state.Offset)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+
+	var records bytes.Buffer
+	er := lf.NewExportReader(stream)
+	for {
+		var frameType byte
+		var rec []byte
+		var cp *lf.ExportCheckpoint
+		frameType, rec, cp, err = er.Next()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return
+		}
+		switch frameType {
+		case lf.ExportFrameRecord:
+			records.Write(rec)
+		case lf.ExportFrameCheckpoint:
+			if _, err = out.Write(records.Bytes()); err != nil {
+				return
+			}
+			if err = out.Sync(); err != nil {
+				return
+			}
+			state.Offset = cp.Offset
+			state.Size += int64(records.Len())
+			if err = ioutil.WriteFile(statePath, []byte(lf.PrettyJSON(state)), 0644); err != nil {
+				return
+			}
+			progressed = true
+			records.Reset()
+			fmt.Printf("  %d bytes\n", state.Size)
+		case lf.ExportFrameEnd:
+			done = true
+			return
+		}
+	}
+}
+
 func doNodeBootstrap(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int) {
 	if len(args) == 0 {
 		logger.Printf("FATAL: no URL specified")
@@ -256,8 +407,23 @@ func doNodeBootstrap(cfg *lf.ClientConfig, basePath string, args []string) (exit
 		return
 	}
 
+	// An interrupted bootstrap leaves its progress in bootstrap.lf.state and can be re-run to resume.
 	genesisLf := path.Join(basePath, "genesis.lf")
-	if _, err := os.Stat(genesisLf); err == nil {
+	bootstrapPartial := path.Join(basePath, "bootstrap.lf.partial")
+	bootstrapStatePath := path.Join(basePath, "bootstrap.lf.state")
+	var state bootstrapState
+	if stateJSON, _ := ioutil.ReadFile(bootstrapStatePath); len(stateJSON) > 0 {
+		if json.Unmarshal(stateJSON, &state) != nil {
+			logger.Printf("FATAL: unable to parse %s, delete it to restart bootstrap", bootstrapStatePath)
+			exitCode = 1
+			return
+		}
+		if state.URL != args[0] {
+			logger.Printf("FATAL: interrupted bootstrap from %s found, resume it with that URL or delete %s to restart", state.URL, bootstrapStatePath)
+			exitCode = 1
+			return
+		}
+	} else if _, err := os.Stat(genesisLf); err == nil {
 		logger.Printf("FATAL: node-bootstrap cannot be run on an already-bootstrapped node")
 		exitCode = 1
 		return
@@ -303,47 +469,44 @@ func doNodeBootstrap(cfg *lf.ClientConfig, basePath string, args []string) (exit
 	ioutil.WriteFile(genesisLf, status.GenesisRecords, 0644)
 
 	statusJSON := lf.PrettyJSON(status)
-	fmt.Printf("%s\nDownloading current records...\n", statusJSON)
-	var dumpRecordsURL string
-	if strings.HasSuffix(args[0], "/") {
-		dumpRecordsURL = args[0] + "dumprecords"
+	fmt.Printf("%s\n", statusJSON)
+	state.URL = args[0]
+	if err = ioutil.WriteFile(bootstrapStatePath, []byte(lf.PrettyJSON(&state)), 0644); err != nil {
+		logger.Printf("FATAL: unable to write %s (%s)", bootstrapStatePath, err.Error())
+		exitCode = 1
+		return
+	}
+	if state.Offset > 0 {
+		fmt.Printf("Resuming download of synchronized records after %d bytes...\n", state.Size)
 	} else {
-		dumpRecordsURL = args[0] + "/dumprecords"
+		fmt.Printf("Downloading synchronized records...\n")
 	}
-	resp, err := http.Get(dumpRecordsURL)
-	if resp.StatusCode == 200 {
-		var total uint64
-		out, err := os.OpenFile(path.Join(basePath, "bootstrap.lf"), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
-		if err != nil {
-			log.Printf("FAILED: could not open bootstrap.lf for writing (%s)", err.Error())
+
+	failures := 0
+	for {
+		done, progressed, err := bootstrapDownload(remote, bootstrapPartial, bootstrapStatePath, &state)
+		if done {
+			break
+		}
+		if progressed {
+			failures = 0
+		}
+		failures++
+		if failures > bootstrapMaxRetries {
+			logger.Printf("FAILED: download aborted after %d attempts without progress (%s), run node-bootstrap again to resume", bootstrapMaxRetries, err.Error())
 			exitCode = 1
 			return
 		}
-		var buf [1048576]byte
-		for {
-			n, err := resp.Body.Read(buf[:])
-			if n > 0 {
-				total += uint64(n)
-				fmt.Printf("  %d bytes\n", total)
-				_, err := out.Write(buf[0:n])
-				if err != nil {
-					break
-				}
-			} else {
-				if err != nil && err != io.EOF {
-					log.Printf("FAILED: aborted transfer after unexpected error: %s", err.Error())
-					exitCode = 1
-					return
-				}
-				break
-			}
-		}
-		out.Close()
-	} else {
-		log.Printf("FAILED: %d (%s)", resp.StatusCode, resp.Status)
+		logger.Printf("WARNING: download interrupted after %d bytes (%s), resuming in %d seconds...", state.Size, err.Error(), bootstrapRetryDelay/time.Second)
+		time.Sleep(bootstrapRetryDelay)
+	}
+
+	if err := os.Rename(bootstrapPartial, path.Join(basePath, "bootstrap.lf")); err != nil {
+		logger.Printf("FAILED: could not rename %s to bootstrap.lf (%s)", bootstrapPartial, err.Error())
 		exitCode = 1
 		return
 	}
+	_ = os.Remove(bootstrapStatePath)
 
 	fmt.Printf("\nDone! Node is ready to start.\n")
 
@@ -365,16 +528,21 @@ func doNodeStart(cfg *lf.ClientConfig, basePath string, args []string) (exitCode
 	}()
 
 	nodeOpts := flag.NewFlagSet("node-start", flag.ContinueOnError)
-	p2pPort := nodeOpts.Int("p2p", lf.DefaultP2PPort, "")
-	httpPort := nodeOpts.Int("http", lf.DefaultHTTPPort, "")
+	p2pListen := nodeOpts.String("p2p", lfDefaultP2PPortStr, "")
+	httpListen := nodeOpts.String("http", lfDefaultHTTPPortStr, "")
 	oracle := nodeOpts.Bool("oracle", false, "")
 	logLevel := nodeOpts.String("loglevel", "verbose", "")
 	logToStderr := nodeOpts.Bool("logstderr", false, "")
+	logFormat := nodeOpts.String("logformat", lf.LogFormatText, "")
 	letsEncrypt := nodeOpts.String("letsencrypt", "", "")
 	localTest := nodeOpts.Bool("localtest", false, "")
+	tlsCert := nodeOpts.String("tlscert", "", "")
+	tlsKey := nodeOpts.String("tlskey", "", "")
+	tlsClientCA := nodeOpts.String("tlsclientca", "", "")
+	corsOrigins := nodeOpts.String("cors", "", "")
 	nodeOpts.SetOutput(ioutil.Discard)
 	err := nodeOpts.Parse(args)
-	if err != nil {
+	if err != nil || (len(*tlsCert) > 0) != (len(*tlsKey) > 0) || (len(*tlsClientCA) > 0 && len(*tlsCert) == 0) {
 		printHelp("")
 		exitCode = 1
 		return
@@ -386,21 +554,69 @@ func doNodeStart(cfg *lf.ClientConfig, basePath string, args []string) (exitCode
 		return
 	}
 
-	ll := lf.LogLevelVerbose
-	switch *logLevel {
-	case "normal":
-		ll = lf.LogLevelNormal
-	case "verbose":
-		ll = lf.LogLevelVerbose
-	case "trace":
-		ll = lf.LogLevelTrace
-	default:
+	// Settings come from node.json with any flags given on the command line taking precedence.
+	// Flags are applied again when node.json is reloaded on SIGHUP.
+	setFlags := make(map[string]bool)
+	nodeOpts.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	applyFlags := func(c *lf.NodeConfig) {
+		if setFlags["p2p"] {
+			c.P2PListen = strings.Split(*p2pListen, ",")
+		}
+		if setFlags["http"] {
+			c.HTTPListen = strings.Split(*httpListen, ",")
+		}
+		if setFlags["oracle"] {
+			c.Commentary = *oracle
+		}
+		if setFlags["loglevel"] {
+			c.LogLevel = *logLevel
+		}
+		if setFlags["logstderr"] {
+			c.LogStderr = *logToStderr
+		}
+		if setFlags["logformat"] {
+			c.LogFormat = *logFormat
+		}
+		if setFlags["letsencrypt"] {
+			c.LetsEncrypt = strings.Split(*letsEncrypt, ",")
+		}
+		if setFlags["localtest"] {
+			c.LocalTest = *localTest
+		}
+		if setFlags["tlscert"] {
+			c.TLS = &lf.NodeTLSConfig{
+				CertFile:     *tlsCert,
+				KeyFile:      *tlsKey,
+				ClientCAFile: *tlsClientCA,
+			}
+		}
+		if setFlags["cors"] {
+			c.AllowedOrigins = strings.Split(*corsOrigins, ",")
+		}
+	}
+
+	nodeConfigPath := path.Join(basePath, lf.NodeConfigName)
+	var nodeConfig lf.NodeConfig
+	err = nodeConfig.Load(nodeConfigPath)
+	if err != nil {
+		logger.Printf("FATAL: cannot read %s: %s\n", nodeConfigPath, err.Error())
+		exitCode = 1
+		return
+	}
+	if nodeConfig.Dirty {
+		_ = os.MkdirAll(basePath, 0755)
+		_ = nodeConfig.Save(nodeConfigPath)
+	}
+	applyFlags(&nodeConfig)
+
+	ll, ok := lf.LogLevelFromString(nodeConfig.LogLevel)
+	if !ok || (nodeConfig.LogFormat != lf.LogFormatText && nodeConfig.LogFormat != lf.LogFormatJSON) || (nodeConfig.TLS != nil && (len(nodeConfig.TLS.CertFile) == 0 || len(nodeConfig.TLS.KeyFile) == 0)) {
 		printHelp("")
 		exitCode = 1
 		return
 	}
 
-	if *logToStderr {
+	if nodeConfig.LogStderr {
 		logger = log.New(os.Stderr, "", log.LstdFlags)
 	} else {
 		logFile, err = os.OpenFile(path.Join(basePath, "node.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
@@ -415,10 +631,12 @@ func doNodeStart(cfg *lf.ClientConfig, basePath string, args []string) (exitCode
 	var letsEncryptDomains []string
 	var letsEncryptServer *http.Server
 	var letsEncryptShuttingDown uint32
-	if len(*letsEncrypt) > 0 {
-		letsEncryptDomains = strings.Split(*letsEncrypt, ",")
-		for i := range letsEncryptDomains {
-			letsEncryptDomains[i] = strings.TrimSpace(letsEncryptDomains[i])
+	if len(nodeConfig.LetsEncrypt) > 0 {
+		for _, d := range nodeConfig.LetsEncrypt {
+			d = strings.TrimSpace(d)
+			if len(d) > 0 {
+				letsEncryptDomains = append(letsEncryptDomains, d)
+			}
 		}
 
 		letsEncryptCachePath := path.Join(basePath, "letsencrypt")
@@ -452,13 +670,34 @@ func doNodeStart(cfg *lf.ClientConfig, basePath string, args []string) (exitCode
 	signal.Notify(osSignalChannel, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT, syscall.SIGBUS)
 	signal.Ignore(syscall.SIGUSR1, syscall.SIGUSR2)
 
-	node, err := lf.NewNode(basePath, *p2pPort, *httpPort, logger, ll, *localTest)
+	node, err := lf.NewNode(basePath, nodeConfig.P2PListen, nodeConfig.HTTPListen, nodeConfig.TLS, logger, ll, nodeConfig.LogFormat, nodeConfig.LocalTest, &nodeConfig)
 	if err != nil {
 		logger.Printf("FATAL: unable to start node: %s\n", err.Error())
 		exitCode = 1
 		return
 	}
-	node.SetCommentaryEnabled(*oracle)
+
+	// SIGHUP reloads node.json and API tokens. Settings that only take effect on start are ignored.
+	reloadSignalChannel := make(chan os.Signal, 1)
+	signal.Notify(reloadSignalChannel, syscall.SIGHUP)
+	defer signal.Stop(reloadSignalChannel)
+	go func() {
+		for range reloadSignalChannel {
+			var c lf.NodeConfig
+			err := c.Load(nodeConfigPath)
+			if err == nil {
+				applyFlags(&c)
+				err = node.ApplyConfig(&c)
+			}
+			if err != nil {
+				logger.Printf("WARNING: SIGHUP: unable to reload %s: %s", nodeConfigPath, err.Error())
+			}
+			err = node.ReloadAPITokens()
+			if err != nil {
+				logger.Printf("WARNING: SIGHUP: unable to reload API tokens: %s", err.Error())
+			}
+		}
+	}()
 
 	go func() {
 		sig := <-osSignalChannel
@@ -486,36 +725,396 @@ func doNodeStart(cfg *lf.ClientConfig, basePath string, args []string) (exitCode
 	return
 }
 
+// readGenesisParameters reads the genesis parameters of the network in basePath (the default network if there's no genesis.lf).
+func readGenesisParameters(basePath string) (*lf.GenesisParameters, error) {
+	genesisRecords, _ := ioutil.ReadFile(path.Join(basePath, "genesis.lf"))
+	if len(genesisRecords) == 0 {
+		genesisRecords = lf.SolGenesisRecords
+	}
+	return lf.NewGenesisParametersFromRecords(genesisRecords)
+}
+
+// makeClientNodes creates LF clients for URLs, wrapping them to verify results locally if verify is true.
+func makeClientNodes(basePath string, urls []lf.RemoteNode, verify bool) ([]lf.LF, error) {
+	var gp *lf.GenesisParameters
+	if verify {
+		var err error
+		gp, err = readGenesisParameters(basePath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read genesis records to verify results (%s)", err.Error())
+		}
+	}
+	nodes := make([]lf.LF, 0, len(urls))
+	for _, u := range urls {
+		if gp != nil {
+			vn := lf.NewVerifyingNode(u, gp)
+			nodeURL := u.DisplayURL()
+			vn.Rejected = func(res *lf.QueryResult, err error) {
+				logger.Printf("WARNING: rejected result =%s from %s: %s\n", lf.Base62Encode(res.Hash[:]), nodeURL, err.Error())
+			}
+			nodes = append(nodes, vn)
+		} else {
+			nodes = append(nodes, u)
+		}
+	}
+	return nodes, nil
+}
+
+func doProxy(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int) {
+	proxyOpts := flag.NewFlagSet("proxy", flag.ContinueOnError)
+	httpPort := proxyOpts.Int("http", lf.DefaultHTTPPort, "")
+	ttl := proxyOpts.Int("ttl", int(lf.ProxyDefaultCacheTTL/time.Second), "")
+	verify := proxyOpts.Bool("verify", false, "")
+	urlOverride := proxyOpts.String("url", "", "")
+	proxyOpts.SetOutput(ioutil.Discard)
+	err := proxyOpts.Parse(args)
+	if err != nil || *httpPort <= 0 || *httpPort > 65535 || *ttl <= 0 {
+		printHelp("")
+		exitCode = 1
+		return
+	}
+
+	urls := cfg.URLs
+	if len(*urlOverride) > 0 {
+		urls2 := tokenizeStringWithEsc(*urlOverride, ',', '\\')
+		urls = nil
+		for i := 0; i < len(urls2); i++ {
+			u, err := lf.NewRemoteNode(urls2[i])
+			if err != nil {
+				logger.Printf("FATAL: invalid URL: %s (%s)", urls2[i], err.Error())
+				exitCode = 1
+				return
+			}
+			urls = append(urls, u)
+		}
+	}
+	if len(urls) == 0 {
+		logger.Println("FATAL: no URLs configured!")
+		exitCode = 1
+		return
+	}
+
+	nodes, err := makeClientNodes(basePath, urls, *verify)
+	if err != nil {
+		logger.Printf("FATAL: %s\n", err.Error())
+		exitCode = 1
+		return
+	}
+	proxy := lf.NewProxy(lf.NewMultiNode(nodes...), time.Duration(*ttl)*time.Second, logger)
+
+	server := &http.Server{
+		Addr:           ":" + strconv.Itoa(*httpPort),
+		MaxHeaderBytes: 4096,
+		ErrorLog:       logger,
+		Handler:        proxy.GetHTTPHandler(),
+		IdleTimeout:    10 * time.Second,
+		ReadTimeout:    10 * time.Second,
+		WriteTimeout:   600 * time.Second,
+	}
+	server.SetKeepAlivesEnabled(true)
+
+	var shuttingDown uint32
+	osSignalChannel := make(chan os.Signal, 2)
+	signal.Notify(osSignalChannel, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGINT)
+	go func() {
+		<-osSignalChannel
+		atomic.StoreUint32(&shuttingDown, 1)
+		server.Close()
+	}()
+
+	logger.Printf("proxying HTTP API on port %d to %d upstream node(s) (cache TTL %ds)\n", *httpPort, len(urls), *ttl)
+	err = server.ListenAndServe()
+	if err != nil && atomic.LoadUint32(&shuttingDown) == 0 {
+		logger.Printf("FATAL: unable to start proxy: %s\n", err.Error())
+		exitCode = 1
+	}
+	return
+}
+
 func doNodeConnect(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int) {
 	if len(args) != 3 {
 		printHelp("")
 		exitCode = 1
 		return
 	}
-	ip := net.ParseIP(args[0])
-	if !ip.IsGlobalUnicast() && !ip.IsLoopback() {
+	ipStr, zone := args[0], ""
+	if i := strings.IndexByte(ipStr, '%'); i > 0 {
+		ipStr, zone = ipStr[0:i], ipStr[i+1:]
+	}
+	ip := net.ParseIP(ipStr)
+	if (!ip.IsGlobalUnicast() && !ip.IsLoopback() && !ip.IsLinkLocalUnicast()) || (len(zone) > 0 && ip.To4() != nil) {
+		printHelp("")
+		exitCode = 1
+		return
+	}
+	port, err := strconv.ParseUint(args[1], 10, 64)
+	if port == 0 || port > 65535 || err != nil {
+		printHelp("")
+		exitCode = 1
+		return
+	}
+	urls := cfg.URLs
+	for _, u := range urls {
+		err = u.ConnectPeer(&lf.Peer{
+			IP:       ip,
+			Zone:     zone,
+			Port:     int(port),
+			Identity: lf.Base62Decode(args[2]),
+		})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		logger.Printf("ERROR: cannot send connect command to node: %s\n", err.Error())
+		exitCode = 1
+		return
+	}
+	return
+}
+
+// adminURLs returns the configured node URLs with the local node's master token added to any URL that doesn't include one.
+func adminURLs(cfg *lf.ClientConfig, basePath string) []lf.RemoteNode {
+	authToken, _ := ioutil.ReadFile(path.Join(basePath, "authtoken.secret"))
+	urls := make([]lf.RemoteNode, 0, len(cfg.URLs))
+	for _, u := range cfg.URLs {
+		if !u.HasToken() && len(bytes.TrimSpace(authToken)) > 0 {
+			u = u.WithToken(string(bytes.TrimSpace(authToken)))
+		}
+		urls = append(urls, u)
+	}
+	return urls
+}
+
+func doNodeToken(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool) (exitCode int) {
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	urls := adminURLs(cfg, basePath)
+	if len(urls) == 0 {
+		logger.Println("ERROR: no node URLs configured")
+		exitCode = 1
+		return
+	}
+
+	var err error
+	switch cmd {
+
+	case "list":
+		var tokens []lf.APIToken
+		for _, u := range urls {
+			tokens, err = u.ListAPITokens()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("ERROR: cannot list API tokens: %s\n", err.Error())
+			exitCode = 1
+			return
+		}
+		if jsonOutput {
+			fmt.Println(lf.PrettyJSON(tokens))
+		} else {
+			for _, t := range tokens {
+				rate := "unlimited"
+				if t.RateLimit > 0.0 {
+					rate = fmt.Sprintf("%g/s", t.RateLimit)
+				}
+				fmt.Printf("%-24s %-40s %-10s %s\n", t.Name, strings.Join(t.Scopes, ","), rate, time.Unix(int64(t.Created), 0).Format(time.RFC1123))
+			}
+		}
+
+	case "create":
+		createOpts := flag.NewFlagSet("node-token create", flag.ContinueOnError)
+		rate := createOpts.Float64("rate", 0.0, "")
+		createOpts.SetOutput(ioutil.Discard)
+		err = createOpts.Parse(args)
+		if err != nil || createOpts.NArg() != 2 || *rate < 0.0 {
+			printHelp("")
+			exitCode = 1
+			return
+		}
+		var scopes []string
+		for _, s := range strings.Split(createOpts.Arg(1), ",") {
+			s = strings.TrimSpace(s)
+			if len(s) > 0 {
+				scopes = append(scopes, s)
+			}
+		}
+		var t *lf.APIToken
+		for _, u := range urls {
+			t, err = u.CreateAPIToken(&lf.APITokenCreate{Name: createOpts.Arg(0), Scopes: scopes, RateLimit: *rate})
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("ERROR: cannot create API token: %s\n", err.Error())
+			exitCode = 1
+			return
+		}
+		if jsonOutput {
+			fmt.Println(lf.PrettyJSON(t))
+		} else {
+			fmt.Println(t.Token)
+		}
+
+	case "revoke":
+		if len(args) != 1 {
+			printHelp("")
+			exitCode = 1
+			return
+		}
+		for _, u := range urls {
+			err = u.RevokeAPIToken(args[0])
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("ERROR: cannot revoke API token: %s\n", err.Error())
+			exitCode = 1
+			return
+		}
+
+	default:
+		printHelp("")
+		exitCode = 1
+	}
+
+	return
+}
+
+func doNodePeers(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool) (exitCode int) {
+	cmd := "list"
+	if len(args) > 0 {
+		cmd = args[0]
+		args = args[1:]
+	}
+
+	urls := adminURLs(cfg, basePath)
+	if len(urls) == 0 {
+		logger.Println("ERROR: no node URLs configured")
+		exitCode = 1
+		return
+	}
+
+	if cmd == "list" {
+		if len(args) != 0 {
+			printHelp("")
+			exitCode = 1
+			return
+		}
+		var peers []lf.PeerInfo
+		var err error
+		for _, u := range urls {
+			peers, err = u.ListPeers()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("ERROR: cannot list peers: %s\n", err.Error())
+			exitCode = 1
+			return
+		}
+		if jsonOutput {
+			fmt.Println(lf.PrettyJSON(peers))
+		} else {
+			for _, p := range peers {
+				addr := p.Address
+				if len(addr) == 0 && len(p.IP) > 0 {
+					ta := net.TCPAddr{IP: p.IP, Port: p.Port, Zone: p.Zone}
+					addr = ta.String()
+				}
+				var flags []string
+				if p.Connected {
+					if p.Inbound {
+						flags = append(flags, "inbound")
+					} else {
+						flags = append(flags, "outbound")
+					}
+				}
+				if p.Pinned {
+					flags = append(flags, "pinned")
+				}
+				if p.Banned {
+					flags = append(flags, "banned")
+				}
+				stats := "-"
+				if p.Connected {
+					stats = fmt.Sprintf("up %s, %d bytes out, %d bytes in", time.Since(time.Unix(int64(p.ConnectedSince), 0)).Truncate(time.Second).String(), p.BytesSent, p.BytesReceived)
+				} else if p.LastSuccessfulConnection > 0 {
+					stats = "last seen " + time.Unix(int64(p.LastSuccessfulConnection), 0).Format(time.RFC1123)
+				}
+				fmt.Printf("%-44s %-46s %-24s %s\n", lf.Base62Encode(p.Identity), addr, strings.Join(flags, ","), stats)
+			}
+		}
+		return
+	}
+
+	// All other operations take a peer identity, and pin can also take an address for a new peer.
+	var peer lf.Peer
+	if len(args) == 3 && cmd == "pin" {
+		ipStr, zone := args[1], ""
+		if i := strings.IndexByte(ipStr, '%'); i > 0 {
+			ipStr, zone = ipStr[0:i], ipStr[i+1:]
+		}
+		port, err := strconv.ParseUint(args[2], 10, 64)
+		peer.IP = net.ParseIP(ipStr)
+		peer.Zone = zone
+		peer.Port = int(port)
+		if peer.IP == nil || port == 0 || port > 65535 || err != nil {
+			printHelp("")
+			exitCode = 1
+			return
+		}
+	} else if len(args) != 1 {
 		printHelp("")
 		exitCode = 1
 		return
 	}
-	port, err := strconv.ParseUint(args[1], 10, 64)
-	if port == 0 || port > 65535 || err != nil {
+	peer.Identity = lf.Base62Decode(args[0])
+	if len(peer.Identity) == 0 {
 		printHelp("")
 		exitCode = 1
 		return
 	}
-	urls := cfg.URLs
+
+	var err error
 	for _, u := range urls {
-		err = u.Connect(ip, int(port), lf.Base62Decode(args[2]))
+		switch cmd {
+		case "pin":
+			err = u.PinPeer(&peer, true)
+		case "unpin":
+			err = u.PinPeer(&peer, false)
+		case "drop":
+			err = u.DropPeer(peer.Identity)
+		case "forget":
+			err = u.ForgetPeer(peer.Identity)
+		case "ban":
+			err = u.BanPeer(peer.Identity, true)
+		case "unban":
+			err = u.BanPeer(peer.Identity, false)
+		default:
+			printHelp("")
+			exitCode = 1
+			return
+		}
 		if err == nil {
 			break
 		}
 	}
 	if err != nil {
-		logger.Printf("ERROR: cannot send connect command to node: %s\n", err.Error())
+		logger.Printf("ERROR: cannot %s peer: %s\n", cmd, err.Error())
 		exitCode = 1
 		return
 	}
+
 	return
 }
 
@@ -545,6 +1144,54 @@ func doStatus(cfg *lf.ClientConfig, basePath string, args []string) (exitCode in
 
 var one = 1
 
+// parseCLITrustPolicy parses a trust policy of the form name[:parameters] or returns nil if it is invalid.
+// Parameters are CA serial numbers for "ca", a minimum weight for "weight-threshold", or a list of
+// component=coefficient pairs for "blend", separated by commas.
+func parseCLITrustPolicy(s string) *lf.QueryTrustPolicy {
+	nameParams := tokenizeStringWithEsc(s, ':', '\\')
+	if len(nameParams) == 0 || len(nameParams) > 2 {
+		return nil
+	}
+	tp := &lf.QueryTrustPolicy{Name: strings.ToLower(strings.TrimSpace(nameParams[0]))}
+	var params []string
+	if len(nameParams) == 2 {
+		params = tokenizeStringWithEsc(nameParams[1], ',', '\\')
+	}
+	switch tp.Name {
+	case lf.QueryTrustPolicyCA:
+		for _, p := range params {
+			tp.CAs = append(tp.CAs, strings.TrimSpace(p))
+		}
+	case lf.QueryTrustPolicyWeightThreshold:
+		if len(params) != 1 {
+			return nil
+		}
+		w, err := strconv.ParseUint(strings.TrimSpace(params[0]), 10, 64)
+		if err != nil {
+			return nil
+		}
+		tp.MinWeight = &lf.QueryResultWeight{0, 0, uint32(w >> 32), uint32(w)}
+	case lf.QueryTrustPolicyBlend:
+		tp.Blend = make(map[string]float64)
+		for _, p := range params {
+			cw := strings.SplitN(p, "=", 2)
+			if len(cw) != 2 {
+				return nil
+			}
+			w, err := strconv.ParseFloat(strings.TrimSpace(cw[1]), 64)
+			if err != nil {
+				return nil
+			}
+			tp.Blend[strings.TrimSpace(cw[0])] = w
+		}
+	default:
+		if len(params) > 0 {
+			return nil
+		}
+	}
+	return tp
+}
+
 func doGet(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool) (exitCode int) {
 	getOpts := flag.NewFlagSet("get", flag.ContinueOnError)
 	maskKey := getOpts.String("mask", "", "")
@@ -554,6 +1201,12 @@ func doGet(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool
 	rawOutput := getOpts.Bool("raw", false, "")
 	urlOverride := getOpts.String("url", "", "")
 	json2 := getOpts.Bool("json", jsonOutput, "") // allow -json after get for convenience
+	selectorTypeName := getOpts.String("seltype", "", "")
+	trustPolicyName := getOpts.String("trust", "", "")
+	explain := getOpts.Bool("explain", false, "")
+	valueFilter := getOpts.String("filter", "", "")
+	quorum := getOpts.Int("quorum", 0, "")
+	verify := getOpts.Bool("verify", false, "")
 	getOpts.SetOutput(ioutil.Discard)
 	err := getOpts.Parse(args)
 	if err != nil {
@@ -561,6 +1214,25 @@ func doGet(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool
 		exitCode = 1
 		return
 	}
+	selectorType, ok := lf.SelectorTypeFromString(*selectorTypeName)
+	if !ok {
+		logger.Printf("ERROR: get query failed: unrecognized selector type '%s'\n", *selectorTypeName)
+		exitCode = 1
+		return
+	}
+	var trustPolicy *lf.QueryTrustPolicy
+	if len(*trustPolicyName) > 0 {
+		trustPolicy = parseCLITrustPolicy(*trustPolicyName)
+		if trustPolicy == nil {
+			logger.Printf("ERROR: get query failed: invalid trust policy '%s'\n", *trustPolicyName)
+			exitCode = 1
+			return
+		}
+	}
+	selectorKey := func(name string, ord uint64) lf.Blob {
+		k, _ := lf.MakeSelectorKeyWithType(selectorType, []byte(name), ord)
+		return k
+	}
 	args = getOpts.Args()
 	if len(args) < 1 {
 		printHelp("")
@@ -613,20 +1285,20 @@ func doGet(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool
 				mk = []byte(tord[0])
 			}
 			if len(tord) == 1 {
-				ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{lf.MakeSelectorKey([]byte(tord[0]), 0)}})
+				ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{selectorKey(tord[0], 0)}})
 			} else if len(tord) == 2 {
 				if len(tord[1]) == 0 {
-					ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{lf.MakeSelectorKey([]byte(tord[0]), 0), lf.MakeSelectorKey([]byte(tord[0]), 0xffffffffffffffff)}})
+					ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{selectorKey(tord[0], 0), selectorKey(tord[0], 0xffffffffffffffff)}})
 				} else {
 					ord0, _ := strconv.ParseUint(tord[1], 10, 64)
-					ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{lf.MakeSelectorKey([]byte(tord[0]), ord0)}})
+					ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{selectorKey(tord[0], ord0)}})
 				}
 			} else if len(tord) == 3 {
 				ord0, _ := strconv.ParseUint(tord[1], 10, 64)
 				ord1, _ := strconv.ParseUint(tord[2], 10, 64)
 				ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{
-					lf.MakeSelectorKey([]byte(tord[0]), ord0),
-					lf.MakeSelectorKey([]byte(tord[0]), ord1),
+					selectorKey(tord[0], ord0),
+					selectorKey(tord[0], ord1),
 				}})
 			} else {
 				logger.Printf("ERROR: get query failed: selector or selector ordinal range invalid")
@@ -638,24 +1310,56 @@ func doGet(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool
 	}
 
 	req := &lf.Query{
-		Ranges:    ranges,
-		TimeRange: tr,
-		Open:      openQuery,
-		Oracles:   cfg.Oracles,
+		Ranges:        ranges,
+		TimeRange:     tr,
+		Open:          openQuery,
+		Oracles:       cfg.Oracles,
+		OracleWeights: cfg.QueryOracleWeights(),
+		TrustPolicy:   trustPolicy,
+	}
+	if len(*valueFilter) > 0 {
+		req.Filter = *valueFilter
+		req.MaskingKey = mk
+	}
+	if *explain {
+		req.Explain = explain
+		*rawOutput = false
 	}
 	if *rawOutput {
 		jsonOutput = false
 	}
-	if !jsonOutput {
+	if !jsonOutput && !*explain {
 		req.Limit = &one
 	}
 
+	if *quorum > len(urls) {
+		logger.Printf("ERROR: get query failed: quorum of %d requires at least %d URLs\n", *quorum, *quorum)
+		exitCode = 1
+		return
+	}
+	nodes, err := makeClientNodes(basePath, urls, *verify)
+	if err != nil {
+		logger.Printf("ERROR: get query failed: %s\n", err.Error())
+		exitCode = 1
+		return
+	}
+	mn := lf.NewMultiNode(nodes...)
+
 	var results lf.QueryResults
-	for _, u := range urls {
-		results, err = u.ExecuteQuery(req)
+	if *quorum > 1 {
+		mn.Quorum = *quorum
+		var qqr *lf.MultiNodeQuorumResult
+		qqr, err = mn.ExecuteQueryQuorum(req)
 		if err == nil {
-			break
+			if len(qqr.Agreeing) < qqr.Quorum {
+				logger.Printf("ERROR: get query failed: quorum of %d not reached (agree: %s) (disagree: %s) (failed: %s)\n", qqr.Quorum, strings.Join(qqr.Agreeing, ","), strings.Join(qqr.Disagreeing, ","), strings.Join(qqr.Failed, ","))
+				exitCode = 1
+				return
+			}
+			results = qqr.Results
 		}
+	} else {
+		results, err = mn.ExecuteQuery(req)
 	}
 
 	if err != nil {
@@ -680,6 +1384,8 @@ func doGet(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool
 		} else {
 			fmt.Println("[]")
 		}
+	} else if *explain {
+		printQueryExplanation(results)
 	} else if *rawOutput {
 		for _, ress := range results {
 			if len(ress) > 0 {
@@ -750,6 +1456,53 @@ func doGet(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool
 	return
 }
 
+// printQueryExplanation prints every candidate considered by a query along with its diagnostics.
+func printQueryExplanation(results lf.QueryResults) {
+	for ri, ress := range results {
+		if ri > 0 {
+			fmt.Println("")
+		}
+		fmt.Printf("result %d:\n", ri+1)
+		for _, res := range ress {
+			printQueryCandidate(&res)
+		}
+		if len(ress) > 0 && ress[0].Explain != nil {
+			for _, res := range ress[0].Explain.Excluded {
+				printQueryCandidate(&res)
+			}
+		}
+	}
+}
+
+// printQueryCandidate prints a single query candidate and its diagnostics for printQueryExplanation.
+func printQueryCandidate(res *lf.QueryResult) {
+	fmt.Printf("  =%s %s ts %d weight %.8x%.8x%.8x%.8x\n", lf.Base62Encode(res.Hash[:]), res.Record.Owner.String(), res.Record.Timestamp, res.Weight[0], res.Weight[1], res.Weight[2], res.Weight[3])
+	if res.Explain == nil {
+		return
+	}
+	filter := "included"
+	if len(res.Explain.Filter) > 0 {
+		filter = "filtered (" + res.Explain.Filter + ")"
+	}
+	fmt.Printf("    %s, trust %.4f (local %.4f, oracle %.4f)\n", filter, res.Trust, res.LocalTrust, res.OracleTrust)
+	if len(res.TrustComponents) > 0 {
+		components := make([]string, 0, len(res.TrustComponents))
+		for c := range res.TrustComponents {
+			components = append(components, c)
+		}
+		sort.Strings(components)
+		fmt.Print("    trust components:")
+		for _, c := range components {
+			fmt.Printf(" %s %.4f", c, res.TrustComponents[c])
+		}
+		fmt.Println("")
+	}
+	fmt.Printf("    local reputation %d, cert %s, work valid %t, negative comments %d, positive comments %d\n", res.Explain.LocalReputation, res.Explain.CertStatus, res.Explain.WorkValid, res.Explain.NegativeComments, res.Explain.PositiveComments)
+	for _, v := range res.OracleVerdicts {
+		fmt.Printf("    oracle %s (weight %g): %s\n", v.Oracle.String(), v.Weight, v.Verdict)
+	}
+}
+
 func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int) {
 	go lf.WharrgarblInitTable(path.Join(basePath, "wharrgarbl-table.bin"))
 
@@ -760,6 +1513,8 @@ func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 	urlOverride := setOpts.String("url", "", "")
 	noWork := setOpts.Bool("nowork", false, "")
 	pulseIfUnchanged := setOpts.Bool("pulse", false, "")
+	workToken := setOpts.String("worktoken", "", "")
+	selectorTypeName := setOpts.String("seltype", "", "")
 	setOpts.SetOutput(ioutil.Discard)
 	err := setOpts.Parse(args)
 	if err != nil {
@@ -767,6 +1522,12 @@ func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 		exitCode = 1
 		return
 	}
+	selectorType, ok := lf.SelectorTypeFromString(*selectorTypeName)
+	if !ok {
+		logger.Printf("ERROR: set failed: unrecognized selector type '%s'\n", *selectorTypeName)
+		exitCode = 1
+		return
+	}
 	args = setOpts.Args()
 	if len(args) < 2 { // must have at least one selector and a value
 		printHelp("")
@@ -804,6 +1565,7 @@ func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 
 	var plainTextSelectorNames [][]byte
 	var plainTextSelectorOrdinals []uint64
+	var selectorTypes []byte
 	for i := 0; i < len(args)-1; i++ {
 		var unesc string
 		json.Unmarshal([]byte("\""+args[i]+"\""), &unesc) // use JSON string escaping for selector arguments
@@ -822,6 +1584,7 @@ func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 				}
 				plainTextSelectorNames = append(plainTextSelectorNames, sel)
 				plainTextSelectorOrdinals = append(plainTextSelectorOrdinals, ord)
+				selectorTypes = append(selectorTypes, selectorType)
 				if len(mk) == 0 {
 					mk = sel
 				}
@@ -900,15 +1663,16 @@ func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 
 	var ranges []lf.QueryRange
 	for i := range plainTextSelectorNames {
-		key := lf.MakeSelectorKey(plainTextSelectorNames[i], plainTextSelectorOrdinals[i])
+		key, _ := lf.MakeSelectorKeyWithType(selectorType, plainTextSelectorNames[i], plainTextSelectorOrdinals[i])
 		ranges = append(ranges, lf.QueryRange{KeyRange: []lf.Blob{key, key}})
 	}
 	one := 1
 	query := lf.Query{
-		Ranges:  ranges,
-		Owners:  []lf.OwnerPublic{owner.Public},
-		Limit:   &one,
-		Oracles: cfg.Oracles,
+		Ranges:        ranges,
+		Owners:        []lf.OwnerPublic{owner.Public},
+		Limit:         &one,
+		Oracles:       cfg.Oracles,
+		OracleWeights: cfg.QueryOracleWeights(),
 	}
 	for trials := 0; trials < 2; trials++ {
 		oldrecs, err := workingURL.ExecuteQuery(&query)
@@ -946,16 +1710,52 @@ func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 
 	var rec *lf.Record
 
-	var wf *lf.Wharrgarblr
-	if !ownerInfo.HasCurrentCertificate {
-		if *noWork {
-			logger.Printf("ERROR: no auth certificate found for owner %s and -nowork was specified.\n", o.String())
+	if !ownerInfo.HasCurrentCertificate && *noWork {
+		logger.Printf("ERROR: no auth certificate found for owner %s and -nowork was specified.\n", o.String())
+		exitCode = 1
+		return
+	}
+	pulseToken, err := lf.NewPulse(o, plainTextSelectorNames, plainTextSelectorOrdinals, ownerInfo.ServerTime, 0)
+	if err != nil {
+		logger.Printf("ERROR: set failed: %s\n", err.Error())
+		exitCode = 1
+		return
+	}
+	rb := lf.RecordBuilder{SelectorTypes: selectorTypes}
+	err = rb.Start(lf.RecordTypeDatum, value, lf.CastHashBlobsToArrays(ownerInfo.NewRecordLinks), mk, plainTextSelectorNames, plainTextSelectorOrdinals, o.Public, pulseToken.Key(), ownerInfo.ServerTime)
+	if err != nil {
+		logger.Printf("ERROR: set failed: %s\n", err.Error())
+		exitCode = 1
+		return
+	}
+	if !ownerInfo.HasCurrentCertificate && len(*workToken) > 0 {
+		err = addRemoteWork(&rb, workingURL, *workToken)
+	} else if !ownerInfo.HasCurrentCertificate {
+		wf := lf.NewWharrgarblr(lf.RecordDefaultWharrgarblMemory, 0)
+
+		// Ctrl-C cleanly aborts proof of work instead of killing the process mid-computation.
+		ctx, cancel := context.WithCancel(context.Background())
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, syscall.SIGINT, syscall.SIGTERM)
+		go func() {
+			if _, ok := <-interrupt; ok {
+				cancel()
+			}
+		}()
+		err = rb.AddWorkContext(ctx, wf, 0, workProgressPrinter())
+		signal.Stop(interrupt)
+		close(interrupt)
+		cancel()
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		if err == context.Canceled {
+			logger.Println("ERROR: set failed: proof of work aborted")
 			exitCode = 1
 			return
 		}
-		wf = lf.NewWharrgarblr(lf.RecordDefaultWharrgarblMemory, 0)
 	}
-	rec, err = lf.NewRecord(lf.RecordTypeDatum, value, lf.CastHashBlobsToArrays(ownerInfo.NewRecordLinks), mk, plainTextSelectorNames, plainTextSelectorOrdinals, ownerInfo.ServerTime, wf, o)
+	if err == nil {
+		rec, err = rb.Complete(o)
+	}
 	if err == nil {
 		for trials := 0; trials < 2; trials++ {
 			err = workingURL.AddRecord(rec)
@@ -977,6 +1777,149 @@ func doSet(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 	return
 }
 
+// workProgressPrinter returns a progress function that shows proof of work status and an ETA on stderr.
+// The ETA assumes the rate at which completion probability accumulates stays about the same, which holds
+// once the search's memory table is full, and is reached when about one collision is expected.
+func workProgressPrinter() lf.WharrgarblProgressFunc {
+	startTime := time.Now()
+	return func(iterations uint64, probability float64) {
+		elapsed := time.Since(startTime)
+		eta := "any moment now"
+		expected := -math.Log(1.0 - probability) // expected collisions so far
+		if expected > 0.0 && expected < 1.0 {
+			eta = "~" + (time.Duration(float64(elapsed)*((1.0/expected)-1.0)) / time.Second * time.Second).String()
+		} else if expected <= 0.0 {
+			eta = "unknown"
+		}
+		fmt.Fprintf(os.Stderr, "\r\033[Kcomputing proof of work: %d iterations, %.1f%% chance of completion so far, ETA %s", iterations, probability*100.0, eta)
+	}
+}
+
+// addRemoteWork asks a node to compute proof of work for a record being built locally.
+func addRemoteWork(rb *lf.RecordBuilder, node lf.LF, workToken string) error {
+	workHash, difficulty := rb.WorkHash()
+	wr, err := node.ExecuteMakeWork(&lf.MakeWork{WorkHash: workHash, Difficulty: difficulty, AuthToken: workToken})
+	if err != nil {
+		return err
+	}
+	return rb.SetWork(wr.Work)
+}
+
+func doEstimate(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool) (exitCode int) {
+	estimateOpts := flag.NewFlagSet("estimate", flag.ContinueOnError)
+	linkCount := estimateOpts.Int("links", -1, "")
+	local := estimateOpts.Bool("local", false, "")
+	urlOverride := estimateOpts.String("url", "", "")
+	json2 := estimateOpts.Bool("json", jsonOutput, "")
+	selectorTypeName := estimateOpts.String("seltype", "", "")
+	estimateOpts.SetOutput(ioutil.Discard)
+	err := estimateOpts.Parse(args)
+	if err != nil {
+		printHelp("")
+		exitCode = 1
+		return
+	}
+	args = estimateOpts.Args()
+	if len(args) < 1 || len(args) > 2 {
+		printHelp("")
+		exitCode = 1
+		return
+	}
+	jsonOutput = *json2
+
+	var e lf.Estimate
+	var ok bool
+	e.SelectorType, ok = lf.SelectorTypeFromString(*selectorTypeName)
+	if !ok {
+		logger.Printf("ERROR: estimate failed: unrecognized selector type '%s'\n", *selectorTypeName)
+		exitCode = 1
+		return
+	}
+	e.ValueSize, err = strconv.Atoi(args[0])
+	if err == nil && len(args) > 1 {
+		e.Selectors, err = strconv.Atoi(args[1])
+	}
+	if err != nil {
+		logger.Printf("ERROR: estimate failed: invalid value size or selector count")
+		exitCode = 1
+		return
+	}
+	if *linkCount >= 0 {
+		e.Links = linkCount
+	}
+
+	var result *lf.EstimateResult
+	if *local {
+		var links int
+		if e.Links != nil {
+			links = *e.Links
+		} else {
+			gp, err := readGenesisParameters(basePath)
+			if err != nil {
+				logger.Printf("ERROR: estimate failed: unable to read genesis records to get link count (%s)", err.Error())
+				exitCode = 1
+				return
+			}
+			links = int(gp.RecordMinLinks)
+		}
+		var er lf.EstimateResult
+		er.Difficulty, err = lf.RecordWorkDifficulty(e.ValueSize, e.Selectors, links, e.SelectorType)
+		if err != nil {
+			logger.Printf("ERROR: estimate failed: %s", err.Error())
+			exitCode = 1
+			return
+		}
+		lf.WharrgarblInitTable(path.Join(basePath, "wharrgarbl-table.bin"))
+		er.ExpectedIterations = lf.WharrgarblExpectedIterations(er.Difficulty, lf.RecordDefaultWharrgarblMemory)
+		er.IterationsPerSecond = lf.WharrgarblBenchmark(lf.RecordDefaultWharrgarblMemory, 0, time.Second*3)
+		if er.IterationsPerSecond > 0.0 {
+			er.ExpectedSeconds = float64(er.ExpectedIterations) / er.IterationsPerSecond
+		}
+		result = &er
+	} else {
+		urls := cfg.URLs
+		if len(*urlOverride) > 0 {
+			urls2 := tokenizeStringWithEsc(*urlOverride, ',', '\\')
+			urls = nil
+			for i := 0; i < len(urls2); i++ {
+				u, err := lf.NewRemoteNode(urls2[i])
+				if err != nil {
+					logger.Printf("ERROR: invalid URL: %s (%s)", urls2[i], err.Error())
+					exitCode = 1
+					return
+				}
+				urls = append(urls, u)
+			}
+		}
+		if len(urls) == 0 {
+			logger.Println("ERROR: estimate failed: no URLs configured!")
+			exitCode = 1
+			return
+		}
+		for _, u := range urls {
+			result, err = u.ExecuteEstimate(&e)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("ERROR: estimate failed: %s", err.Error())
+			exitCode = 1
+			return
+		}
+	}
+
+	if jsonOutput {
+		fmt.Println(lf.PrettyJSON(result))
+	} else if result.IterationsPerSecond <= 0.0 {
+		fmt.Printf("difficulty %d (%.8x), ~%d iterations (node has not yet measured its work rate)\n", result.Difficulty, result.Difficulty, result.ExpectedIterations)
+	} else {
+		fmt.Printf("difficulty %d (%.8x), ~%d iterations at %.0f iterations/second, ~%s expected\n", result.Difficulty, result.Difficulty, result.ExpectedIterations, result.IterationsPerSecond, (time.Duration(result.ExpectedSeconds*float64(time.Second)) / time.Second * time.Second).String())
+	}
+
+	return
+}
+
 func doOwner(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int) {
 	cmd := "list"
 	if len(args) > 0 {
@@ -1568,6 +2511,45 @@ func doURL(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 			fmt.Println(u)
 		}
 
+	case "tls":
+		if len(args) == 3 || len(args) > 4 {
+			printHelp("")
+			exitCode = 1
+			return
+		}
+		if len(args) > 1 {
+			tc := &lf.RemoteNodeTLSConfig{}
+			if cfg.TLS != nil {
+				tc.LocalServerName = cfg.TLS.LocalServerName // set by the node itself, see node-start
+			}
+			if args[1] != "-" {
+				tc.RootCAFile, _ = filepath.Abs(args[1])
+			}
+			if len(args) == 4 {
+				tc.CertFile, _ = filepath.Abs(args[2])
+				tc.KeyFile, _ = filepath.Abs(args[3])
+			}
+			err := lf.SetRemoteNodeTLSConfig(tc)
+			if err != nil {
+				logger.Printf("ERROR: invalid TLS configuration: %s\n", err.Error())
+				exitCode = 1
+				return
+			}
+			if len(tc.RootCAFile) == 0 && len(tc.CertFile) == 0 && len(tc.LocalServerName) == 0 {
+				tc = nil
+			}
+			cfg.TLS = tc
+			cfg.Dirty = true
+		}
+		if cfg.TLS != nil {
+			fmt.Printf("CA:   %s\nCert: %s\nKey:  %s\n", cfg.TLS.RootCAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile)
+			if len(cfg.TLS.LocalServerName) > 0 {
+				fmt.Printf("Local node name: %s\n", cfg.TLS.LocalServerName)
+			}
+		} else {
+			fmt.Println("(system roots, no client certificate)")
+		}
+
 	default:
 		printHelp("")
 		exitCode = 1
@@ -1576,7 +2558,7 @@ func doURL(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int)
 	return
 }
 
-func doOracle(cfg *lf.ClientConfig, basePath string, args []string) (exitCode int) {
+func doOracle(cfg *lf.ClientConfig, basePath string, args []string, jsonOutput bool) (exitCode int) {
 	cmd := "list"
 	if len(args) > 0 {
 		cmd = args[0]
@@ -1585,7 +2567,7 @@ func doOracle(cfg *lf.ClientConfig, basePath string, args []string) (exitCode in
 
 	case "list":
 		for _, o := range cfg.Oracles {
-			fmt.Println(o.String())
+			fmt.Printf("%s %g\n", o.String(), cfg.OracleWeight(o))
 		}
 
 	case "add":
@@ -1600,15 +2582,41 @@ func doOracle(cfg *lf.ClientConfig, basePath string, args []string) (exitCode in
 			exitCode = 1
 			return
 		}
+		weight := 1.0
+		if len(args) >= 3 {
+			var err error
+			weight, err = strconv.ParseFloat(strings.TrimSpace(args[2]), 64)
+			if err != nil || math.IsNaN(weight) || math.IsInf(weight, 0) || weight < 0.0 {
+				logger.Println("ERROR: invalid oracle weight " + args[2] + " (must be a non-negative number)")
+				exitCode = 1
+				return
+			}
+		}
+		setWeight := func() {
+			if cfg.OracleWeights == nil {
+				cfg.OracleWeights = make(map[string]float64)
+			}
+			if weight == 1.0 {
+				delete(cfg.OracleWeights, oracleOwner.String())
+			} else {
+				cfg.OracleWeights[oracleOwner.String()] = weight
+			}
+			cfg.Dirty = true
+		}
 		for _, o := range cfg.Oracles {
 			if bytes.Equal(o, oracleOwner) {
-				fmt.Printf("%s alredy exists in trusted oracle list, nothing done\n", oracleOwner.String())
+				if len(args) >= 3 {
+					setWeight()
+					fmt.Printf("%s weight set to %g\n", oracleOwner.String(), weight)
+				} else {
+					fmt.Printf("%s alredy exists in trusted oracle list, nothing done\n", oracleOwner.String())
+				}
 				return
 			}
 		}
 		cfg.Oracles = append(cfg.Oracles, oracleOwner)
-		cfg.Dirty = true
-		fmt.Printf("%s added as trusted oracle\n", oracleOwner.String())
+		setWeight()
+		fmt.Printf("%s added as trusted oracle with weight %g\n", oracleOwner.String(), weight)
 
 	case "delete":
 		if len(args) < 2 {
@@ -1625,6 +2633,7 @@ func doOracle(cfg *lf.ClientConfig, basePath string, args []string) (exitCode in
 		for i, o := range cfg.Oracles {
 			if bytes.Equal(o, oracleOwner) {
 				cfg.Oracles = append(cfg.Oracles[0:i], cfg.Oracles[i+1:]...)
+				delete(cfg.OracleWeights, oracleOwner.String())
 				cfg.Dirty = true
 				fmt.Printf("%s removed from trusted oracle list\n", oracleOwner.String())
 				return
@@ -1633,6 +2642,103 @@ func doOracle(cfg *lf.ClientConfig, basePath string, args []string) (exitCode in
 		logger.Printf("ERROR: %s not found in trusted oracle list", oracleOwner.String())
 		exitCode = 1
 
+	case "flag":
+		if len(args) < 3 {
+			printHelp("")
+			exitCode = 1
+			return
+		}
+		subject := strings.TrimSpace(args[1])
+		var subjectBytes []byte
+		if strings.HasPrefix(subject, "@") {
+			subjectBytes, _ = lf.NewOwnerPublicFromString(subject)
+		} else {
+			subjectBytes = lf.Base62Decode(strings.TrimPrefix(subject, "="))
+			if len(subjectBytes) != 32 {
+				subjectBytes = nil
+			}
+		}
+		if len(subjectBytes) == 0 {
+			logger.Println("ERROR: invalid record hash or owner " + args[1])
+			exitCode = 1
+			return
+		}
+		if len(cfg.URLs) == 0 {
+			logger.Println("ERROR: no URLs configured!")
+			exitCode = 1
+			return
+		}
+		c := &lf.Comment{Assertion: strings.TrimSpace(args[2]), Subject: subjectBytes}
+		var err error
+		for _, u := range cfg.URLs {
+			err = u.AddComment(c)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			logger.Printf("ERROR: cannot submit comment to node: %s\n", err.Error())
+			exitCode = 1
+			return
+		}
+		fmt.Printf("%s flagged as %s, comment will be published by node's oracle\n", subject, c.Assertion)
+
+	case "comments":
+		if len(args) < 2 {
+			printHelp("")
+			exitCode = 1
+			return
+		}
+		q := &lf.CommentQuery{Oracles: cfg.Oracles}
+		subject := strings.TrimSpace(args[1])
+		if strings.HasPrefix(subject, "@") {
+			q.Owner, _ = lf.NewOwnerPublicFromString(subject)
+			if len(q.Owner) == 0 {
+				logger.Println("ERROR: invalid owner " + args[1])
+				exitCode = 1
+				return
+			}
+		} else {
+			hash := lf.Base62Decode(strings.TrimPrefix(subject, "="))
+			if len(hash) != 32 {
+				logger.Println("ERROR: invalid record hash " + args[1])
+				exitCode = 1
+				return
+			}
+			var hb lf.HashBlob
+			copy(hb[:], hash)
+			q.Record = &hb
+		}
+		nodes := make([]lf.LF, 0, len(cfg.URLs))
+		for _, u := range cfg.URLs {
+			nodes = append(nodes, u)
+		}
+		comments, err := lf.NewMultiNode(nodes...).GetComments(q)
+		if err != nil {
+			logger.Printf("ERROR: comment query failed: %s\n", err.Error())
+			exitCode = 1
+			return
+		}
+		if jsonOutput {
+			fmt.Println(lf.PrettyJSON(comments))
+			return
+		}
+		for _, c := range comments {
+			by := "(pending)"
+			if len(c.Oracle) > 0 {
+				by = c.Oracle.String()
+			}
+			var where string
+			if c.Record != nil {
+				where = " in =" + lf.Base62Encode(c.Record[:]) + " at " + time.Unix(int64(c.Timestamp), 0).String()
+			}
+			about := "=" + lf.Base62Encode(c.Subject)
+			if c.Assertion == lf.CommentAssertionOwnerIsMalicious || c.Assertion == lf.CommentAssertionOwnerVouchedFor {
+				about = lf.OwnerPublic(c.Subject).String()
+			}
+			fmt.Printf("%s: %s %s (%s)%s\n", by, c.Assertion, about, c.Reason, where)
+		}
+
 	default:
 		printHelp("")
 		exitCode = 1
@@ -1852,6 +2958,12 @@ func main() {
 		os.Exit(-1)
 		return
 	}
+	if cfg.TLS != nil {
+		err = lf.SetRemoteNodeTLSConfig(cfg.TLS)
+		if err != nil {
+			fmt.Printf("WARNING: cannot load TLS certificates configured in %s: %s\n", cfgPath, err.Error())
+		}
+	}
 
 	switch args[0] {
 
@@ -1900,9 +3012,18 @@ func main() {
 	case "node-start":
 		exitCode = doNodeStart(&cfg, *basePath, cmdArgs)
 
+	case "proxy":
+		exitCode = doProxy(&cfg, *basePath, cmdArgs)
+
 	case "node-connect":
 		exitCode = doNodeConnect(&cfg, *basePath, cmdArgs)
 
+	case "node-token":
+		exitCode = doNodeToken(&cfg, *basePath, cmdArgs, *jsonOutput)
+
+	case "node-peers":
+		exitCode = doNodePeers(&cfg, *basePath, cmdArgs, *jsonOutput)
+
 	case "status":
 		exitCode = doStatus(&cfg, *basePath, cmdArgs)
 
@@ -1912,6 +3033,9 @@ func main() {
 	case "get":
 		exitCode = doGet(&cfg, *basePath, cmdArgs, *jsonOutput)
 
+	case "estimate":
+		exitCode = doEstimate(&cfg, *basePath, cmdArgs, *jsonOutput)
+
 	case "owner":
 		exitCode = doOwner(&cfg, *basePath, cmdArgs)
 
@@ -1919,7 +3043,7 @@ func main() {
 		exitCode = doURL(&cfg, *basePath, cmdArgs)
 
 	case "oracle":
-		exitCode = doOracle(&cfg, *basePath, cmdArgs)
+		exitCode = doOracle(&cfg, *basePath, cmdArgs, *jsonOutput)
 
 	case "makegenesis":
 		exitCode = doMakeGenesis(&cfg, *basePath, cmdArgs)
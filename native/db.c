@@ -337,6 +337,12 @@ int ZTLF_DB_Open(
 		"AND NOT EXISTS (SELECT dl.linking_record_goff FROM dangling_link AS dl WHERE dl.linking_record_goff = r.goff) "
 		"AND NOT EXISTS (SELECT gp.record_goff FROM graph_pending AS gp WHERE gp.record_goff = r.goff) "
 		"ORDER BY r.ts ASC"); /* this ordering is important for things like genesis record playback */
+	S(db->sGetSynchronized,
+		"SELECT r.doff,r.dlen,r.reputation FROM record AS r WHERE "
+		"r.doff >= ? "
+		"AND NOT EXISTS (SELECT dl.linking_record_goff FROM dangling_link AS dl WHERE dl.linking_record_goff = r.goff) "
+		"AND NOT EXISTS (SELECT gp.record_goff FROM graph_pending AS gp WHERE gp.record_goff = r.goff) "
+		"ORDER BY r.doff ASC LIMIT ?");
 	S(db->sGetAllByIDNotOwner,
 		"SELECT r.doff,r.dlen,r.reputation FROM record AS r WHERE "
 		"r.id = ? "
@@ -460,6 +466,8 @@ int ZTLF_DB_Open(
 		"DELETE FROM limbo WHERE hash = ?");
 	S(db->sHaveRecordInLimbo,
 		"SELECT hash FROM limbo WHERE hash = ?");
+	S(db->sForgetLimboOwner,
+		"DELETE FROM limbo WHERE owner = ?");
 	S(db->sRegisterPulseToken,
 		"INSERT OR IGNORE INTO pulse (token,start,minutes) VALUES (?,?,0)");
 	S(db->sUpdatePulse,
@@ -839,6 +847,7 @@ void ZTLF_DB_Close(struct ZTLF_DB *db)
 		if (db->sGetAllRecords)                        sqlite3_finalize(db->sGetAllRecords);
 		if (db->sGetAllByOwner)                        sqlite3_finalize(db->sGetAllByOwner);
 		if (db->sGetAllByIDNotOwner)                   sqlite3_finalize(db->sGetAllByIDNotOwner);
+		if (db->sGetSynchronized)                      sqlite3_finalize(db->sGetSynchronized);
 		if (db->sGetIDOwnerReputation)                 sqlite3_finalize(db->sGetIDOwnerReputation);
 		if (db->sHaveRecordsWithIDNotOwner)            sqlite3_finalize(db->sHaveRecordsWithIDNotOwner);
 		if (db->sDemoteCollisions)                     sqlite3_finalize(db->sDemoteCollisions);
@@ -878,6 +887,7 @@ void ZTLF_DB_Close(struct ZTLF_DB *db)
 		if (db->sMarkInLimbo)                          sqlite3_finalize(db->sMarkInLimbo);
 		if (db->sTakeFromLimbo)                        sqlite3_finalize(db->sTakeFromLimbo);
 		if (db->sHaveRecordInLimbo)                    sqlite3_finalize(db->sHaveRecordInLimbo);
+		if (db->sForgetLimboOwner)                     sqlite3_finalize(db->sForgetLimboOwner);
 		if (db->sRegisterPulseToken)                   sqlite3_finalize(db->sRegisterPulseToken);
 		if (db->sUpdatePulse)                          sqlite3_finalize(db->sUpdatePulse);
 		if (db->sGetPulse)                             sqlite3_finalize(db->sGetPulse);
@@ -1244,6 +1254,10 @@ struct ZTLF_QueryResults *ZTLF_DB_Query(
 
 	if (!r)
 		goto query_error;
+	if (oracleCount > ZTLF_DB_QUERY_MAX_ORACLES) {
+		ZTLF_L_warning("query has too many oracles: %u (maximum %u)",oracleCount,(unsigned int)ZTLF_DB_QUERY_MAX_ORACLES);
+		goto query_error;
+	}
 
 	sqlite3_reset(db->sQueryClearRecordSet);
 	if (sqlite3_step(db->sQueryClearRecordSet) != SQLITE_DONE) {
@@ -1293,8 +1307,10 @@ struct ZTLF_QueryResults *ZTLF_DB_Query(
 			continue;
 
 		struct ZTLF_QueryResult *qr;
+		int newGroup = 0;
 		const sqlite_int64 ckey = sqlite3_column_int64(db->sQueryGetResults,6);
 		if ((lastCKey != ckey)||(lastOwnerSize != ownerSize)||(memcmp(lastOwner,owner,ownerSize) != 0)) {
+			newGroup = 1;
 			lastCKey = ckey;
 			memcpy(lastOwner,owner,ownerSize);
 			lastOwnerSize = ownerSize;
@@ -1316,6 +1332,9 @@ struct ZTLF_QueryResults *ZTLF_DB_Query(
 			qr->weightH = 0;
 			qr->ownerSize = (unsigned int)ownerSize;
 			qr->negativeComments = 0;
+			qr->positiveComments = 0;
+			qr->negativeOracles = 0;
+			qr->positiveOracles = 0;
 			qr->localReputation = ZTLF_DB_REPUTATION_DEFAULT; /* this gets set to minimum of all records in a group */
 			qr->ckey = (uint64_t)ckey;
 			memcpy(qr->owner,owner,ownerSize);
@@ -1342,18 +1361,46 @@ struct ZTLF_QueryResults *ZTLF_DB_Query(
 			if (rep < qr->localReputation)
 				qr->localReputation = rep;
 
+			/* Note which oracles have negative and positive comments about this record and, once per group since
+			 * a group has only one owner, about its owner. Each oracle counts at most once in each direction per
+			 * group no matter how many records the group has or how many comments an oracle made. */
 			if (oracleCount) {
 				const void *const hash = sqlite3_column_blob(db->sQueryGetResults,5);
 				for(unsigned int i=0;i<oracleCount;i++) {
-					sqlite3_reset(db->sGetCommentsBySubjectAndCommentOracle);
-					sqlite3_bind_blob(db->sGetCommentsBySubjectAndCommentOracle,1,hash,32,SQLITE_STATIC);
-					sqlite3_bind_blob(db->sGetCommentsBySubjectAndCommentOracle,2,oracles[i],(int)oracleSize[i],SQLITE_STATIC);
-					while (sqlite3_step(db->sGetCommentsBySubjectAndCommentOracle) == SQLITE_ROW) {
-						if (sqlite3_column_int(db->sGetCommentsBySubjectAndCommentOracle,0) == ZTLF_DB_COMMENT_ASSERTION_RECORD_COLLIDES_WITH_CLAIMED_ID) {
-							++qr->negativeComments;
+					const uint64_t bit = ((uint64_t)1) << i;
+					for(int s=0;s<(newGroup+1);s++) {
+						sqlite3_reset(db->sGetCommentsBySubjectAndCommentOracle);
+						if (s == 0) {
+							sqlite3_bind_blob(db->sGetCommentsBySubjectAndCommentOracle,1,hash,32,SQLITE_STATIC);
+						} else {
+							sqlite3_bind_blob(db->sGetCommentsBySubjectAndCommentOracle,1,owner,ownerSize,SQLITE_STATIC);
+						}
+						sqlite3_bind_blob(db->sGetCommentsBySubjectAndCommentOracle,2,oracles[i],(int)oracleSize[i],SQLITE_STATIC);
+						while (sqlite3_step(db->sGetCommentsBySubjectAndCommentOracle) == SQLITE_ROW) {
+							switch(sqlite3_column_int(db->sGetCommentsBySubjectAndCommentOracle,0)) {
+								case ZTLF_DB_COMMENT_ASSERTION_RECORD_COLLIDES_WITH_CLAIMED_ID:
+								case ZTLF_DB_COMMENT_ASSERTION_RECORD_IS_SPAM:
+									if (s == 0) qr->negativeOracles |= bit;
+									break;
+								case ZTLF_DB_COMMENT_ASSERTION_RECORD_IS_CANONICAL_FOR_ID:
+									if (s == 0) qr->positiveOracles |= bit;
+									break;
+								case ZTLF_DB_COMMENT_ASSERTION_OWNER_IS_MALICIOUS:
+									if (s == 1) qr->negativeOracles |= bit;
+									break;
+								case ZTLF_DB_COMMENT_ASSERTION_OWNER_VOUCHED_FOR:
+									if (s == 1) qr->positiveOracles |= bit;
+									break;
+							}
 						}
 					}
 				}
+				qr->negativeComments = 0;
+				qr->positiveComments = 0;
+				for(unsigned int i=0;i<oracleCount;i++) {
+					qr->negativeComments += (unsigned int)((qr->negativeOracles >> i) & 1);
+					qr->positiveComments += (unsigned int)((qr->positiveOracles >> i) & 1);
+				}
 			}
 		}
 	}
@@ -1413,6 +1460,28 @@ query_error:
 	return NULL;
 }
 
+struct ZTLF_RecordList *ZTLF_DB_GetSynchronized(struct ZTLF_DB *db,const uint64_t startDoff,const unsigned int max)
+{
+	struct ZTLF_RecordList *r = (struct ZTLF_RecordList *)malloc(sizeof(struct ZTLF_RecordList) + (sizeof(struct ZTLF_RecordIndex) * (max + 1)));
+	if (!r)
+		return NULL;
+	r->count = 0;
+
+	pthread_mutex_lock(&db->dbLock);
+	sqlite3_reset(db->sGetSynchronized);
+	sqlite3_bind_int64(db->sGetSynchronized,1,(sqlite_int64)startDoff);
+	sqlite3_bind_int64(db->sGetSynchronized,2,(sqlite_int64)max);
+	while ((r->count < (long)max)&&(sqlite3_step(db->sGetSynchronized) == SQLITE_ROW)) {
+		r->records[r->count].doff = (uint64_t)sqlite3_column_int64(db->sGetSynchronized,0);
+		r->records[r->count].dlen = (uint64_t)sqlite3_column_int64(db->sGetSynchronized,1);
+		r->records[r->count].localReputation = sqlite3_column_int(db->sGetSynchronized,2);
+		++r->count;
+	}
+	pthread_mutex_unlock(&db->dbLock);
+
+	return r;
+}
+
 struct ZTLF_RecordList *ZTLF_DB_GetAllByIDNotOwner(struct ZTLF_DB *db,const void *id,const void *owner,const unsigned int ownerLen)
 {
 	long rcap = 64;
@@ -1725,6 +1794,16 @@ int ZTLF_DB_HaveRecordIncludeLimbo(struct ZTLF_DB *db,const void *hash)
 	return have;
 }
 
+int ZTLF_DB_ForgetLimboOwner(struct ZTLF_DB *db,const void *owner,const unsigned int ownerSize)
+{
+	pthread_mutex_lock(&db->dbLock);
+	sqlite3_reset(db->sForgetLimboOwner);
+	sqlite3_bind_blob(db->sForgetLimboOwner,1,owner,(int)ownerSize,SQLITE_STATIC);
+	const int ok = sqlite3_step(db->sForgetLimboOwner);
+	pthread_mutex_unlock(&db->dbLock);
+	return (ok == SQLITE_DONE) ? 0 : ZTLF_POS(ok);
+}
+
 int ZTLF_DB_UpdatePulse(struct ZTLF_DB *db,const uint64_t token,const uint64_t minutes,const uint64_t startRangeStart,const uint64_t startRangeEnd)
 {
 	int changed = 0;
@@ -32,8 +32,12 @@
 /* Reputation for records that appear to be collisions with other record composite keys */
 #define ZTLF_DB_REPUTATION_COLLISION 0
 
-/* commentAssertionRecordCollidesWithClaimedID from Go */
+/* Comment assertions from comment.go (subjects are record hashes or owner public keys) */
 #define ZTLF_DB_COMMENT_ASSERTION_RECORD_COLLIDES_WITH_CLAIMED_ID 1
+#define ZTLF_DB_COMMENT_ASSERTION_RECORD_IS_SPAM 2
+#define ZTLF_DB_COMMENT_ASSERTION_OWNER_IS_MALICIOUS 3
+#define ZTLF_DB_COMMENT_ASSERTION_OWNER_VOUCHED_FOR 4
+#define ZTLF_DB_COMMENT_ASSERTION_RECORD_IS_CANONICAL_FOR_ID 5
 
 /**
  * Structure making up graph.bin
@@ -54,6 +58,9 @@ ZTLF_PACKED_STRUCT(struct ZTLF_DB_GraphNode
 /* Big enough for the largest NIST ECC curve, can be increased if needed. */
 #define ZTLF_DB_QUERY_MAX_OWNER_SIZE 72
 
+/* Maximum number of oracles in a query (one bit each in ZTLF_QueryResult's oracle masks). */
+#define ZTLF_DB_QUERY_MAX_ORACLES 64
+
 struct ZTLF_DB;
 
 struct ZTLF_QueryResult
@@ -63,7 +70,10 @@ struct ZTLF_QueryResult
 	uint64_t doff;
 	unsigned int dlen;
 	unsigned int ownerSize;
-	unsigned int negativeComments;
+	unsigned int negativeComments;       /* number of oracles with a negative comment about the record or its owner */
+	unsigned int positiveComments;       /* number of oracles with a positive comment about the record or its owner */
+	uint64_t negativeOracles;            /* bit i is set if oracle i has a negative comment */
+	uint64_t positiveOracles;            /* bit i is set if oracle i has a positive comment */
 	int localReputation;
 	uint64_t ckey;
 	uint8_t owner[ZTLF_DB_QUERY_MAX_OWNER_SIZE];
@@ -128,6 +138,7 @@ struct ZTLF_DB
 	sqlite3_stmt *sGetAllRecords;
 	sqlite3_stmt *sGetAllByOwner;
 	sqlite3_stmt *sGetAllByIDNotOwner;
+	sqlite3_stmt *sGetSynchronized;
 	sqlite3_stmt *sGetIDOwnerReputation;
 	sqlite3_stmt *sHaveRecordsWithIDNotOwner;
 	sqlite3_stmt *sDemoteCollisions;
@@ -167,6 +178,7 @@ struct ZTLF_DB
 	sqlite3_stmt *sMarkInLimbo;
 	sqlite3_stmt *sTakeFromLimbo;
 	sqlite3_stmt *sHaveRecordInLimbo;
+	sqlite3_stmt *sForgetLimboOwner;
 	sqlite3_stmt *sRegisterPulseToken;
 	sqlite3_stmt *sUpdatePulse;
 	sqlite3_stmt *sGetPulse;
@@ -225,6 +237,9 @@ struct ZTLF_QueryResults *ZTLF_DB_Query(
 struct ZTLF_RecordList *ZTLF_DB_GetAllByOwner(struct ZTLF_DB *db,const void *owner,const unsigned int ownerLen);
 struct ZTLF_RecordList *ZTLF_DB_GetAllByIDNotOwner(struct ZTLF_DB *db,const void *id,const void *owner,const unsigned int ownerLen);
 
+/* Gets up to max fully synchronized records with data offsets of at least startDoff in data file order. */
+struct ZTLF_RecordList *ZTLF_DB_GetSynchronized(struct ZTLF_DB *db,const uint64_t startDoff,const unsigned int max);
+
 /* Gets the data offset and data length of a record by its hash (returns length, sets doff and ts). */
 unsigned int ZTLF_DB_GetByHash(struct ZTLF_DB *db,const void *hash,uint64_t *doff,uint64_t *ts);
 
@@ -297,6 +312,8 @@ int ZTLF_DB_MarkInLimbo(struct ZTLF_DB *db,const void *hash,const void *owner,co
 
 int ZTLF_DB_HaveRecordIncludeLimbo(struct ZTLF_DB *db,const void *hash);
 
+int ZTLF_DB_ForgetLimboOwner(struct ZTLF_DB *db,const void *owner,const unsigned int ownerSize);
+
 int ZTLF_DB_UpdatePulse(struct ZTLF_DB *db,const uint64_t token,const uint64_t minutes,const uint64_t startRangeStart,const uint64_t startRangeEnd);
 
 uint64_t ZTLF_DB_GetPulse(struct ZTLF_DB *db,const uint64_t token);
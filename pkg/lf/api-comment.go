@@ -0,0 +1,248 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+const (
+	// commentQueryMaxOracles is the maximum number of oracles in a CommentQuery (not counting the node's own).
+	commentQueryMaxOracles = 16
+
+	// commentQueryMaxRecords is how many of each oracle's most recent records a CommentQuery scans for comments.
+	commentQueryMaxRecords = 1024
+)
+
+// Comment assertion names as they appear in the API.
+// Record assertions take a record hash as their subject and owner assertions take an owner public key.
+const (
+	CommentAssertionRecordCollidesWithClaimedID = "collides-with-claimed-id" // Record's ID was already claimed by another owner (negative)
+	CommentAssertionRecordIsSpam                = "spam"                     // Record is spam (negative)
+	CommentAssertionOwnerIsMalicious            = "malicious"                // Owner is known to be malicious (negative)
+	CommentAssertionOwnerVouchedFor             = "vouched-for"              // Owner is vouched for by the oracle (positive)
+	CommentAssertionRecordIsCanonicalForID      = "canonical"                // Record is the legitimate record for its ID (positive)
+)
+
+// Comment reason names as they appear in the API.
+const (
+	CommentReasonNone                 = "none"
+	CommentReasonAutomaticallyFlagged = "automatic"
+	CommentReasonManuallyFlagged      = "manual"
+)
+
+// Comment is an assertion made by an oracle about a record or owner.
+// Comments are published in commentary records by oracle nodes. Comments submitted to a node are
+// queued and published by its oracle thread, and are returned without Oracle or Record until then.
+type Comment struct {
+	Oracle    OwnerPublic `json:",omitempty"` // Oracle that made this comment
+	Record    *HashBlob   `json:",omitempty"` // Commentary record containing this comment (nil if not yet published)
+	Timestamp uint64      `json:",omitempty"` // Timestamp of commentary record
+	Assertion string      ``                  // What is being asserted about the subject
+	Reason    string      `json:",omitempty"` // Why it is being asserted (always manual for submitted comments)
+	Subject   Blob        ``                  // Subject of assertion (record hash or owner public key depending on assertion)
+}
+
+// CommentQuery requests comments about a record or about an owner and its records.
+// Comments made by the node's own oracle identity are always included along with those of any
+// oracles listed in the query. Only the most recent 1024 records of each oracle are searched.
+type CommentQuery struct {
+	Record  *HashBlob     `json:",omitempty"` // Record to get comments about
+	Owner   OwnerPublic   `json:",omitempty"` // Owner to get comments about (including comments about its records)
+	Oracles []OwnerPublic `json:",omitempty"` // Oracles whose comments should be included (at most 16)
+}
+
+func commentAssertionFromString(s string) (byte, bool) {
+	switch s {
+	case CommentAssertionRecordCollidesWithClaimedID:
+		return commentAssertionRecordCollidesWithClaimedID, true
+	case CommentAssertionRecordIsSpam:
+		return commentAssertionRecordIsSpam, true
+	case CommentAssertionOwnerIsMalicious:
+		return commentAssertionOwnerIsMalicious, true
+	case CommentAssertionOwnerVouchedFor:
+		return commentAssertionOwnerVouchedFor, true
+	case CommentAssertionRecordIsCanonicalForID:
+		return commentAssertionRecordIsCanonicalForID, true
+	}
+	return commentAssertionNil, false
+}
+
+func commentAssertionString(a byte) string {
+	switch a {
+	case commentAssertionRecordCollidesWithClaimedID:
+		return CommentAssertionRecordCollidesWithClaimedID
+	case commentAssertionRecordIsSpam:
+		return CommentAssertionRecordIsSpam
+	case commentAssertionOwnerIsMalicious:
+		return CommentAssertionOwnerIsMalicious
+	case commentAssertionOwnerVouchedFor:
+		return CommentAssertionOwnerVouchedFor
+	case commentAssertionRecordIsCanonicalForID:
+		return CommentAssertionRecordIsCanonicalForID
+	}
+	return ""
+}
+
+func commentReasonString(r byte) string {
+	switch r {
+	case commentReasonNone:
+		return CommentReasonNone
+	case commentReasonAutomaticallyFlagged:
+		return CommentReasonAutomaticallyFlagged
+	case commentReasonManuallyFlagged:
+		return CommentReasonManuallyFlagged
+	}
+	return ""
+}
+
+// export converts a comment to its API representation.
+func (c *comment) export(oracle OwnerPublic, rec *Record) Comment {
+	ac := Comment{
+		Oracle:    oracle,
+		Assertion: commentAssertionString(c.assertion),
+		Reason:    commentReasonString(c.reason),
+		Subject:   c.subject,
+	}
+	if rec != nil {
+		h := HashBlob(rec.Hash())
+		ac.Record = &h
+		ac.Timestamp = rec.Timestamp
+	}
+	return ac
+}
+
+// execute queues a comment for publication by a node's oracle thread.
+func (c *Comment) execute(n *Node) error {
+	if atomic.LoadUint32(&n.commentary) == 0 {
+		return ErrCommentaryDisabled
+	}
+	assertion, ok := commentAssertionFromString(c.Assertion)
+	if !ok {
+		return ErrUnknownCommentAssertion
+	}
+	nc := &comment{
+		subject:   make([]byte, len(c.Subject)),
+		assertion: assertion,
+		reason:    commentReasonManuallyFlagged,
+	}
+	copy(nc.subject, c.Subject)
+	if !nc.valid() {
+		return ErrInvalidComment
+	}
+	n.commentsLock.Lock()
+	n.comments.PushBack(nc)
+	n.commentsLock.Unlock()
+	n.oracleLog[LogLevelNormal].Printf("comment: queued manual comment: %s", nc.string())
+	return nil
+}
+
+// matches returns true if a comment is about the record or owner in this query.
+// Comments about a record's owner are included when asking about a record. Record owners are
+// looked up as needed and cached in recordOwners.
+func (q *CommentQuery) matches(n *Node, c *comment, recordOwners map[string][]byte) bool {
+	recordOwner := func(hash []byte) []byte {
+		owner, cached := recordOwners[string(hash)]
+		if !cached {
+			if rec, err := n.GetRecord(hash); err == nil {
+				owner = rec.Owner
+			}
+			recordOwners[string(hash)] = owner
+		}
+		return owner
+	}
+	if c.subjectIsOwner() {
+		return (len(q.Owner) > 0 && bytes.Equal(c.subject, q.Owner)) || (q.Record != nil && bytes.Equal(c.subject, recordOwner(q.Record[:])))
+	}
+	return (q.Record != nil && bytes.Equal(c.subject, q.Record[:])) || (len(q.Owner) > 0 && bytes.Equal(recordOwner(c.subject), q.Owner))
+}
+
+// execute gets comments from a node's own oracle and from the query's oracles.
+func (q *CommentQuery) execute(n *Node) ([]Comment, error) {
+	if q.Record == nil && len(q.Owner) == 0 {
+		return nil, ErrInvalidParameter
+	}
+	if len(q.Oracles) > commentQueryMaxOracles {
+		return nil, ErrQueryTooManyOracles
+	}
+
+	oracles := []OwnerPublic{n.owner.Public}
+	for _, o := range q.Oracles {
+		dup := false
+		for _, o2 := range oracles {
+			if bytes.Equal(o, o2) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			oracles = append(oracles, o)
+		}
+	}
+
+	comments := make([]Comment, 0)
+	recordOwners := make(map[string][]byte)
+	for _, oracle := range oracles {
+		// Records come back oldest first, so only the last commentQueryMaxRecords are read.
+		var recordOffsets [][2]uint64
+		_ = n.db.getAllByOwner(oracle, func(doff, dlen uint64, _ int) bool {
+			recordOffsets = append(recordOffsets, [2]uint64{doff, dlen})
+			return true
+		})
+		if len(recordOffsets) > commentQueryMaxRecords {
+			recordOffsets = recordOffsets[len(recordOffsets)-commentQueryMaxRecords:]
+		}
+		var commentaryRecords []*Record
+		for _, ro := range recordOffsets {
+			recordData, err := n.db.getDataByOffset(ro[0], uint(ro[1]), nil)
+			if err == nil {
+				rec, err := NewRecordFromBytes(recordData)
+				if err == nil && rec.Type == RecordTypeCommentary {
+					commentaryRecords = append(commentaryRecords, rec)
+				}
+			}
+		}
+
+		for _, rec := range commentaryRecords {
+			cdata, _ := rec.GetValue(nil)
+			for len(cdata) > 0 {
+				var c comment
+				var err error
+				cdata, err = c.readFrom(cdata)
+				if err != nil {
+					break
+				}
+				if q.matches(n, &c, recordOwners) {
+					comments = append(comments, c.export(oracle, rec))
+				}
+			}
+		}
+	}
+
+	// Include comments that this node has queued but not yet published.
+	var pending []*comment
+	n.commentsLock.Lock()
+	for e := n.comments.Front(); e != nil; e = e.Next() {
+		pending = append(pending, e.Value.(*comment))
+	}
+	n.commentsLock.Unlock()
+	for _, c := range pending {
+		if q.matches(n, c, recordOwners) {
+			comments = append(comments, c.export(nil, nil))
+		}
+	}
+
+	return comments, nil
+}
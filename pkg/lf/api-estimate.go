@@ -0,0 +1,53 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// Estimate requests an estimate of the proof of work required for a record with a given payload.
+// ValueSize is the size of the value in bytes before compression. If Links is nil the network's
+// minimum link count is assumed.
+type Estimate struct {
+	ValueSize    int  ``                  // Size of value in bytes
+	Selectors    int  ``                  // Number of selectors
+	SelectorType byte `json:",omitempty"` // Type of selectors (default: SelectorTypeBP160)
+	Links        *int `json:",omitempty"` // Number of links or minimum for this network if nil
+}
+
+// EstimateResult (response) contains the estimated cost of a record.
+// Expected iterations and seconds are for the node's own work function and hardware, and actual
+// times vary a lot since proof of work is probabilistic.
+type EstimateResult struct {
+	Difficulty          uint32  // Required Wharrgarbl difficulty
+	ExpectedIterations  uint64  // Approximate search iterations needed
+	IterationsPerSecond float64 // Measured search rate on this node (0 if not measured yet, e.g. while jobs are running)
+	ExpectedSeconds     float64 // Approximate time to compute work on this node (0 if rate not yet measured)
+}
+
+func (e *Estimate) execute(n *Node) (*EstimateResult, error) {
+	links := int(n.genesisParameters.RecordMinLinks)
+	if e.Links != nil {
+		links = *e.Links
+	}
+	diff, err := RecordWorkDifficulty(e.ValueSize, e.Selectors, links, e.SelectorType)
+	if err != nil {
+		return nil, err
+	}
+	var er EstimateResult
+	er.Difficulty = diff
+	er.ExpectedIterations = WharrgarblExpectedIterations(diff, RecordDefaultWharrgarblMemory)
+	er.IterationsPerSecond = n.workIterationsPerSecond()
+	if er.IterationsPerSecond > 0.0 {
+		er.ExpectedSeconds = float64(er.ExpectedIterations) / er.IterationsPerSecond
+	}
+	return &er, nil
+}
@@ -15,6 +15,7 @@ package lf
 
 import (
 	"bytes"
+	"context"
 )
 
 // doMakeRequestSetup contains common code for execute() for pulses and records
@@ -22,6 +23,7 @@ func doMakeRequestSetup(n *Node, selectors []MakeSelector, passphrase string, ow
 	owner *Owner,
 	selectorNames [][]byte,
 	selectorOrdinals []uint64,
+	selectorTypes []byte,
 	maskingKey []byte,
 	recTS, recDoff uint64,
 	recDlen uint,
@@ -52,14 +54,19 @@ func doMakeRequestSetup(n *Node, selectors []MakeSelector, passphrase string, ow
 
 	var selectorRanges [][2][]byte
 	for _, sr := range selectors {
-		skey := MakeSelectorKey(sr.Name, sr.Ordinal)
+		skey, e := MakeSelectorKeyWithType(sr.Type, sr.Name, sr.Ordinal)
+		if e != nil {
+			err = e
+			return
+		}
 		selectorRanges = append(selectorRanges, [2][]byte{skey, skey})
 		selectorNames = append(selectorNames, sr.Name)
 		selectorOrdinals = append(selectorOrdinals, sr.Ordinal)
+		selectorTypes = append(selectorTypes, sr.Type)
 	}
 
 	if scanForOlderRecord {
-		_ = n.db.query(selectorRanges, nil, func(ts, _, _, doff, dlen uint64, _ int, _ uint64, recOwner []byte, _ uint) bool {
+		_ = n.db.query(selectorRanges, nil, func(ts, _, _, doff, dlen uint64, _ int, _ uint64, recOwner []byte, _, _ uint64) bool {
 			if bytes.Equal(recOwner, owner.Public) {
 				if ts > recTS {
 					recTS = ts
@@ -78,6 +85,7 @@ func doMakeRequestSetup(n *Node, selectors []MakeSelector, passphrase string, ow
 type MakeSelector struct {
 	Name    Blob   `json:",omitempty"`
 	Ordinal uint64 ``
+	Type    byte   `json:",omitempty"` // Selector type (default: SelectorTypeBP160)
 }
 
 // MakeRecord requests that the server make and submit a record.
@@ -107,9 +115,26 @@ type MakePulse struct {
 	NewRecordIfPulseSpanExceeded *bool          `json:",omitempty"` // If true (or missing), create new record if pulse is later than max pulse span
 }
 
-func (m *MakeRecord) execute(n *Node) (*Record, Pulse, bool, error) {
+// MakeWork requests that the server compute proof of work for a record built elsewhere.
+// WorkHash is the record's 48-byte work hash as returned by RecordBuilder.WorkHash() and
+// Difficulty is the required Wharrgarbl difficulty. Unlike MakeRecord this reveals no secrets,
+// since the client finishes and signs the record locally. Nodes only accept work requests from
+// trusted clients or clients presenting the node's work auth token.
+type MakeWork struct {
+	WorkHash   Blob   `json:",omitempty"` // Work hash from RecordBuilder
+	Difficulty uint32 ``                  // Wharrgarbl difficulty
+	AuthToken  string `json:",omitempty"` // Contents of worktoken.secret on the node (not needed from localhost)
+}
+
+// MakeWorkResult (response) contains the result of a MakeWork request.
+type MakeWorkResult struct {
+	Work       Blob   // Wharrgarbl output (WharrgarblOutputSize bytes)
+	Iterations uint64 // Number of search iterations required to find it
+}
+
+func (m *MakeRecord) execute(ctx context.Context, n *Node) (*Record, Pulse, bool, error) {
 	pulseIfUnchanged := m.PulseIfUnchanged != nil && *m.PulseIfUnchanged // default: false
-	owner, selectorNames, selectorOrdinals, maskingKey, recTS, recDoff, recDlen, err := doMakeRequestSetup(n, m.Selectors, m.Passphrase, m.OwnerPrivate, m.MaskingKey, pulseIfUnchanged)
+	owner, selectorNames, selectorOrdinals, selectorTypes, maskingKey, recTS, recDoff, recDlen, err := doMakeRequestSetup(n, m.Selectors, m.Passphrase, m.OwnerPrivate, m.MaskingKey, pulseIfUnchanged)
 	if err != nil {
 		return nil, nil, false, err
 	}
@@ -154,7 +179,7 @@ func (m *MakeRecord) execute(n *Node) (*Record, Pulse, bool, error) {
 		return nil, nil, false, ErrRecordInsufficientLinks
 	}
 
-	rec, err := NewRecord(RecordTypeDatum, m.Value, l, maskingKey, selectorNames, selectorOrdinals, ts, wg, owner)
+	rec, err := newRecord(ctx, RecordTypeDatum, m.Value, l, maskingKey, selectorNames, selectorOrdinals, selectorTypes, ts, wg, owner, nil)
 	if err != nil {
 		return nil, nil, false, err
 	}
@@ -166,9 +191,9 @@ func (m *MakeRecord) execute(n *Node) (*Record, Pulse, bool, error) {
 	return nil, nil, false, err
 }
 
-func (m *MakePulse) execute(n *Node) (Pulse, *Record, bool, error) {
+func (m *MakePulse) execute(ctx context.Context, n *Node) (Pulse, *Record, bool, error) {
 	newRecordIfPulseSpanExceeded := m.NewRecordIfPulseSpanExceeded == nil || *m.NewRecordIfPulseSpanExceeded // default: true
-	owner, selectorNames, selectorOrdinals, maskingKey, recTS, recDoff, recDlen, err := doMakeRequestSetup(n, m.Selectors, m.Passphrase, m.OwnerPrivate, m.MaskingKey, newRecordIfPulseSpanExceeded)
+	owner, selectorNames, selectorOrdinals, selectorTypes, maskingKey, recTS, recDoff, recDlen, err := doMakeRequestSetup(n, m.Selectors, m.Passphrase, m.OwnerPrivate, m.MaskingKey, newRecordIfPulseSpanExceeded)
 	if err != nil {
 		return nil, nil, false, err
 	}
@@ -210,7 +235,7 @@ func (m *MakePulse) execute(n *Node) (Pulse, *Record, bool, error) {
 			if uint(len(l)) < n.genesisParameters.RecordMinLinks {
 				return nil, nil, false, ErrRecordInsufficientLinks
 			}
-			rec, err := NewRecord(old.Type, oldv, nil, maskingKey, selectorNames, selectorOrdinals, ts, wg, owner)
+			rec, err := newRecord(ctx, old.Type, oldv, nil, maskingKey, selectorNames, selectorOrdinals, selectorTypes, ts, wg, owner, nil)
 			if err != nil {
 				return nil, nil, false, err
 			}
@@ -230,3 +255,14 @@ func (m *MakePulse) execute(n *Node) (Pulse, *Record, bool, error) {
 	ok, _ := n.DoPulse(pulse, true)
 	return pulse, nil, ok, nil
 }
+
+func (m *MakeWork) execute(ctx context.Context, n *Node, client string) (*MakeWorkResult, error) {
+	if len(m.WorkHash) != 48 || m.Difficulty == 0 || m.Difficulty > recordWharrgarblCost(RecordMaxSize) {
+		return nil, ErrInvalidParameter
+	}
+	work, iterations, err := n.doWork(ctx, client, m.WorkHash, m.Difficulty)
+	if err != nil {
+		return nil, err
+	}
+	return &MakeWorkResult{Work: work[:], Iterations: iterations}, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"net/http"
+	"runtime"
+	"sync"
+)
+
+// QueryBatchMaxSize is the maximum number of queries in a single batch.
+const QueryBatchMaxSize = 256
+
+// QueryBatch (request) is a list of independent queries to be executed together.
+type QueryBatch []Query
+
+// QueryBatchResult (response) is the result of one query in a batch.
+// Either Results or Error is present. Results may be empty if a query succeeded but found nothing.
+type QueryBatchResult struct {
+	Results QueryResults `json:",omitempty"` // Results if query succeeded
+	Error   *ErrAPI      `json:",omitempty"` // Error if query failed
+}
+
+// execute runs all queries in a batch concurrently and returns results in the same order as the queries.
+func (m QueryBatch) execute(n *Node) ([]QueryBatchResult, error) {
+	if len(m) > QueryBatchMaxSize {
+		return nil, ErrQueryBatchTooLarge
+	}
+	results := make([]QueryBatchResult, len(m))
+	if len(m) == 0 {
+		return results, nil
+	}
+
+	workers := runtime.NumCPU()
+	if workers > len(m) {
+		workers = len(m)
+	}
+	next := make(chan int, len(m))
+	for i := range m {
+		next <- i
+	}
+	close(next)
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				qr, err := m[i].execute(n)
+				if err != nil {
+					results[i].Error = &ErrAPI{Code: http.StatusBadRequest, Message: "query failed: " + err.Error(), ErrTypeName: errTypeName(err)}
+				} else {
+					results[i].Results = qr
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results, nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import "crypto/x509"
+
+// Reasons a query candidate was filtered out of results (QueryResultExplanation.Filter).
+const (
+	QueryFilterOwner        = "owner"         // Owner not in query's Owners list
+	QueryFilterTimeRange    = "time-range"    // Timestamp outside query's TimeRange
+	QueryFilterOpen         = "open"          // Record has selectors not named in query and query is not open
+	QueryFilterAuthRequired = "auth-required" // Network requires auth certs and record is not signed
+	QueryFilterWork         = "work"          // Record is not signed and its proof of work is not valid
+	QueryFilterValue        = "value"         // Record value does not match query's value Filter
+	QueryFilterLimit        = "limit"         // Record ranked below query's Limit
+)
+
+// Owner certificate status of a query candidate (QueryResultExplanation.CertStatus).
+const (
+	QueryCertStatusNone       = "none"         // Owner has no certificates
+	QueryCertStatusValid      = "valid"        // A current certificate covers the record's timestamp
+	QueryCertStatusOutOfRange = "out-of-range" // Owner has certificates but none cover the record's timestamp
+	QueryCertStatusRevoked    = "revoked"      // Only revoked certificates cover the record's timestamp
+)
+
+// QueryResultExplanation (response, part of QueryResult) contains diagnostics for a query candidate.
+// It is only present if Explain was set in the query. Results still only contain included candidates, so the
+// candidates that were filtered out or ranked below Limit are listed in Excluded of each result's first record.
+// Results with no included candidate are left out entirely as they would be without Explain.
+type QueryResultExplanation struct {
+	Filter             string        `json:",omitempty"` // Reason candidate was filtered out of results, empty if it was included
+	LocalReputation    int           ``                  // Raw local reputation from the database
+	NegativeComments   uint          ``                  // Number of query oracles with negative comments about this record or its owner
+	ComplainingOracles []OwnerPublic `json:",omitempty"` // Query oracles with negative comments about this record or its owner
+	PositiveComments   uint          ``                  // Number of query oracles with positive comments about this record or its owner
+	EndorsingOracles   []OwnerPublic `json:",omitempty"` // Query oracles with positive comments about this record or its owner
+	CertStatus         string        ``                  // Owner certificate status for this record
+	WorkValid          bool          ``                  // True if record's proof of work is valid
+	Excluded           []QueryResult `json:",omitempty"` // Candidates for this result that were filtered out (first record of a result only)
+}
+
+// queryCertStatus determines a record's owner certificate status from its owner's current and revoked certificates.
+func queryCertStatus(rec *Record, certs, revokedCerts []*x509.Certificate) string {
+	covers := func(cert *x509.Certificate) bool {
+		return rec.Timestamp >= uint64(cert.NotBefore.Unix()) && rec.Timestamp <= uint64(cert.NotAfter.Unix())
+	}
+	for _, cert := range certs {
+		if covers(cert) {
+			return QueryCertStatusValid
+		}
+	}
+	for _, cert := range revokedCerts {
+		if covers(cert) {
+			return QueryCertStatusRevoked
+		}
+	}
+	if len(certs) > 0 || len(revokedCerts) > 0 {
+		return QueryCertStatusOutOfRange
+	}
+	return QueryCertStatusNone
+}
@@ -0,0 +1,365 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Query value filters and projections operate on values that are JSON documents. They use a small
+// JSONPath-like syntax. Paths start with $ and descend into objects with .name or ["name"] and into
+// arrays with [index], e.g. $.owner.emails[0]. A filter is one or more comparisons of the form
+// <path> <op> <JSON literal> where op is one of == != < <= > >= and the literal may be any JSON value
+// including an array or object (which only make sense with == and !=), combined with && and || and
+// grouped with parentheses or negated with !. A bare path tests that the path exists and is not
+// null or false. Strings compare lexically and numbers compare numerically.
+
+const (
+	queryFilterMaxLength = 4096 // maximum length of a filter expression or projection path
+	queryFilterMaxDepth  = 32   // maximum nesting of parentheses and negation in a filter expression
+)
+
+type jsonPath []interface{} // string for object keys, int for array indexes
+
+type queryValueFilter interface {
+	match(doc interface{}) bool
+}
+
+type queryValueFilterAnd [2]queryValueFilter
+type queryValueFilterOr [2]queryValueFilter
+type queryValueFilterNot struct{ f queryValueFilter }
+type queryValueFilterCompare struct {
+	path  jsonPath
+	op    string // empty to test existence
+	value interface{}
+}
+
+func (f queryValueFilterAnd) match(doc interface{}) bool { return f[0].match(doc) && f[1].match(doc) }
+func (f queryValueFilterOr) match(doc interface{}) bool  { return f[0].match(doc) || f[1].match(doc) }
+func (f queryValueFilterNot) match(doc interface{}) bool { return !f.f.match(doc) }
+
+func (f *queryValueFilterCompare) match(doc interface{}) bool {
+	v, ok := f.path.get(doc)
+	if !ok {
+		return false
+	}
+	switch f.op {
+	case "":
+		return v != nil && v != false
+	case "==":
+		return reflect.DeepEqual(v, f.value)
+	case "!=":
+		return !reflect.DeepEqual(v, f.value)
+	}
+	var c int
+	switch a := v.(type) {
+	case float64:
+		b, ok := f.value.(float64)
+		if !ok {
+			return false
+		}
+		if a < b {
+			c = -1
+		} else if a > b {
+			c = 1
+		}
+	case string:
+		b, ok := f.value.(string)
+		if !ok {
+			return false
+		}
+		c = strings.Compare(a, b)
+	default:
+		return false
+	}
+	switch f.op {
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// get returns the value at this path within a document decoded by encoding/json.
+func (p jsonPath) get(doc interface{}) (interface{}, bool) {
+	for _, e := range p {
+		switch k := e.(type) {
+		case string:
+			obj, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, false
+			}
+			doc, ok = obj[k]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := doc.([]interface{})
+			if !ok || k < 0 || k >= len(arr) {
+				return nil, false
+			}
+			doc = arr[k]
+		}
+	}
+	return doc, true
+}
+
+// queryExprParser is a simple recursive descent parser for filter expressions and paths.
+type queryExprParser struct {
+	s     string
+	pos   int
+	depth int
+}
+
+func (p *queryExprParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t' || p.s[p.pos] == '\r' || p.s[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *queryExprParser) consume(tok string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.s[p.pos:], tok) {
+		p.pos += len(tok)
+		return true
+	}
+	return false
+}
+
+// scanString advances past a JSON string starting at the current position.
+func (p *queryExprParser) scanString() {
+	p.pos++
+	for p.pos < len(p.s) {
+		if p.s[p.pos] == '\\' {
+			p.pos += 2
+			continue
+		}
+		p.pos++
+		if p.s[p.pos-1] == '"' {
+			break
+		}
+	}
+	if p.pos > len(p.s) {
+		p.pos = len(p.s)
+	}
+}
+
+// scanLiteral returns the next JSON string, number, keyword, array, or object literal in the expression.
+func (p *queryExprParser) scanLiteral() string {
+	p.skipSpace()
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		p.scanString()
+	} else if p.pos < len(p.s) && (p.s[p.pos] == '[' || p.s[p.pos] == '{') {
+		depth := 0
+		for p.pos < len(p.s) {
+			switch p.s[p.pos] {
+			case '"':
+				p.scanString()
+				continue
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+			}
+			p.pos++
+			if depth == 0 {
+				break
+			}
+		}
+	} else {
+		for p.pos < len(p.s) && strings.IndexByte(" \t\r\n()&|!<>=]", p.s[p.pos]) < 0 {
+			p.pos++
+		}
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *queryExprParser) parseOr() (queryValueFilter, error) {
+	f, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		f2, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		f = queryValueFilterOr{f, f2}
+	}
+	return f, nil
+}
+
+func (p *queryExprParser) parseAnd() (queryValueFilter, error) {
+	f, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		f2, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		f = queryValueFilterAnd{f, f2}
+	}
+	return f, nil
+}
+
+func (p *queryExprParser) parseUnary() (queryValueFilter, error) {
+	if p.consume("(") {
+		if p.depth++; p.depth > queryFilterMaxDepth {
+			return nil, ErrQueryInvalidFilter
+		}
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, ErrQueryInvalidFilter
+		}
+		p.depth--
+		return f, nil
+	}
+	if p.consume("!") {
+		if p.depth++; p.depth > queryFilterMaxDepth {
+			return nil, ErrQueryInvalidFilter
+		}
+		f, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		p.depth--
+		return queryValueFilterNot{f}, nil
+	}
+
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	cmp := &queryValueFilterCompare{path: path}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.consume(op) {
+			cmp.op = op
+			break
+		}
+	}
+	if len(cmp.op) > 0 {
+		if err = json.Unmarshal([]byte(p.scanLiteral()), &cmp.value); err != nil {
+			return nil, ErrQueryInvalidFilter
+		}
+	}
+	return cmp, nil
+}
+
+func (p *queryExprParser) parsePath() (path jsonPath, err error) {
+	if !p.consume("$") {
+		return nil, ErrQueryInvalidFilter
+	}
+	for p.pos < len(p.s) {
+		switch p.s[p.pos] {
+		case '.':
+			p.pos++
+			start := p.pos
+			for p.pos < len(p.s) && (p.s[p.pos] == '_' || p.s[p.pos] == '-' || (p.s[p.pos] >= '0' && p.s[p.pos] <= '9') || (p.s[p.pos] >= 'a' && p.s[p.pos] <= 'z') || (p.s[p.pos] >= 'A' && p.s[p.pos] <= 'Z')) {
+				p.pos++
+			}
+			if p.pos == start {
+				return nil, ErrQueryInvalidFilter
+			}
+			path = append(path, p.s[start:p.pos])
+		case '[':
+			p.pos++
+			end := strings.IndexByte(p.s[p.pos:], ']')
+			if end < 0 {
+				return nil, ErrQueryInvalidFilter
+			}
+			if p.s[p.pos] == '"' {
+				var k string
+				if json.Unmarshal([]byte(p.scanLiteral()), &k) != nil {
+					return nil, ErrQueryInvalidFilter
+				}
+				if !p.consume("]") {
+					return nil, ErrQueryInvalidFilter
+				}
+				path = append(path, k)
+			} else {
+				idx, err := strconv.ParseUint(strings.TrimSpace(p.s[p.pos:p.pos+end]), 10, 31)
+				if err != nil {
+					return nil, ErrQueryInvalidFilter
+				}
+				p.pos += end + 1
+				path = append(path, int(idx))
+			}
+		default:
+			return
+		}
+	}
+	return
+}
+
+// compileQueryValueFilter parses a filter expression.
+// Expressions are limited in length and nesting depth since the parser and matching are recursive.
+func compileQueryValueFilter(expr string) (queryValueFilter, error) {
+	if len(expr) > queryFilterMaxLength {
+		return nil, ErrQueryInvalidFilter
+	}
+	p := queryExprParser{s: expr}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, ErrQueryInvalidFilter
+	}
+	return f, nil
+}
+
+// compileJSONPath parses a path for a projection.
+func compileJSONPath(expr string) (jsonPath, error) {
+	if len(expr) > queryFilterMaxLength {
+		return nil, ErrQueryInvalidFilter
+	}
+	p := queryExprParser{s: expr}
+	path, err := p.parsePath()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos != len(p.s) {
+		return nil, ErrQueryInvalidFilter
+	}
+	return path, nil
+}
+
+// decodeQueryValue decodes a record value as a JSON document, returning false if it is not valid JSON.
+func decodeQueryValue(v []byte) (doc interface{}, ok bool) {
+	if len(v) == 0 {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(v))
+	if dec.Decode(&doc) != nil || dec.More() {
+		return nil, false
+	}
+	return doc, true
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"crypto/x509"
+	"math"
+	"sort"
+)
+
+const (
+	// QueryTrustPolicyDefault blends local and oracle trust and slightly penalizes unsigned records if the network has auth certificates.
+	QueryTrustPolicyDefault = "default"
+
+	// QueryTrustPolicyCertFirst ranks records signed by a valid owner certificate above all unsigned records.
+	QueryTrustPolicyCertFirst = "cert-first"
+
+	// QueryTrustPolicyCA only trusts records whose owner certificate was issued by one of the CAs listed in the policy.
+	QueryTrustPolicyCA = "ca"
+
+	// QueryTrustPolicyWeightThreshold only trusts records whose weight is at least the policy's minimum weight.
+	QueryTrustPolicyWeightThreshold = "weight-threshold"
+
+	// QueryTrustPolicyOldestClaim ranks the oldest trusted record for a set of selectors first.
+	QueryTrustPolicyOldestClaim = "oldest-claim-wins"
+
+	// QueryTrustPolicyBlend computes trust as a weighted blend of trust components using caller-supplied coefficients.
+	QueryTrustPolicyBlend = "blend"
+)
+
+// Trust component names used in QueryResult.TrustComponents and as QueryTrustPolicy.Blend keys.
+const (
+	QueryTrustComponentLocal     = "local"     // Local node's reputation for the record
+	QueryTrustComponentOracle    = "oracle"    // Fraction of oracles that did not complain about the record, net of endorsements
+	QueryTrustComponentSignature = "signature" // Whether the record is signed by a current owner certificate
+	QueryTrustComponentWeight    = "weight"    // Record weight relative to the heaviest record in the result
+	QueryTrustComponentAge       = "age"       // Record age rank within the result (oldest is 1)
+	QueryTrustComponentPenalty   = "penalty"   // Reductions applied by the policy (always zero or negative)
+)
+
+// QueryTrustPolicy (request, part of Query) selects and parameterizes how trust is computed for query results.
+// Each policy breaks trust down into components whose contributions add up to the final trust value and
+// are returned in QueryResult.TrustComponents.
+type QueryTrustPolicy struct {
+	Name      string             `json:",omitempty"` // Policy name (default: QueryTrustPolicyDefault)
+	CAs       []string           `json:",omitempty"` // Base62 serial numbers of trusted auth CAs (for QueryTrustPolicyCA)
+	MinWeight *QueryResultWeight `json:",omitempty"` // Minimum record weight (for QueryTrustPolicyWeightThreshold)
+	Blend     map[string]float64 `json:",omitempty"` // Coefficients by trust component name (for QueryTrustPolicyBlend)
+}
+
+// Oracle verdicts in QueryOracleVerdict.
+const (
+	QueryOracleVerdictNone     = "none"     // Oracle said nothing about the record or its owner
+	QueryOracleVerdictNegative = "negative" // Oracle complained about the record or its owner
+	QueryOracleVerdictPositive = "positive" // Oracle endorsed the record or its owner
+	QueryOracleVerdictMixed    = "mixed"    // Oracle both complained about and endorsed the record or its owner
+)
+
+// QueryOracleVerdict (response, part of QueryResult) is what one query oracle said about a result.
+type QueryOracleVerdict struct {
+	Oracle  OwnerPublic // Oracle owner
+	Weight  float64     // Oracle's weight in this query
+	Verdict string      // Oracle's verdict on this result
+}
+
+// queryResultTrustInputs contains per-result inputs to trust computation that are not returned to clients.
+type queryResultTrustInputs struct {
+	idOwnerCRC64 uint64              // CRC64 of record ID and owner for looking up oracle comments
+	oracleTrust  float64             // 1.0 minus net weighted fraction of oracles complaining, or 1.0 if no oracles
+	certs        []*x509.Certificate // Owner certificates that apply to this record
+}
+
+// check returns an error if this policy's name or parameters are not valid.
+func (p *QueryTrustPolicy) check() error {
+	switch p.Name {
+	case "", QueryTrustPolicyDefault, QueryTrustPolicyCertFirst, QueryTrustPolicyOldestClaim:
+		return nil
+	case QueryTrustPolicyCA:
+		if len(p.CAs) > 0 {
+			return nil
+		}
+	case QueryTrustPolicyWeightThreshold:
+		if p.MinWeight != nil {
+			return nil
+		}
+	case QueryTrustPolicyBlend:
+		total := 0.0
+		for c, w := range p.Blend {
+			switch c {
+			case QueryTrustComponentLocal, QueryTrustComponentOracle, QueryTrustComponentSignature, QueryTrustComponentWeight, QueryTrustComponentAge:
+			default:
+				return ErrQueryInvalidTrustPolicy
+			}
+			if w < 0.0 || math.IsNaN(w) || math.IsInf(w, 0) {
+				return ErrQueryInvalidTrustPolicy
+			}
+			total += w
+		}
+		if total > 0.0 {
+			return nil
+		}
+	}
+	return ErrQueryInvalidTrustPolicy
+}
+
+// queryOracleWeights checks a query's oracle weights and returns a weight for every oracle and their total.
+func queryOracleWeights(oracles []OwnerPublic, weights []float64) ([]float64, float64, error) {
+	if len(oracles) > dbMaxOracles {
+		return nil, 0.0, ErrQueryTooManyOracles
+	}
+	if len(weights) > len(oracles) {
+		return nil, 0.0, ErrQueryInvalidOracleWeights
+	}
+	ow := make([]float64, len(oracles))
+	total := 0.0
+	for i := range oracles {
+		ow[i] = 1.0
+		if i < len(weights) {
+			if math.IsNaN(weights[i]) || math.IsInf(weights[i], 0) || weights[i] < 0.0 {
+				return nil, 0.0, ErrQueryInvalidOracleWeights
+			}
+			ow[i] = weights[i]
+		}
+		total += ow[i]
+	}
+	return ow, total, nil
+}
+
+// queryOracleVerdict determines an oracle's verdict from its negative and positive comment counts.
+func queryOracleVerdict(negativeComments, positiveComments uint) string {
+	if negativeComments > 0 {
+		if positiveComments > 0 {
+			return QueryOracleVerdictMixed
+		}
+		return QueryOracleVerdictNegative
+	} else if positiveComments > 0 {
+		return QueryOracleVerdictPositive
+	}
+	return QueryOracleVerdictNone
+}
+
+// queryOracleSlander computes the net weighted fraction of oracles complaining about a result.
+// Endorsements subtract from complaints, so this can be negative.
+func queryOracleSlander(verdicts []QueryOracleVerdict, totalWeight float64) float64 {
+	if totalWeight <= 0.0 {
+		return 0.0
+	}
+	net := 0.0
+	for _, v := range verdicts {
+		switch v.Verdict {
+		case QueryOracleVerdictNegative:
+			net += v.Weight
+		case QueryOracleVerdictPositive:
+			net -= v.Weight
+		}
+	}
+	return net / totalWeight
+}
+
+// weightFloat converts a 128-bit weight to a float for computing relative weights.
+func (a *QueryResultWeight) weightFloat() float64 {
+	return (float64(a[0]) * 79228162514264337593543950336.0) + (float64(a[1]) * 18446744073709551616.0) + (float64(a[2]) * 4294967296.0) + float64(a[3])
+}
+
+// apply computes Trust and TrustComponents for every result in a result set.
+// The inputs slice must be the same length and in the same order as qrSet.
+// The totalOracles argument is the total weight of the query's oracles.
+func (p *QueryTrustPolicy) apply(qrSet []QueryResult, inputs []queryResultTrustInputs, totalOracles float64, haveAuthCerts bool, authCerts map[string]*x509.Certificate) {
+	// Base trust is the default policy's blend of local and oracle trust. Other policies build on it.
+	base := make([]float64, len(qrSet))
+	for i := range qrSet {
+		qrSet[i].TrustComponents = make(map[string]float64)
+		if totalOracles > 0.0 {
+			qrSet[i].OracleTrust = inputs[i].oracleTrust
+			qrSet[i].TrustComponents[QueryTrustComponentLocal] = qrSet[i].LocalTrust / (totalOracles + 1.0)
+			qrSet[i].TrustComponents[QueryTrustComponentOracle] = (inputs[i].oracleTrust * totalOracles) / (totalOracles + 1.0)
+			base[i] = (qrSet[i].LocalTrust + (inputs[i].oracleTrust * totalOracles)) / (totalOracles + 1.0)
+		} else {
+			qrSet[i].TrustComponents[QueryTrustComponentLocal] = qrSet[i].LocalTrust
+			base[i] = qrSet[i].LocalTrust
+		}
+	}
+
+	switch p.Name {
+
+	case QueryTrustPolicyCertFirst:
+		// Base trust is scaled into the lower half and signed records get the upper half.
+		for i := range qrSet {
+			for c := range qrSet[i].TrustComponents {
+				qrSet[i].TrustComponents[c] *= 0.5
+			}
+			t := base[i] * 0.5
+			if qrSet[i].Signed {
+				qrSet[i].TrustComponents[QueryTrustComponentSignature] = 0.5
+				t += 0.5
+			}
+			qrSet[i].Trust = t
+		}
+
+	case QueryTrustPolicyCA:
+		for i := range qrSet {
+			qrSet[i].Trust = base[i]
+			if !p.signedByCA(inputs[i].certs, authCerts) {
+				qrSet[i].TrustComponents[QueryTrustComponentPenalty] = -base[i]
+				qrSet[i].Trust = 0.0
+			}
+		}
+
+	case QueryTrustPolicyWeightThreshold:
+		for i := range qrSet {
+			qrSet[i].Trust = base[i]
+			if qrSet[i].Weight.Compare(p.MinWeight) < 0 {
+				qrSet[i].TrustComponents[QueryTrustComponentPenalty] = -base[i]
+				qrSet[i].Trust = 0.0
+			}
+		}
+
+	case QueryTrustPolicyOldestClaim:
+		// Trusted records are ranked by age alone, oldest first. Untrusted records get nothing.
+		ages := queryResultAgeRanks(qrSet)
+		for i := range qrSet {
+			for c := range qrSet[i].TrustComponents {
+				delete(qrSet[i].TrustComponents, c)
+			}
+			if base[i] > 0.0 {
+				qrSet[i].TrustComponents[QueryTrustComponentAge] = ages[i]
+				qrSet[i].Trust = ages[i]
+			} else {
+				qrSet[i].TrustComponents[QueryTrustComponentAge] = 0.0
+				qrSet[i].Trust = 0.0
+			}
+		}
+
+	case QueryTrustPolicyBlend:
+		maxWeight := 0.0
+		for i := range qrSet {
+			maxWeight = math.Max(maxWeight, qrSet[i].Weight.weightFloat())
+		}
+		ages := queryResultAgeRanks(qrSet)
+		totalCoefficients := 0.0
+		for _, w := range p.Blend {
+			totalCoefficients += w
+		}
+		for i := range qrSet {
+			components := map[string]float64{
+				QueryTrustComponentLocal:  qrSet[i].LocalTrust,
+				QueryTrustComponentOracle: inputs[i].oracleTrust,
+				QueryTrustComponentAge:    ages[i],
+			}
+			if qrSet[i].Signed {
+				components[QueryTrustComponentSignature] = 1.0
+			} else {
+				components[QueryTrustComponentSignature] = 0.0
+			}
+			if maxWeight > 0.0 {
+				components[QueryTrustComponentWeight] = qrSet[i].Weight.weightFloat() / maxWeight
+			} else {
+				components[QueryTrustComponentWeight] = 0.0
+			}
+			t := 0.0
+			qrSet[i].TrustComponents = make(map[string]float64)
+			for c, w := range p.Blend {
+				contribution := (components[c] * w) / totalCoefficients
+				qrSet[i].TrustComponents[c] = contribution
+				t += contribution
+			}
+			qrSet[i].Trust = t
+		}
+
+	default: // QueryTrustPolicyDefault
+		for i := range qrSet {
+			qrSet[i].Trust = base[i]
+			// If this database has auth certs, penalize records that are not signed.
+			if haveAuthCerts && !qrSet[i].Signed {
+				qrSet[i].TrustComponents[QueryTrustComponentPenalty] = -(base[i] * 0.1)
+				qrSet[i].Trust *= 0.9
+			}
+		}
+
+	}
+}
+
+// signedByCA returns true if any of a record's certificates were validly issued by a CA in this policy's list.
+func (p *QueryTrustPolicy) signedByCA(certs []*x509.Certificate, authCerts map[string]*x509.Certificate) bool {
+	for _, caSerial := range p.CAs {
+		ca := authCerts[caSerial]
+		if ca == nil {
+			continue
+		}
+		for _, cert := range certs {
+			if verifyCertIssuer(cert, ca) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// queryResultAgeRanks returns a value from 1.0 (oldest) down toward 0.0 (newest) for each result in a set.
+func queryResultAgeRanks(qrSet []QueryResult) []float64 {
+	order := make([]int, len(qrSet))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return qrSet[order[a]].Record.Timestamp < qrSet[order[b]].Record.Timestamp
+	})
+	ranks := make([]float64, len(qrSet))
+	n := float64(len(qrSet))
+	for rank, i := range order {
+		ranks[i] = (n - float64(rank)) / n
+	}
+	return ranks
+}
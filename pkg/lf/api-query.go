@@ -16,6 +16,7 @@ package lf
 import (
 	"bytes"
 	"crypto/x509"
+	"encoding/json"
 	"hash/crc64"
 	"math"
 	"sort"
@@ -42,20 +43,28 @@ const trustSigDigits float64 = 10000000000.0 // rounding precision for comparing
 // proxy being queried.
 type QueryRange struct {
 	Name     Blob     `json:",omitempty"` // Name of selector (plain text)
+	Type     byte     `json:",omitempty"` // Type of selector if Name is used (default: SelectorTypeBP160)
 	Range    []uint64 `json:",omitempty"` // Ordinal value if [1] or range if [2] in size (single ordinal of value 0 if omitted)
 	KeyRange []Blob   `json:",omitempty"` // Selector key or key range, overrides Name and Range if present (allows queries without revealing name)
 }
 
 // Query (request) describes a query for records in the form of an ordered series of selector ranges.
 type Query struct {
-	Ranges     []QueryRange  `json:",omitempty"` // Selectors or selector range(s)
-	TimeRange  []uint64      `json:",omitempty"` // If present, constrain record times to after first value (if [1]) or range (if [2])
-	MaskingKey Blob          `json:",omitempty"` // Masking key to unmask record value(s) server-side (if non-empty)
-	Owners     []OwnerPublic `json:",omitempty"` // Restrict to these owners only
-	SortOrder  string        `json:",omitempty"` // Sort order within each result (default: trust)
-	Limit      *int          `json:",omitempty"` // If non-zero, limit maximum lower trust records per result
-	Open       *bool         `json:",omitempty"` // If true, include records with extra selectors not named in Ranges
-	Oracles    []OwnerPublic `json:",omitempty"` // Trust these oracles during trust computation
+	Ranges        []QueryRange      `json:",omitempty"` // Selectors or selector range(s)
+	TimeRange     []uint64          `json:",omitempty"` // If present, constrain record times to after first value (if [1]) or range (if [2])
+	MaskingKey    Blob              `json:",omitempty"` // Masking key to unmask record value(s) server-side (if non-empty)
+	Owners        []OwnerPublic     `json:",omitempty"` // Restrict to these owners only
+	SortOrder     string            `json:",omitempty"` // Sort order within each result (default: trust)
+	Limit         *int              `json:",omitempty"` // If non-zero, limit maximum lower trust records per result
+	Open          *bool             `json:",omitempty"` // If true, include records with extra selectors not named in Ranges
+	Oracles       []OwnerPublic     `json:",omitempty"` // Trust these oracles during trust computation (at most 64)
+	OracleWeights []float64         `json:",omitempty"` // Weights of Oracles in the same order (default: 1.0 for each)
+	TrustPolicy   *QueryTrustPolicy `json:",omitempty"` // Policy for computing trust (default: QueryTrustPolicyDefault)
+	Explain       *bool             `json:",omitempty"` // If true, attach diagnostics and filtered candidates to each result
+	Filter        string            `json:",omitempty"` // Filter expression for JSON values (needs a masking key, see api-query-filter.go)
+	Project       string            `json:",omitempty"` // Path of sub-document of JSON values to return as Projection (needs a masking key)
+	OmitRecord    *bool             `json:",omitempty"` // If true, omit Record from results
+	OmitValue     *bool             `json:",omitempty"` // If true, omit Value from results
 }
 
 // QueryResultWeight is a 128-bit value broken into four 32-bit valu
@@ -88,16 +97,20 @@ func (a *QueryResultWeight) Compare(b *QueryResultWeight) int {
 
 // QueryResult is a single query result.
 type QueryResult struct {
-	Hash        HashBlob          ``                  // Hash of this specific unique record
-	Size        int               ``                  // Size of this record in bytes
-	Record      *Record           `json:",omitempty"` // Record itself.
-	Value       Blob              `json:",omitempty"` // Unmasked value if masking key was included and valid
-	Pulse       uint64            ``                  // Timestamp plus current pulse value
-	Trust       float64           ``                  // Trust metric computed using local and oracle trust (if the latter is elected)
-	LocalTrust  float64           ``                  // Local trust only
-	OracleTrust float64           ``                  // Oracle trust only
-	Weight      QueryResultWeight `json:",omitempty"` // Record weight as a 128-bit big-endian value decomposed into 4 32-bit integers
-	Signed      bool              ``                  // If true, record's owner is signed and cert's timestamps match this record
+	Hash            HashBlob                ``                  // Hash of this specific unique record
+	Size            int                     ``                  // Size of this record in bytes
+	Record          *Record                 `json:",omitempty"` // Record itself.
+	Value           Blob                    `json:",omitempty"` // Unmasked value if masking key was included and valid
+	Pulse           uint64                  ``                  // Timestamp plus current pulse value
+	Trust           float64                 ``                  // Trust metric computed using local and oracle trust (if the latter is elected)
+	LocalTrust      float64                 ``                  // Local trust only
+	OracleTrust     float64                 ``                  // Oracle trust only
+	Weight          QueryResultWeight       `json:",omitempty"` // Record weight as a 128-bit big-endian value decomposed into 4 32-bit integers
+	Signed          bool                    ``                  // If true, record's owner is signed and cert's timestamps match this record
+	TrustComponents map[string]float64      `json:",omitempty"` // Contribution of each trust component to Trust under the query's trust policy
+	OracleVerdicts  []QueryOracleVerdict    `json:",omitempty"` // What each query oracle said about this record or its owner
+	Explain         *QueryResultExplanation `json:",omitempty"` // Diagnostics if Explain was set in query
+	Projection      json.RawMessage         `json:",omitempty"` // Sub-document of value selected by query's Project path
 }
 
 // QueryResults is a list of results to a query.
@@ -110,7 +123,8 @@ type apiQueryResultTmp struct {
 	weightL, weightH, doff, dlen uint64
 	ts                           int64
 	localReputation              int
-	negativeComments             uint
+	excludedOwner                bool
+	verdicts                     []QueryOracleVerdict
 }
 
 func (m *Query) execute(n *Node) (qr QueryResults, err error) {
@@ -119,34 +133,45 @@ func (m *Query) execute(n *Node) (qr QueryResults, err error) {
 	if len(mm) == 0 {
 		return nil, ErrQueryRequiresSelectors
 	}
-	maskingKey := m.MaskingKey
-	var selectorRanges [][2][]byte
-	for i := 0; i < len(mm); i++ {
-		if len(mm[i].KeyRange) == 0 {
-			// If KeyRange is not used the selectors' names are specified in the clear and we generate keys locally.
-			if len(maskingKey) == 0 && i == 0 {
-				maskingKey = mm[i].Name
-			}
-			if len(mm[i].Range) == 0 {
-				ss := MakeSelectorKey(mm[i].Name, 0)
-				selectorRanges = append(selectorRanges, [2][]byte{ss[:], ss[:]})
-			} else if len(mm[i].Range) == 1 {
-				ss := MakeSelectorKey(mm[i].Name, mm[i].Range[0])
-				selectorRanges = append(selectorRanges, [2][]byte{ss[:], ss[:]})
-			} else if len(mm[i].Range) == 2 {
-				ss := MakeSelectorKey(mm[i].Name, mm[i].Range[0])
-				ee := MakeSelectorKey(mm[i].Name, mm[i].Range[1])
-				selectorRanges = append(selectorRanges, [2][]byte{ss[:], ee[:]})
+	trustPolicy := m.TrustPolicy
+	if trustPolicy == nil {
+		trustPolicy = &QueryTrustPolicy{Name: QueryTrustPolicyDefault}
+	} else if err := trustPolicy.check(); err != nil {
+		return nil, err
+	}
+	var valueFilter queryValueFilter
+	var projectPath jsonPath
+	if len(m.Filter) > 0 || len(m.Project) > 0 {
+		if len(m.Filter) > 0 {
+			if valueFilter, err = compileQueryValueFilter(m.Filter); err != nil {
+				return nil, err
 			}
-		} else {
-			// Otherwise we use the sender-supplied key range which keeps names secret.
-			if len(mm[i].KeyRange) == 1 {
-				selectorRanges = append(selectorRanges, [2][]byte{mm[i].KeyRange[0], mm[i].KeyRange[0]})
-			} else if len(mm[i].KeyRange) == 2 {
-				selectorRanges = append(selectorRanges, [2][]byte{mm[i].KeyRange[0], mm[i].KeyRange[1]})
+		}
+		if len(m.Project) > 0 {
+			if projectPath, err = compileJSONPath(m.Project); err != nil {
+				return nil, err
 			}
 		}
 	}
+	oracleWeights, totalOracles, err := queryOracleWeights(m.Oracles, m.OracleWeights)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.SortOrder) > 0 && m.SortOrder != QuerySortOrderTrust && m.SortOrder != QuerySortOrderWeight && m.SortOrder != QuerySortOrderTimestamp {
+		return nil, ErrQueryInvalidSortOrder
+	}
+	maskingKey := m.MaskingKey
+	if len(maskingKey) == 0 && len(mm[0].KeyRange) == 0 {
+		// If KeyRange is not used the selectors' names are specified in the clear and the first one is the default masking key.
+		maskingKey = mm[0].Name
+	}
+	if (valueFilter != nil || projectPath != nil) && len(maskingKey) == 0 {
+		return nil, ErrQueryFilterRequiresMaskingKey
+	}
+	selectorRanges, err := querySelectorKeyRanges(mm)
+	if err != nil {
+		return nil, err
+	}
 	if len(selectorRanges) == 0 {
 		return nil, ErrQueryRequiresSelectors
 	}
@@ -167,9 +192,11 @@ func (m *Query) execute(n *Node) (qr QueryResults, err error) {
 		tsMax = int64(9223372036854775807)
 	}
 
-	// Get all results grouped by selector composite key.
+	explain := m.Explain != nil && *m.Explain
+
+	// Get all results grouped by selector composite key along with what each oracle said about them.
 	bySelectorKey := make(map[uint64]*[]apiQueryResultTmp)
-	_ = n.db.query(selectorRanges, m.Oracles, func(ts, weightL, weightH, doff, dlen uint64, localReputation int, ckey uint64, owner []byte, negativeComments uint) bool {
+	_ = n.db.query(selectorRanges, m.Oracles, func(ts, weightL, weightH, doff, dlen uint64, localReputation int, ckey uint64, owner []byte, negativeOracles, positiveOracles uint64) bool {
 		includeOwner := true
 		if len(m.Owners) > 0 {
 			includeOwner = false
@@ -180,31 +207,50 @@ func (m *Query) execute(n *Node) (qr QueryResults, err error) {
 				}
 			}
 		}
-		if includeOwner {
+		if includeOwner || explain {
 			rptr := bySelectorKey[ckey]
 			if rptr == nil {
 				tmp := make([]apiQueryResultTmp, 0, 4)
 				rptr = &tmp
 				bySelectorKey[ckey] = rptr
 			}
-			*rptr = append(*rptr, apiQueryResultTmp{weightL, weightH, doff, dlen, int64(ts), localReputation, negativeComments})
+			var verdicts []QueryOracleVerdict
+			for oi := range m.Oracles {
+				verdicts = append(verdicts, QueryOracleVerdict{
+					Oracle:  m.Oracles[oi],
+					Weight:  oracleWeights[oi],
+					Verdict: queryOracleVerdict(uint((negativeOracles>>uint(oi))&1), uint((positiveOracles>>uint(oi))&1)),
+				})
+			}
+			*rptr = append(*rptr, apiQueryResultTmp{weightL, weightH, doff, dlen, int64(ts), localReputation, !includeOwner, verdicts})
 		}
 		return true
 	})
 
 	// Actually grab the records and populate the qr[] slice. Also compute
-	// oracle trust per ID/owner combo.
+	// oracle trust per ID/owner combo. Candidates that are filtered out are
+	// kept in qrFiltered if the query asked for an explanation. Results with
+	// no included candidates are left out whether or not it did.
 	slanderByIDOwner := make(map[uint64]float64)
-	totalOracles := float64(len(m.Oracles))
-	ownerCertCache := make(map[uint64][]*x509.Certificate)
-	var qrIDOwnerCRC64s [][]uint64
+	ownerCertCache := make(map[uint64][2][]*x509.Certificate)
+	var qrTrustInputs [][]queryResultTrustInputs
+	var qrFiltered [][]QueryResult
 	for _, rptr := range bySelectorKey {
+		var qrSet, qrSetFiltered []QueryResult
+		var qrSetTrustInputs []queryResultTrustInputs
+
 		// Collate results and add to query result
 		for rn := 0; rn < len(*rptr); rn++ {
 			result := &(*rptr)[rn]
 
-			if result.ts < tsMin || result.ts > tsMax {
-				continue
+			filter := ""
+			if result.excludedOwner {
+				filter = QueryFilterOwner
+			} else if result.ts < tsMin || result.ts > tsMax {
+				if !explain {
+					continue
+				}
+				filter = QueryFilterTimeRange
 			}
 
 			rdata, err := n.db.getDataByOffset(result.doff, uint(result.dlen), nil)
@@ -216,26 +262,58 @@ func (m *Query) execute(n *Node) (qr QueryResults, err error) {
 				return nil, err
 			}
 
-			if len(rec.Selectors) != len(selectorRanges) && (m.Open == nil || !*m.Open) {
-				continue
+			if len(filter) == 0 && len(rec.Selectors) != len(selectorRanges) && (m.Open == nil || !*m.Open) {
+				if !explain {
+					continue
+				}
+				filter = QueryFilterOpen
 			}
 
 			// Get owner certs and check whether any non-revoked certs apply to this record.
 			ownerC64 := crc64.Checksum(rec.Owner, crc64ECMATable)
 			ownerCerts, haveCachedOwnerCerts := ownerCertCache[ownerC64]
 			if !haveCachedOwnerCerts {
-				ownerCerts, _, _ = n.GetOwnerCertificates(rec.Owner)
+				ownerCerts[0], ownerCerts[1], _ = n.GetOwnerCertificates(rec.Owner)
 				ownerCertCache[ownerC64] = ownerCerts
 			}
-			recordIsSigned := false
-			for _, cert := range ownerCerts {
+			var recordCerts []*x509.Certificate
+			for _, cert := range ownerCerts[0] {
 				if rec.Timestamp >= uint64(cert.NotBefore.Unix()) && rec.Timestamp <= uint64(cert.NotAfter.Unix()) {
-					recordIsSigned = true
-					break
+					recordCerts = append(recordCerts, cert)
 				}
 			}
-			if !recordIsSigned && (n.genesisParameters.AuthRequired || !rec.ValidateWork()) && !n.localTest {
-				continue
+			recordIsSigned := len(recordCerts) > 0
+			workValid := true
+			if !recordIsSigned || explain {
+				workValid = rec.ValidateWork()
+			}
+			if len(filter) == 0 && !recordIsSigned && !n.localTest {
+				if n.genesisParameters.AuthRequired {
+					filter = QueryFilterAuthRequired
+				} else if !workValid {
+					filter = QueryFilterWork
+				}
+				if len(filter) > 0 && !explain {
+					continue
+				}
+			}
+
+			// Decode JSON value if it's needed to apply a value filter or projection.
+			v, _ := rec.GetValue(maskingKey)
+			var projection json.RawMessage
+			if valueFilter != nil || projectPath != nil {
+				doc, isJSON := decodeQueryValue(v)
+				if valueFilter != nil && len(filter) == 0 && (!isJSON || !valueFilter.match(doc)) {
+					if !explain {
+						continue
+					}
+					filter = QueryFilterValue
+				}
+				if projectPath != nil && isJSON {
+					if pv, ok := projectPath.get(doc); ok {
+						projection, _ = json.Marshal(pv)
+					}
+				}
 			}
 
 			// Compute local trust
@@ -244,24 +322,21 @@ func (m *Query) execute(n *Node) (qr QueryResults, err error) {
 				localTrust = float64(result.localReputation) / float64(dbReputationDefault)
 			}
 
-			// Compute oracle trust by determining the max fraction of oracles
-			// that said something bad about a record with this ID/owner combo.
-			if len(m.Oracles) > 0 {
+			// Compute oracle trust by determining the max net weighted fraction of
+			// oracles that said something bad about a record with this ID/owner combo.
+			// Endorsements offset complaints but can't raise oracle trust above 1.0.
+			verdicts := result.verdicts
+			var idOwnerC64 uint64
+			if totalOracles > 0.0 && len(filter) == 0 {
 				c64 := crc64.New(crc64ECMATable)
 				recID := rec.ID()
 				_, _ = c64.Write(recID[:])
 				_, _ = c64.Write(rec.Owner)
-				idOwnerC64 := c64.Sum64()
-				slander := float64(result.negativeComments) / totalOracles
+				idOwnerC64 = c64.Sum64()
+				slander := queryOracleSlander(verdicts, totalOracles)
 				if slander > slanderByIDOwner[idOwnerC64] {
 					slanderByIDOwner[idOwnerC64] = slander
 				}
-
-				if rn == 0 {
-					qrIDOwnerCRC64s = append(qrIDOwnerCRC64s, []uint64{idOwnerC64})
-				} else if len(qrIDOwnerCRC64s) > 0 {
-					qrIDOwnerCRC64s[len(qrIDOwnerCRC64s)-1] = append(qrIDOwnerCRC64s[len(qrIDOwnerCRC64s)-1], idOwnerC64)
-				}
 			}
 
 			var weight [4]uint32
@@ -270,87 +345,109 @@ func (m *Query) execute(n *Node) (qr QueryResults, err error) {
 			weight[2] = uint32(result.weightL >> 32)
 			weight[3] = uint32(result.weightL)
 
-			v, _ := rec.GetValue(maskingKey)
 			pulse := n.db.getPulse(rec.recordBody.PulseToken) * 60 // pulse is in a resolution of minutes
 
-			if rn == 0 {
-				qr = append(qr, []QueryResult{{
-					Hash:        rec.Hash(),
-					Size:        int(result.dlen),
-					Record:      rec,
-					Value:       v,
-					Pulse:       rec.recordBody.Timestamp + pulse,
-					Trust:       localTrust,
-					LocalTrust:  localTrust,
-					OracleTrust: localTrust,
-					Weight:      weight,
-					Signed:      recordIsSigned,
-				}})
-			} else if len(qr) > 0 {
-				qr[len(qr)-1] = append(qr[len(qr)-1], QueryResult{
-					Hash:        rec.Hash(),
-					Size:        int(result.dlen),
-					Record:      rec,
-					Value:       v,
-					Pulse:       rec.recordBody.Timestamp + pulse,
-					Trust:       localTrust,
-					LocalTrust:  localTrust,
-					OracleTrust: localTrust,
-					Weight:      weight,
-					Signed:      recordIsSigned,
-				})
+			res := QueryResult{
+				Hash:        rec.Hash(),
+				Size:        int(result.dlen),
+				Record:      rec,
+				Value:       v,
+				Pulse:       rec.recordBody.Timestamp + pulse,
+				Trust:       localTrust,
+				LocalTrust:  localTrust,
+				OracleTrust: localTrust,
+				Weight:      weight,
+				Signed:      recordIsSigned,
+				Projection:  projection,
+			}
+			if len(verdicts) > 0 {
+				res.OracleVerdicts = verdicts
+			}
+			if explain {
+				res.Explain = &QueryResultExplanation{
+					Filter:          filter,
+					LocalReputation: result.localReputation,
+					CertStatus:      queryCertStatus(rec, ownerCerts[0], ownerCerts[1]),
+					WorkValid:       workValid,
+				}
+				for _, v := range verdicts {
+					if v.Verdict == QueryOracleVerdictNegative || v.Verdict == QueryOracleVerdictMixed {
+						res.Explain.NegativeComments++
+						res.Explain.ComplainingOracles = append(res.Explain.ComplainingOracles, v.Oracle)
+					}
+					if v.Verdict == QueryOracleVerdictPositive || v.Verdict == QueryOracleVerdictMixed {
+						res.Explain.PositiveComments++
+						res.Explain.EndorsingOracles = append(res.Explain.EndorsingOracles, v.Oracle)
+					}
+				}
 			}
+			if len(filter) == 0 {
+				qrSet = append(qrSet, res)
+				qrSetTrustInputs = append(qrSetTrustInputs, queryResultTrustInputs{idOwnerCRC64: idOwnerC64, certs: recordCerts})
+			} else {
+				res.Trust = 0.0
+				res.LocalTrust = 0.0
+				res.OracleTrust = 0.0
+				qrSetFiltered = append(qrSetFiltered, res)
+			}
+		}
+
+		if len(qrSet) > 0 {
+			qr = append(qr, qrSet)
+			qrTrustInputs = append(qrTrustInputs, qrSetTrustInputs)
+			qrFiltered = append(qrFiltered, qrSetFiltered)
 		}
 	}
 
 	authCerts, _ := n.genesisParameters.GetAuthCertificates()
 	haveAuthCerts := len(authCerts) > 0
 
-	// Compute final trust and sort within each result.
-	if len(qrIDOwnerCRC64s) > 0 {
-		for qrSetIdx, qrSet := range qr {
-			// Compute oracle trust and overall trust as a function of local and oracle trust if there are oracles.
-			if len(m.Oracles) > 0 {
-				for qrSetResultIdx := range qrSet {
-					oracleTrust := math.Max(1.0-slanderByIDOwner[qrIDOwnerCRC64s[qrSetIdx][qrSetResultIdx]], 0.0)
-					qrSet[qrSetResultIdx].Trust = (qrSet[qrSetResultIdx].LocalTrust + (oracleTrust * totalOracles)) / (totalOracles + 1.0)
-					qrSet[qrSetResultIdx].OracleTrust = oracleTrust
-				}
+	// Compute final trust using the query's trust policy and sort within each result.
+	for qrSetIdx, qrSet := range qr {
+		inputs := qrTrustInputs[qrSetIdx]
+		for qrSetResultIdx := range inputs {
+			if totalOracles > 0.0 {
+				inputs[qrSetResultIdx].oracleTrust = math.Max(1.0-slanderByIDOwner[inputs[qrSetResultIdx].idOwnerCRC64], 0.0)
+			} else {
+				inputs[qrSetResultIdx].oracleTrust = 1.0
 			}
+		}
+		trustPolicy.apply(qrSet, inputs, totalOracles, haveAuthCerts, authCerts)
 
-			// If this database has auth certs, penalize records that are not signed.
-			if haveAuthCerts {
-				for qrSetResultIdx := range qrSet {
-					if !qrSet[qrSetResultIdx].Signed {
-						qrSet[qrSetResultIdx].Trust *= 0.9
-					}
+		if len(m.SortOrder) == 0 || m.SortOrder == QuerySortOrderTrust {
+			sort.Slice(qrSet, func(b, a int) bool {
+				if qrSet[a].Trust < qrSet[b].Trust {
+					return true
+				} else if uint64(qrSet[a].Trust*trustSigDigits) == uint64(qrSet[b].Trust*trustSigDigits) {
+					return qrSet[a].Weight.Compare(&qrSet[b].Weight) < 0
 				}
-			}
+				return false
+			})
+		} else if m.SortOrder == QuerySortOrderWeight {
+			sort.Slice(qrSet, func(b, a int) bool {
+				return qrSet[a].Weight.Compare(&qrSet[b].Weight) < 0
+			})
+		} else if m.SortOrder == QuerySortOrderTimestamp {
+			sort.Slice(qrSet, func(b, a int) bool {
+				return qrSet[a].Record.Timestamp < qrSet[b].Record.Timestamp
+			})
+		}
 
-			if len(m.SortOrder) == 0 || m.SortOrder == QuerySortOrderTrust {
-				sort.Slice(qrSet, func(b, a int) bool {
-					if qrSet[a].Trust < qrSet[b].Trust {
-						return true
-					} else if uint64(qrSet[a].Trust*trustSigDigits) == uint64(qrSet[b].Trust*trustSigDigits) {
-						return qrSet[a].Weight.Compare(&qrSet[b].Weight) < 0
-					}
-					return false
-				})
-			} else if m.SortOrder == QuerySortOrderWeight {
-				sort.Slice(qrSet, func(b, a int) bool {
-					return qrSet[a].Weight.Compare(&qrSet[b].Weight) < 0
-				})
-			} else if m.SortOrder == QuerySortOrderTimestamp {
-				sort.Slice(qrSet, func(b, a int) bool {
-					return qrSet[a].Record.Timestamp < qrSet[b].Record.Timestamp
-				})
-			} else {
-				return nil, ErrQueryInvalidSortOrder
+		var excluded []QueryResult
+		if m.Limit != nil && *m.Limit > 0 && len(qrSet) > *m.Limit {
+			if explain {
+				excluded = append(excluded, qrSet[*m.Limit:]...)
+				for i := range excluded {
+					excluded[i].Explain.Filter = QueryFilterLimit
+				}
 			}
+			qr[qrSetIdx] = qrSet[0:*m.Limit]
+		}
 
-			if m.Limit != nil && *m.Limit > 0 && len(qrSet) > *m.Limit {
-				qr[qrSetIdx] = qrSet[0:*m.Limit]
-			}
+		// When explaining, filtered candidates are attached to the top included result.
+		excluded = append(excluded, qrFiltered[qrSetIdx]...)
+		if len(excluded) > 0 {
+			qrSet[0].Explain.Excluded = excluded
 		}
 	}
 
@@ -375,5 +472,60 @@ func (m *Query) execute(n *Node) (qr QueryResults, err error) {
 		return false
 	})
 
+	// Strip records and/or values last since the above needs records.
+	if (m.OmitRecord != nil && *m.OmitRecord) || (m.OmitValue != nil && *m.OmitValue) {
+		strip := func(qrSet []QueryResult) {
+			for i := range qrSet {
+				if m.OmitRecord != nil && *m.OmitRecord {
+					qrSet[i].Record = nil
+				}
+				if m.OmitValue != nil && *m.OmitValue {
+					qrSet[i].Value = nil
+				}
+			}
+		}
+		for _, qrSet := range qr {
+			strip(qrSet)
+			if qrSet[0].Explain != nil {
+				strip(qrSet[0].Explain.Excluded)
+			}
+		}
+	}
+
+	return
+}
+
+// querySelectorKeyRanges computes the selector key ranges for a query (also used by VerifyingNode to check results).
+func querySelectorKeyRanges(mm []QueryRange) (selectorRanges [][2][]byte, err error) {
+	for i := 0; i < len(mm); i++ {
+		if len(mm[i].KeyRange) == 0 {
+			var ord0, ord1 uint64
+			if len(mm[i].Range) > 2 {
+				continue
+			} else if len(mm[i].Range) == 1 {
+				ord0, ord1 = mm[i].Range[0], mm[i].Range[0]
+			} else if len(mm[i].Range) == 2 {
+				ord0, ord1 = mm[i].Range[0], mm[i].Range[1]
+			}
+			ss, err := MakeSelectorKeyWithType(mm[i].Type, mm[i].Name, ord0)
+			if err != nil {
+				return nil, err
+			}
+			ee := ss
+			if ord1 != ord0 {
+				ee, err = MakeSelectorKeyWithType(mm[i].Type, mm[i].Name, ord1)
+				if err != nil {
+					return nil, err
+				}
+			}
+			selectorRanges = append(selectorRanges, [2][]byte{ss, ee})
+		} else {
+			if len(mm[i].KeyRange) == 1 {
+				selectorRanges = append(selectorRanges, [2][]byte{mm[i].KeyRange[0], mm[i].KeyRange[0]})
+			} else if len(mm[i].KeyRange) == 2 {
+				selectorRanges = append(selectorRanges, [2][]byte{mm[i].KeyRange[0], mm[i].KeyRange[1]})
+			}
+		}
+	}
 	return
 }
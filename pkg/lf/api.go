@@ -33,9 +33,10 @@ type OwnerStatus struct {
 
 // Peer contains information about a peer
 type Peer struct {
-	IP       net.IP //
-	Port     int    // -1 indicates inbound TCP connection with unknown/unreachable port
-	Identity Blob   //
+	IP       net.IP ``                  //
+	Zone     string `json:",omitempty"` // IPv6 scope zone (interface) for link-local addresses
+	Port     int    ``                  // -1 indicates inbound TCP connection with unknown/unreachable port
+	Identity Blob   ``                  //
 }
 
 // NodeStatus contains status information about this node and the network it belongs to.
@@ -84,12 +85,29 @@ type LF interface {
 	// ExecuteQuery runs this query against this node.
 	ExecuteQuery(*Query) (QueryResults, error)
 
+	// ExecuteQueryBatch runs a batch of independent queries against this node.
+	// Results are returned in the same order as the queries and contain either results or an error.
+	ExecuteQueryBatch(QueryBatch) ([]QueryBatchResult, error)
+
 	// ExecuteMakeRecord runs a MakeRecordRequest against this node.
 	ExecuteMakeRecord(*MakeRecord) (*Record, Pulse, bool, error)
 
 	// ExecuteMakePulse runs a MakePulseRequest against this node.
 	ExecuteMakePulse(*MakePulse) (Pulse, *Record, bool, error)
 
+	// ExecuteMakeWork asks this node to compute proof of work for a record built elsewhere.
+	ExecuteMakeWork(*MakeWork) (*MakeWorkResult, error)
+
+	// ExecuteEstimate estimates the proof of work required for a record and how long it would take on this node.
+	ExecuteEstimate(*Estimate) (*EstimateResult, error)
+
+	// AddComment queues a comment for publication by this node's oracle.
+	// Remote nodes only accept comments from localhost, and the node must have commentary enabled.
+	AddComment(*Comment) error
+
+	// GetComments gets comments by this node's oracle and any other listed oracles about a record or owner.
+	GetComments(*CommentQuery) ([]Comment, error)
+
 	// DoPulse processes a pulse, also announcing it to the global network if the second boolean is true (usually should be true).
 	// This returns true if the pulse was accepted as novel and valid.
 	DoPulse(Pulse, bool) (bool, error)
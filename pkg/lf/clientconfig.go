@@ -43,10 +43,33 @@ func (co *ClientConfigOwner) GetOwner() (o *Owner, err error) {
 
 // ClientConfig is the JSON format for the client configuration file.
 type ClientConfig struct {
-	URLs    []RemoteNode                  ``         // Remote nodes
-	Oracles []OwnerPublic                 ``         // Oracles to trust during queries
-	Owners  map[string]*ClientConfigOwner ``         // Owners by name
-	Dirty   bool                          `json:"-"` // Non-persisted flag that can be used to indicate the config should be saved on client exit
+	URLs          []RemoteNode                  ``                  // Remote nodes
+	Oracles       []OwnerPublic                 ``                  // Oracles to trust during queries
+	OracleWeights map[string]float64            `json:",omitempty"` // Weights of oracles by @owner string (default: 1.0)
+	Owners        map[string]*ClientConfigOwner ``                  // Owners by name
+	TLS           *RemoteNodeTLSConfig          `json:",omitempty"` // Root CAs and client certificate for https and https+mtls URLs
+	Dirty         bool                          `json:"-"`          // Non-persisted flag that can be used to indicate the config should be saved on client exit
+}
+
+// OracleWeight returns the configured weight of an oracle or 1.0 if it has no configured weight.
+func (c *ClientConfig) OracleWeight(oracle OwnerPublic) float64 {
+	if w, ok := c.OracleWeights[oracle.String()]; ok {
+		return w
+	}
+	return 1.0
+}
+
+// QueryOracleWeights returns weights for Oracles in the same order for use in Query.OracleWeights.
+// It returns nil if no oracle has a configured weight so queries remain compatible with older nodes.
+func (c *ClientConfig) QueryOracleWeights() []float64 {
+	if len(c.OracleWeights) == 0 {
+		return nil
+	}
+	weights := make([]float64, len(c.Oracles))
+	for i, o := range c.Oracles {
+		weights[i] = c.OracleWeight(o)
+	}
+	return weights
 }
 
 // Load loads this client config from disk or initializes it with defaults if load fails.
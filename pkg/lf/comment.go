@@ -21,7 +21,11 @@ import (
 // They must also match the defines in db.h where relevant.
 const (
 	commentAssertionNil                         byte = 0
-	commentAssertionRecordCollidesWithClaimedID byte = 1
+	commentAssertionRecordCollidesWithClaimedID byte = 1 // Subject is a record hash (negative)
+	commentAssertionRecordIsSpam                byte = 2 // Subject is a record hash (negative)
+	commentAssertionOwnerIsMalicious            byte = 3 // Subject is an owner public key (negative)
+	commentAssertionOwnerVouchedFor             byte = 4 // Subject is an owner public key (positive)
+	commentAssertionRecordIsCanonicalForID      byte = 5 // Subject is a record hash (positive)
 
 	commentReasonNone                 byte = 0 // No reason given
 	commentReasonAutomaticallyFlagged byte = 1 // Issue detected automatically
@@ -52,11 +56,40 @@ func (c *comment) string() string {
 		return "nil"
 	case commentAssertionRecordCollidesWithClaimedID:
 		return fmt.Sprintf("=%s collides with previously claimed ID (%s)", Base62Encode(c.subject), reason)
+	case commentAssertionRecordIsSpam:
+		return fmt.Sprintf("=%s is spam (%s)", Base62Encode(c.subject), reason)
+	case commentAssertionOwnerIsMalicious:
+		return fmt.Sprintf("@%s is malicious (%s)", Base62Encode(c.subject), reason)
+	case commentAssertionOwnerVouchedFor:
+		return fmt.Sprintf("@%s is vouched for (%s)", Base62Encode(c.subject), reason)
+	case commentAssertionRecordIsCanonicalForID:
+		return fmt.Sprintf("=%s is canonical for its ID (%s)", Base62Encode(c.subject), reason)
 	}
 
 	return fmt.Sprintf("unknown assertion %.2x subject %x reason %.2x", c.assertion, c.subject, c.reason)
 }
 
+// subjectIsOwner returns true if this comment's subject is an owner rather than a record.
+func (c *comment) subjectIsOwner() bool {
+	return c.assertion == commentAssertionOwnerIsMalicious || c.assertion == commentAssertionOwnerVouchedFor
+}
+
+// positive returns true if this comment's assertion speaks in favor of its subject.
+func (c *comment) positive() bool {
+	return c.assertion == commentAssertionOwnerVouchedFor || c.assertion == commentAssertionRecordIsCanonicalForID
+}
+
+// valid returns true if this comment's assertion is known and its subject has the right format.
+func (c *comment) valid() bool {
+	switch c.assertion {
+	case commentAssertionRecordCollidesWithClaimedID, commentAssertionRecordIsSpam, commentAssertionRecordIsCanonicalForID:
+		return len(c.subject) == 32
+	case commentAssertionOwnerIsMalicious, commentAssertionOwnerVouchedFor:
+		return OwnerPublic(c.subject).Type() != 0
+	}
+	return false
+}
+
 func (c *comment) sizeBytes() int {
 	return 3 + len(c.subject)
 }
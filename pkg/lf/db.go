@@ -46,6 +46,7 @@ import (
 
 const (
 	dbMaxOwnerSize       int = C.ZTLF_DB_QUERY_MAX_OWNER_SIZE
+	dbMaxOracles         int = C.ZTLF_DB_QUERY_MAX_ORACLES
 	dbMaxConfigValueSize int = 1048576
 
 	// Reputations are in descending order in a circles of hell sense -- 0 is the worst possible thing.
@@ -244,6 +245,19 @@ func (db *db) hasRecord(h []byte) bool {
 	return false
 }
 
+// getOffsetByHash returns whether or not the record exists and its offset in the record data flat file.
+func (db *db) getOffsetByHash(h []byte) (bool, uint64) {
+	if len(h) == 32 {
+		db.cdbLock.Lock()
+		defer db.cdbLock.Unlock()
+		var doff, ts uint64
+		if C.ZTLF_DB_GetByHash(db.cdb, unsafe.Pointer(&(h[0])), (*C.uint64_t)(unsafe.Pointer(&doff)), (*C.uint64_t)(unsafe.Pointer(&ts))) > 0 {
+			return true, doff
+		}
+	}
+	return false, 0
+}
+
 // getRecordTimestampByHash returns whether or not the record exists and its timestamp in seconds since epoch.
 func (db *db) getRecordTimestampByHash(h []byte) (bool, uint64) {
 	if len(h) == 32 {
@@ -293,6 +307,13 @@ func (db *db) updateRecordReputationByHash(h []byte, reputation int) {
 	}
 }
 
+// isOpen returns true if the database is open.
+func (db *db) isOpen() bool {
+	db.cdbLock.Lock()
+	defer db.cdbLock.Unlock()
+	return db.cdb != nil
+}
+
 // stats returns some basic statistics about this database.
 func (db *db) stats() (recordCount, dataSize uint64) {
 	db.cdbLock.Lock()
@@ -327,8 +348,10 @@ func (db *db) haveDanglingLinks(ignoreAfterNRetries int) bool {
 // query executes a query against a number of selector ranges. The function is executed for each result, with
 // results not sorted. The loop is broken if the function returns false. The owner is passed as a pointer to
 // an array that is reused, so a copy must be made if you want to keep it. The arguments to the function are:
-// timestamp, weight (low), weight (high), data offset, data length, local reputation, cumulative selector key, owner, negative comments.
-func (db *db) query(selectorRanges [][2][]byte, oracles []OwnerPublic, f func(uint64, uint64, uint64, uint64, uint64, int, uint64, []byte, uint) bool) error {
+// timestamp, weight (low), weight (high), data offset, data length, local reputation, cumulative selector key, owner,
+// oracles with negative comments, oracles with positive comments. The last two are bit masks in which bit N is set
+// if oracle N commented on the record or its owner. At most dbMaxOracles oracles may be given.
+func (db *db) query(selectorRanges [][2][]byte, oracles []OwnerPublic, f func(uint64, uint64, uint64, uint64, uint64, int, uint64, []byte, uint64, uint64) bool) error {
 	if len(selectorRanges) == 0 {
 		return nil
 	}
@@ -347,6 +370,10 @@ func (db *db) query(selectorRanges [][2][]byte, oracles []OwnerPublic, f func(ui
 		selSizes[ii] = C.uint(len(selectorRanges[i][1]))
 	}
 
+	if len(oracles) > dbMaxOracles {
+		return ErrInvalidParameter
+	}
+
 	var cresults *C.struct_ZTLF_QueryResults
 	if len(oracles) > 0 {
 		ora := make([]uintptr, len(oracles))
@@ -387,7 +414,7 @@ func (db *db) query(selectorRanges [][2][]byte, oracles []OwnerPublic, f func(ui
 		for i := C.long(0); i < cresults.count; i++ {
 			cr := (*C.struct_ZTLF_QueryResult)(unsafe.Pointer(uintptr(unsafe.Pointer(&cresults.results[0])) + (uintptr(i) * uintptr(C.sizeof_struct_ZTLF_QueryResult))))
 			if cr.ownerSize > 0 && cr.dlen > 0 {
-				if !f(uint64(cr.ts), uint64(cr.weightL), uint64(cr.weightH), uint64(cr.doff), uint64(cr.dlen), int(cr.localReputation), uint64(cr.ckey), C.GoBytes(unsafe.Pointer(&cr.owner[0]), C.int(cr.ownerSize)), uint(cr.negativeComments)) {
+				if !f(uint64(cr.ts), uint64(cr.weightL), uint64(cr.weightH), uint64(cr.doff), uint64(cr.dlen), int(cr.localReputation), uint64(cr.ckey), C.GoBytes(unsafe.Pointer(&cr.owner[0]), C.int(cr.ownerSize)), uint64(cr.negativeOracles), uint64(cr.positiveOracles)) {
 					break
 				}
 			}
@@ -418,6 +445,33 @@ func (db *db) getAllByOwner(owner []byte, f func(uint64, uint64, int) bool) erro
 	return nil
 }
 
+// getSynchronized calls f for up to max fully synchronized records with offsets of at least startDoff in data file order.
+func (db *db) getSynchronized(startDoff uint64, max uint, f func(uint64, uint64, int) bool) error {
+	db.cdbLock.Lock()
+	results := C.ZTLF_DB_GetSynchronized(db.cdb, C.uint64_t(startDoff), C.uint(max))
+	db.cdbLock.Unlock()
+	if uintptr(unsafe.Pointer(results)) == 0 {
+		return ErrIO
+	}
+	for i := C.long(0); i < results.count; i++ {
+		rec := (*C.struct_ZTLF_RecordIndex)(unsafe.Pointer(uintptr(unsafe.Pointer(&results.records[0])) + (uintptr(i) * uintptr(C.sizeof_struct_ZTLF_RecordIndex))))
+		if !f(uint64(rec.doff), uint64(rec.dlen), int(rec.localReputation)) {
+			break
+		}
+	}
+	C.free(unsafe.Pointer(results))
+	return nil
+}
+
+// isSynchronized returns true if the record at a given offset in the record data flat file is fully synchronized.
+func (db *db) isSynchronized(doff uint64) (synchronized bool) {
+	_ = db.getSynchronized(doff, 1, func(sdoff, _ uint64, _ int) bool {
+		synchronized = sdoff == doff
+		return false
+	})
+	return
+}
+
 func (db *db) getOwnerStats(owner []byte) (recordCount uint64, recordBytes uint64) {
 	if len(owner) == 0 {
 		return
@@ -606,6 +660,20 @@ func (db *db) haveRecordIncludeLimbo(hash []byte) bool {
 	return C.ZTLF_DB_HaveRecordIncludeLimbo(db.cdb, unsafe.Pointer(&hash[0])) > 0
 }
 
+// forgetLimboOwner removes all of an owner's records from limbo.
+func (db *db) forgetLimboOwner(owner []byte) error {
+	if len(owner) == 0 {
+		return ErrInvalidParameter
+	}
+	db.cdbLock.Lock()
+	defer db.cdbLock.Unlock()
+	e := C.ZTLF_DB_ForgetLimboOwner(db.cdb, unsafe.Pointer(&owner[0]), C.uint(len(owner)))
+	if e != 0 {
+		return fmt.Errorf("database error %d", int(e))
+	}
+	return nil
+}
+
 func (db *db) updatePulse(token, minutes, startRangeStart, startRangeEnd uint64) bool {
 	db.cdbLock.Lock()
 	defer db.cdbLock.Unlock()
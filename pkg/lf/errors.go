@@ -39,22 +39,41 @@ func (e Err) Error() string { return (string)(e) }
 
 // General errors
 const (
-	ErrInvalidPublicKey       Err = "invalid public key"
-	ErrInvalidPrivateKey      Err = "invalid private key"
-	ErrInvalidParameter       Err = "invalid parameter"
-	ErrInvalidObject          Err = "invalid object"
-	ErrUnsupportedType        Err = "unsupported type"
-	ErrUnsupportedCurve       Err = "unsupported ECC curve (for this purpose)"
-	ErrWharrgarblFailed       Err = "Wharrgarbl proof of work algorithm failed (out of memory?)"
-	ErrIO                     Err = "I/O error"
-	ErrIncorrectKey           Err = "incorrect key"
-	ErrRecordNotFound         Err = "record not found"
-	ErrRecordIsNewer          Err = "record is newer than timestamp"
-	ErrPulseSpanExceeded      Err = "pulse is more than one year after record"
-	ErrDuplicateRecord        Err = "duplicate record"
-	ErrPrivateKeyRequired     Err = "private key required"
-	ErrQueryRequiresSelectors Err = "query requires at least one selector"
-	ErrQueryInvalidSortOrder  Err = "invalid sort order value"
+	ErrInvalidPublicKey              Err = "invalid public key"
+	ErrInvalidPrivateKey             Err = "invalid private key"
+	ErrInvalidParameter              Err = "invalid parameter"
+	ErrInvalidObject                 Err = "invalid object"
+	ErrUnsupportedType               Err = "unsupported type"
+	ErrUnsupportedCurve              Err = "unsupported ECC curve (for this purpose)"
+	ErrWharrgarblFailed              Err = "Wharrgarbl proof of work algorithm failed (out of memory?)"
+	ErrIO                            Err = "I/O error"
+	ErrIncorrectKey                  Err = "incorrect key"
+	ErrRecordNotFound                Err = "record not found"
+	ErrRecordIsNewer                 Err = "record is newer than timestamp"
+	ErrPulseSpanExceeded             Err = "pulse is more than one year after record"
+	ErrDuplicateRecord               Err = "duplicate record"
+	ErrPrivateKeyRequired            Err = "private key required"
+	ErrQueryRequiresSelectors        Err = "query requires at least one selector"
+	ErrQueryInvalidSortOrder         Err = "invalid sort order value"
+	ErrQueryInvalidTrustPolicy       Err = "invalid trust policy or trust policy parameters"
+	ErrQueryInvalidFilter            Err = "invalid value filter or projection path"
+	ErrQueryInvalidOracleWeights     Err = "oracle weights must be non-negative and no more numerous than oracles"
+	ErrQueryTooManyOracles           Err = "too many oracles in query"
+	ErrQueryBatchTooLarge            Err = "too many queries in batch"
+	ErrQueryFilterRequiresMaskingKey Err = "value filters and projections require a masking key"
+	ErrWorkQueueFull                 Err = "proof of work queue full or client quota exceeded"
+	ErrWorkCanceled                  Err = "proof of work canceled"
+	ErrNoNodes                       Err = "no nodes configured"
+	ErrQuorumNotReached              Err = "nodes disagree on query results (quorum not reached)"
+	ErrCommentaryDisabled            Err = "commentary (oracle mode) is not enabled on this node"
+	ErrInvalidComment                Err = "invalid comment assertion or subject"
+	ErrUnknownCommentAssertion       Err = "unknown comment assertion"
+	ErrInvalidAPIToken               Err = "invalid API token name, scopes, or rate limit"
+	ErrAPITokenExists                Err = "an API token with this name already exists"
+	ErrAPITokenNotFound              Err = "API token not found"
+	ErrPeerNotFound                  Err = "peer not found"
+	ErrExportChecksumMismatch        Err = "export stream checkpoint does not match records received"
+	ErrTLSClientCertificateRequired  Err = "https+mtls URL requires a client certificate (see SetRemoteNodeTLSConfig)"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -74,6 +93,7 @@ const (
 	ErrRecordTooManyLinks              ErrRecord = "too many links"
 	ErrRecordInvalidLinks              ErrRecord = "links must be sorted and unique"
 	ErrRecordTooManySelectors          ErrRecord = "too many selectors"
+	ErrRecordInvalidSelector           ErrRecord = "selector claim invalid"
 	ErrRecordUnsupportedAlgorithm      ErrRecord = "unsupported algorithm or type"
 	ErrRecordTooLarge                  ErrRecord = "record too large"
 	ErrRecordValueTooLarge             ErrRecord = "record value too large"
@@ -82,6 +102,15 @@ const (
 	ErrRecordCertificateInvalid        ErrRecord = "certificate invalid"
 	ErrRecordCertificateRequired       ErrRecord = "certificate required"
 	ErrRecordProhibited                ErrRecord = "record administratively prohibited"
+	ErrVerifyRecordMissing             ErrRecord = "result does not contain a record"
+	ErrVerifyHashMismatch              ErrRecord = "result hash does not match record"
+	ErrVerifySelectorMismatch          ErrRecord = "record selectors do not match query"
+	ErrVerifyOwnerMismatch             ErrRecord = "record owner does not match query"
+	ErrVerifyTimestampMismatch         ErrRecord = "record timestamp outside query time range"
+	ErrVerifyValueMismatch             ErrRecord = "result value does not match record value"
+	ErrVerifyFilterMismatch            ErrRecord = "record value does not match query filter"
+	ErrVerifyProjectionMismatch        ErrRecord = "result projection does not match record value"
+	ErrVerifyCertificateInvalid        ErrRecord = "record claimed to be signed but no owner certificate chains to a genesis root"
 )
 
 //////////////////////////////////////////////////////////////////////////////
@@ -49,6 +49,41 @@ type GenesisParameters struct {
 	stateP *genesisParametersState
 }
 
+// NewGenesisParametersFromRecords replays serialized genesis records (e.g. from genesis.lf) to get current genesis parameters.
+// The owner of the first record is taken to be the genesis owner and records by other owners are ignored.
+func NewGenesisParametersFromRecords(genesisRecords []byte) (*GenesisParameters, error) {
+	var gp GenesisParameters
+	var genesisOwner OwnerPublic
+	var lastTimestamp uint64
+	gotGenesis := false
+	rdr := bytes.NewReader(genesisRecords)
+	for rdr.Len() > 0 {
+		var r Record
+		err := r.UnmarshalFrom(rdr)
+		if err != nil {
+			return nil, err
+		}
+		if len(genesisOwner) == 0 {
+			genesisOwner = r.Owner
+		}
+		if r.Type != RecordTypeGenesis || !bytes.Equal(genesisOwner, r.Owner) || r.Validate() != nil {
+			continue
+		}
+		rv, err := r.GetValue(nil)
+		if err == nil && len(rv) > 0 && (!gotGenesis || lastTimestamp < r.Timestamp) {
+			if _, err = gp.Update(rv); err != nil {
+				return nil, err
+			}
+			lastTimestamp = r.Timestamp
+			gotGenesis = true
+		}
+	}
+	if !gotGenesis {
+		return nil, ErrInvalidObject
+	}
+	return &gp, nil
+}
+
 // Update updates these GenesisParameters from a JSON encoded parameter set, obeying AmendableFields constraints.
 func (gp *GenesisParameters) Update(jsonValue []byte) (bool, error) {
 	if len(jsonValue) == 0 {
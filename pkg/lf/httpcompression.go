@@ -46,7 +46,7 @@ func (w *compressedResponseWriter) Write(b []byte) (int, error) {
 func httpCompressionHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ae := r.Header.Get("Accept-Encoding")
-		if strings.Contains(ae, "gzip") {
+		if strings.Contains(ae, "gzip") && !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") { // WebSockets must be able to hijack the connection
 			w.Header().Set("Content-Encoding", "gzip")
 			gz := gzPool.Get().(*gzip.Writer)
 			gz.Reset(w)
@@ -0,0 +1,460 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// multiNodeBackoffMax is the longest a failing node will be deprioritized before being tried again first.
+	multiNodeBackoffMax = time.Minute * 5
+)
+
+// multiNodeMember tracks the health of one node in a MultiNode.
+type multiNodeMember struct {
+	node        LF
+	failures    uint          // consecutive failures
+	lastFailure time.Time     //
+	latency     time.Duration // moving average of successful request latency
+}
+
+// name returns a name for this node for use in quorum reports.
+func (mm *multiNodeMember) name() string {
+	n := mm.node
+	if vn, ok := n.(*VerifyingNode); ok {
+		n = vn.LF
+	}
+	if rn, ok := n.(RemoteNode); ok {
+		return rn.DisplayURL()
+	}
+	return "local"
+}
+
+// available returns false if this node has failed recently and is still backing off.
+func (mm *multiNodeMember) available(now time.Time) bool {
+	if mm.failures == 0 {
+		return true
+	}
+	backoff := multiNodeBackoffMax
+	if mm.failures < 16 {
+		backoff = time.Second << mm.failures
+		if backoff > multiNodeBackoffMax {
+			backoff = multiNodeBackoffMax
+		}
+	}
+	return now.Sub(mm.lastFailure) >= backoff
+}
+
+// MultiNode is an LF implementation that spreads requests across several nodes.
+// Requests go to the healthiest and fastest node first and fail over to others on transport
+// or server errors. Errors from a node that is working but rejects a request (e.g. an invalid
+// query) are returned immediately. Read requests can optionally be hedged by sending them to
+// another node if the first is slow, and queries can require that several nodes agree.
+type MultiNode struct {
+	// HedgeDelay is how long to wait for a read before also sending it to the next node (0 disables hedging).
+	HedgeDelay time.Duration
+
+	// Quorum is the number of nodes that must agree on the winning records for a query (<=1 disables quorum).
+	Quorum int
+
+	nodes []*multiNodeMember
+	lock  sync.Mutex
+}
+
+// MultiNodeQuorumResult contains the results of a quorum query and which nodes agreed with them.
+type MultiNodeQuorumResult struct {
+	Results     QueryResults // Results from the largest group of nodes returning the same winning records
+	Quorum      int          // Number of nodes that must agree (Quorum limited to at least two and at most the number of nodes)
+	Agreeing    []string     // Nodes that returned the same winning records as Results
+	Disagreeing []string     // Nodes that returned different winning records
+	Failed      []string     // Nodes that could not be queried
+}
+
+// NewMultiNode creates a MultiNode from one or more nodes, which are initially tried in the order given.
+func NewMultiNode(nodes ...LF) *MultiNode {
+	m := new(MultiNode)
+	for _, n := range nodes {
+		m.nodes = append(m.nodes, &multiNodeMember{node: n})
+	}
+	return m
+}
+
+// multiNodeShouldFailover returns true if an error indicates that a node failed rather than that a request was bad.
+func multiNodeShouldFailover(err error) bool {
+	if err == nil {
+		return false
+	}
+	if e, ok := err.(ErrAPI); ok {
+		return e.Code >= 500 || e.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// order returns members with available nodes first and the fastest nodes first among those.
+func (m *MultiNode) order() []*multiNodeMember {
+	now := time.Now()
+	m.lock.Lock()
+	order := make([]*multiNodeMember, len(m.nodes))
+	copy(order, m.nodes)
+	available := make(map[*multiNodeMember]bool, len(order))
+	latency := make(map[*multiNodeMember]time.Duration, len(order))
+	for _, mm := range order {
+		available[mm] = mm.available(now)
+		latency[mm] = mm.latency
+	}
+	m.lock.Unlock()
+	sort.SliceStable(order, func(a, b int) bool {
+		if available[order[a]] != available[order[b]] {
+			return available[order[a]]
+		}
+		return latency[order[a]] < latency[order[b]]
+	})
+	return order
+}
+
+// record updates a member's health after a request.
+func (m *MultiNode) record(mm *multiNodeMember, latency time.Duration, err error) {
+	m.lock.Lock()
+	if multiNodeShouldFailover(err) {
+		mm.failures++
+		mm.lastFailure = time.Now()
+	} else {
+		mm.failures = 0
+		if mm.latency == 0 {
+			mm.latency = latency
+		} else {
+			mm.latency = ((mm.latency * 7) + latency) / 8
+		}
+	}
+	m.lock.Unlock()
+}
+
+// call runs a request against one member and records the outcome.
+func (m *MultiNode) call(mm *multiNodeMember, f func(LF) (interface{}, error)) (interface{}, error) {
+	start := time.Now()
+	v, err := f(mm.node)
+	m.record(mm, time.Since(start), err)
+	return v, err
+}
+
+// do runs a request against nodes in order until one succeeds or returns a non-failover error.
+// If hedge is true and HedgeDelay is set, the next node is also tried if a request is slow.
+func (m *MultiNode) do(hedge bool, f func(LF) (interface{}, error)) (interface{}, error) {
+	order := m.order()
+	if len(order) == 0 {
+		return nil, ErrNoNodes
+	}
+
+	type reply struct {
+		v   interface{}
+		err error
+	}
+	replies := make(chan reply, len(order))
+	next, inFlight := 0, 0
+	launch := func() {
+		mm := order[next]
+		next++
+		inFlight++
+		go func() {
+			v, err := m.call(mm, f)
+			replies <- reply{v, err}
+		}()
+	}
+
+	launch()
+	var hedgeTimer *time.Timer
+	var hedgeC <-chan time.Time
+	if hedge && m.HedgeDelay > 0 {
+		hedgeTimer = time.NewTimer(m.HedgeDelay)
+		hedgeC = hedgeTimer.C
+		defer hedgeTimer.Stop()
+	}
+
+	var lastErr error
+	for inFlight > 0 {
+		select {
+		case r := <-replies:
+			inFlight--
+			if !multiNodeShouldFailover(r.err) {
+				return r.v, r.err
+			}
+			lastErr = r.err
+			if inFlight == 0 && next < len(order) {
+				launch()
+			}
+		case <-hedgeC:
+			if next < len(order) {
+				launch()
+				hedgeTimer.Reset(m.HedgeDelay)
+			} else {
+				hedgeC = nil
+			}
+		}
+	}
+	return nil, lastErr
+}
+
+// ExecuteQueryQuorum sends a query to Quorum nodes (or at least two) and compares their winning records.
+// Nodes that fail are replaced by other nodes if any remain. An error is returned only if no node answered.
+func (m *MultiNode) ExecuteQueryQuorum(q *Query) (*MultiNodeQuorumResult, error) {
+	order := m.order()
+	if len(order) == 0 {
+		return nil, ErrNoNodes
+	}
+	quorum := m.Quorum
+	if quorum < 2 {
+		quorum = 2
+	}
+	if quorum > len(order) {
+		quorum = len(order)
+	}
+
+	type reply struct {
+		mm  *multiNodeMember
+		qr  QueryResults
+		err error
+	}
+	replies := make(chan reply, len(order))
+	next, inFlight := 0, 0
+	launch := func() {
+		mm := order[next]
+		next++
+		inFlight++
+		go func() {
+			v, err := m.call(mm, func(n LF) (interface{}, error) { return n.ExecuteQuery(q) })
+			qr, _ := v.(QueryResults)
+			replies <- reply{mm, qr, err}
+		}()
+	}
+	for next < quorum {
+		launch()
+	}
+
+	var answered []reply
+	result := MultiNodeQuorumResult{Quorum: quorum}
+	var lastErr error
+	for inFlight > 0 {
+		r := <-replies
+		inFlight--
+		if r.err != nil {
+			result.Failed = append(result.Failed, r.mm.name())
+			lastErr = r.err
+			if !multiNodeShouldFailover(r.err) {
+				continue
+			}
+			if next < len(order) {
+				launch()
+			}
+		} else {
+			answered = append(answered, r)
+		}
+	}
+	if len(answered) == 0 {
+		return nil, lastErr
+	}
+
+	// Group answers by their winning record hashes and take the largest group.
+	groups := make(map[string][]int)
+	largest := ""
+	for i := range answered {
+		k := multiNodeWinners(answered[i].qr)
+		groups[k] = append(groups[k], i)
+		if len(groups[k]) > len(groups[largest]) || (len(groups[k]) == len(groups[largest]) && groups[k][0] < groups[largest][0]) {
+			largest = k
+		}
+	}
+	result.Results = answered[groups[largest][0]].qr
+	for k, g := range groups {
+		for _, i := range g {
+			if k == largest {
+				result.Agreeing = append(result.Agreeing, answered[i].mm.name())
+			} else {
+				result.Disagreeing = append(result.Disagreeing, answered[i].mm.name())
+			}
+		}
+	}
+	return &result, nil
+}
+
+// multiNodeWinners returns a comparable key made from the sorted hashes of the top result in each result set.
+func multiNodeWinners(qr QueryResults) string {
+	winners := make([][]byte, 0, len(qr))
+	for _, ress := range qr {
+		if len(ress) > 0 {
+			winners = append(winners, ress[0].Hash[:])
+		}
+	}
+	sort.Slice(winners, func(a, b int) bool { return bytes.Compare(winners[a], winners[b]) < 0 })
+	return string(bytes.Join(winners, nil))
+}
+
+// AddRecord submits a record to the first node that accepts it.
+func (m *MultiNode) AddRecord(rec *Record) error {
+	_, err := m.do(false, func(n LF) (interface{}, error) { return nil, n.AddRecord(rec) })
+	return err
+}
+
+// GetRecord gets a record by its hash from the first node that answers.
+func (m *MultiNode) GetRecord(hash []byte) (*Record, error) {
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.GetRecord(hash) })
+	rec, _ := v.(*Record)
+	return rec, err
+}
+
+// GenesisParameters gets genesis parameters from the first node that answers.
+func (m *MultiNode) GenesisParameters() (*GenesisParameters, error) {
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.GenesisParameters() })
+	gp, _ := v.(*GenesisParameters)
+	return gp, err
+}
+
+// NodeStatus gets the status of the first node that answers.
+func (m *MultiNode) NodeStatus() (*NodeStatus, error) {
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.NodeStatus() })
+	ns, _ := v.(*NodeStatus)
+	return ns, err
+}
+
+// OwnerStatus gets an owner's status from the first node that answers.
+func (m *MultiNode) OwnerStatus(ownerPublic OwnerPublic) (*OwnerStatus, error) {
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.OwnerStatus(ownerPublic) })
+	os, _ := v.(*OwnerStatus)
+	return os, err
+}
+
+// Links gets links from the first node that answers.
+func (m *MultiNode) Links(count int) ([][32]byte, uint64, error) {
+	type links struct {
+		l  [][32]byte
+		ts uint64
+	}
+	v, err := m.do(true, func(n LF) (interface{}, error) {
+		l, ts, err := n.Links(count)
+		return &links{l, ts}, err
+	})
+	if l, _ := v.(*links); l != nil {
+		return l.l, l.ts, err
+	}
+	return nil, 0, err
+}
+
+// ExecuteQuery executes a query against the first node that answers.
+// If Quorum is greater than one the query is sent to that many nodes (or all nodes if there are fewer)
+// and ErrQuorumNotReached is returned if they do not all agree on the winning records. Use
+// ExecuteQueryQuorum for details.
+func (m *MultiNode) ExecuteQuery(q *Query) (QueryResults, error) {
+	if m.Quorum > 1 {
+		qqr, err := m.ExecuteQueryQuorum(q)
+		if err != nil {
+			return nil, err
+		}
+		if len(qqr.Agreeing) < qqr.Quorum {
+			return nil, ErrQuorumNotReached
+		}
+		return qqr.Results, nil
+	}
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.ExecuteQuery(q) })
+	qr, _ := v.(QueryResults)
+	return qr, err
+}
+
+// ExecuteQueryBatch executes a batch of queries against the first node that answers.
+func (m *MultiNode) ExecuteQueryBatch(queries QueryBatch) ([]QueryBatchResult, error) {
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.ExecuteQueryBatch(queries) })
+	qbr, _ := v.([]QueryBatchResult)
+	return qbr, err
+}
+
+// ExecuteMakeRecord executes a MakeRecord against the first node that accepts it.
+func (m *MultiNode) ExecuteMakeRecord(mr *MakeRecord) (*Record, Pulse, bool, error) {
+	type made struct {
+		rec   *Record
+		pulse Pulse
+		isNew bool
+	}
+	v, err := m.do(false, func(n LF) (interface{}, error) {
+		rec, pulse, isNew, err := n.ExecuteMakeRecord(mr)
+		return &made{rec, pulse, isNew}, err
+	})
+	if r, _ := v.(*made); r != nil {
+		return r.rec, r.pulse, r.isNew, err
+	}
+	return nil, nil, false, err
+}
+
+// ExecuteMakePulse executes a MakePulse against the first node that accepts it.
+func (m *MultiNode) ExecuteMakePulse(mp *MakePulse) (Pulse, *Record, bool, error) {
+	type made struct {
+		pulse Pulse
+		rec   *Record
+		isNew bool
+	}
+	v, err := m.do(false, func(n LF) (interface{}, error) {
+		pulse, rec, isNew, err := n.ExecuteMakePulse(mp)
+		return &made{pulse, rec, isNew}, err
+	})
+	if r, _ := v.(*made); r != nil {
+		return r.pulse, r.rec, r.isNew, err
+	}
+	return nil, nil, false, err
+}
+
+// ExecuteMakeWork asks the first node that accepts it to compute proof of work.
+func (m *MultiNode) ExecuteMakeWork(mw *MakeWork) (*MakeWorkResult, error) {
+	v, err := m.do(false, func(n LF) (interface{}, error) { return n.ExecuteMakeWork(mw) })
+	wr, _ := v.(*MakeWorkResult)
+	return wr, err
+}
+
+// ExecuteEstimate gets a proof of work estimate from the first node that answers.
+func (m *MultiNode) ExecuteEstimate(e *Estimate) (*EstimateResult, error) {
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.ExecuteEstimate(e) })
+	er, _ := v.(*EstimateResult)
+	return er, err
+}
+
+// DoPulse sends a pulse to the first node that accepts it.
+func (m *MultiNode) DoPulse(pulse Pulse, announce bool) (bool, error) {
+	v, err := m.do(false, func(n LF) (interface{}, error) { return n.DoPulse(pulse, announce) })
+	ok, _ := v.(bool)
+	return ok, err
+}
+
+// Connect instructs the first node that accepts it to connect to a peer.
+func (m *MultiNode) Connect(ip net.IP, port int, identity []byte) error {
+	_, err := m.do(false, func(n LF) (interface{}, error) { return nil, n.Connect(ip, port, identity) })
+	return err
+}
+
+// AddComment submits a comment to the first available node.
+func (m *MultiNode) AddComment(c *Comment) error {
+	_, err := m.do(false, func(n LF) (interface{}, error) { return nil, n.AddComment(c) })
+	return err
+}
+
+// GetComments gets comments from the first node to answer.
+func (m *MultiNode) GetComments(q *CommentQuery) ([]Comment, error) {
+	v, err := m.do(true, func(n LF) (interface{}, error) { return n.GetComments(q) })
+	comments, _ := v.([]Comment)
+	return comments, err
+}
+
+// IsLocal returns false for MultiNode even if it contains a local node.
+func (m *MultiNode) IsLocal() bool { return false }
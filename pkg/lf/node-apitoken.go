@@ -0,0 +1,291 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the API token (bearer authorization) part of Node, see node.go for main object.
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// API token scopes. Requests from localhost are authorized for every scope unless TrustLoopback is disabled in node.json.
+const (
+	APITokenScopeReadOnly   = "read-only"   // No privileges beyond public endpoints (identifies and rate limits a client)
+	APITokenScopeMakeRecord = "makerecord"  // Delegate record creation and proof of work (/makerecord, /work)
+	APITokenScopeConnect    = "connect"     // Suggest P2P endpoints (/connect)
+	APITokenScopeOracleFlag = "oracle-flag" // Submit comments for this node's oracle to publish (/comment)
+	APITokenScopeAdmin      = "admin"       // All of the above plus node administration such as token and peer management
+)
+
+// APITokenMasterName is the name of the token stored in authtoken.secret, which has admin scope and no rate limit.
+const APITokenMasterName = "authtoken.secret"
+
+const apiTokensFileName = "apitokens.json"
+
+// APIToken describes a named bearer token that authorizes HTTP API requests.
+// Clients send tokens in an "Authorization: Bearer <token>" header. RemoteNode does this
+// automatically for URLs that include a token as user info, e.g. https://<token>@host/.
+type APIToken struct {
+	Name      string   ``                  // Unique name of token
+	Token     string   `json:",omitempty"` // Secret token (only returned when the token is created)
+	Scopes    []string ``                  // Scopes granted by this token
+	RateLimit float64  `json:",omitempty"` // Maximum requests per second or 0 for no limit
+	Created   uint64   ``                  // Time token was created in seconds since epoch
+}
+
+// APITokenCreate (request) asks a node to create a new API token.
+type APITokenCreate struct {
+	Name      string   ``                  // Unique name of token
+	Scopes    []string ``                  // Scopes to grant
+	RateLimit float64  `json:",omitempty"` // Maximum requests per second or 0 for no limit
+}
+
+// APITokenRevoke (request) asks a node to revoke an API token by name.
+type APITokenRevoke struct {
+	Name string
+}
+
+// apiToken is an API token as stored by the node, which keeps only a hash of the secret token.
+type apiToken struct {
+	APIToken
+	TokenHash Blob
+
+	allowance float64   // remaining request allowance for rate limiting
+	lastCheck time.Time // time allowance was last updated
+}
+
+type apiTokenContextKey struct{}
+
+func apiTokenScopeValid(scope string) bool {
+	switch scope {
+	case APITokenScopeReadOnly, APITokenScopeMakeRecord, APITokenScopeConnect, APITokenScopeOracleFlag, APITokenScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// hasScope returns true if this token grants a scope, which admin tokens do for every scope.
+func (t *apiToken) hasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope || s == APITokenScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// allow returns true if a request is within this token's rate limit and uses up one request of allowance.
+// Tokens may burst up to one second's worth of requests (minimum one request).
+func (t *apiToken) allow(now time.Time) bool {
+	if t.RateLimit <= 0.0 {
+		return true
+	}
+	burst := math.Max(t.RateLimit, 1.0)
+	if t.lastCheck.IsZero() {
+		t.allowance = burst
+	} else {
+		t.allowance = math.Min(t.allowance+(now.Sub(t.lastCheck).Seconds()*t.RateLimit), burst)
+	}
+	t.lastCheck = now
+	if t.allowance < 1.0 {
+		return false
+	}
+	t.allowance -= 1.0
+	return true
+}
+
+// loadAPITokens reads named API tokens from apitokens.json if it exists, replacing any currently loaded tokens.
+func (n *Node) loadAPITokens() error {
+	apiTokens := make(map[string]*apiToken)
+	data, err := ioutil.ReadFile(path.Join(n.basePath, apiTokensFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		var tokens []*apiToken
+		if err = json.Unmarshal(data, &tokens); err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			if len(t.Name) > 0 && len(t.TokenHash) == sha256.Size {
+				apiTokens[t.Name] = t
+			}
+		}
+	}
+	n.apiTokensLock.Lock()
+	n.apiTokens = apiTokens
+	n.apiTokensLock.Unlock()
+	return nil
+}
+
+// ReloadAPITokens reloads named API tokens from apitokens.json, such as after it has been edited by hand.
+// If the file can't be read or parsed the currently loaded tokens are kept.
+func (n *Node) ReloadAPITokens() error {
+	return n.loadAPITokens()
+}
+
+// saveAPITokens writes named API tokens to apitokens.json. The caller must hold apiTokensLock.
+func (n *Node) saveAPITokens() error {
+	tokens := make([]*apiToken, 0, len(n.apiTokens))
+	for _, t := range n.apiTokens {
+		tokens = append(tokens, t)
+	}
+	sort.Slice(tokens, func(a, b int) bool { return tokens[a].Name < tokens[b].Name })
+	return ioutil.WriteFile(path.Join(n.basePath, apiTokensFileName), []byte(PrettyJSON(tokens)), 0600)
+}
+
+// CreateAPIToken creates and saves a new named API token, returning it with its secret token.
+// The secret is not stored and can't be retrieved again later.
+func (n *Node) CreateAPIToken(c *APITokenCreate) (*APIToken, error) {
+	if len(c.Name) == 0 || c.Name == APITokenMasterName || len(c.Scopes) == 0 || math.IsNaN(c.RateLimit) || math.IsInf(c.RateLimit, 0) || c.RateLimit < 0.0 {
+		return nil, ErrInvalidAPIToken
+	}
+	for _, s := range c.Scopes {
+		if !apiTokenScopeValid(s) {
+			return nil, ErrInvalidAPIToken
+		}
+	}
+
+	var secret [24]byte
+	_, _ = secureRandom.Read(secret[:])
+	token := Base62Encode(secret[:])
+	tokenHash := sha256.Sum256([]byte(token))
+	t := &apiToken{
+		APIToken: APIToken{
+			Name:      c.Name,
+			Scopes:    c.Scopes,
+			RateLimit: c.RateLimit,
+			Created:   TimeSec(),
+		},
+		TokenHash: tokenHash[:],
+	}
+
+	n.apiTokensLock.Lock()
+	defer n.apiTokensLock.Unlock()
+	if _, exists := n.apiTokens[c.Name]; exists {
+		return nil, ErrAPITokenExists
+	}
+	n.apiTokens[c.Name] = t
+	if err := n.saveAPITokens(); err != nil {
+		delete(n.apiTokens, c.Name)
+		return nil, err
+	}
+
+	created := t.APIToken
+	created.Token = token
+	return &created, nil
+}
+
+// ListAPITokens returns all named API tokens without their secrets.
+func (n *Node) ListAPITokens() []APIToken {
+	n.apiTokensLock.Lock()
+	tokens := make([]APIToken, 0, len(n.apiTokens))
+	for _, t := range n.apiTokens {
+		tokens = append(tokens, t.APIToken)
+	}
+	n.apiTokensLock.Unlock()
+	sort.Slice(tokens, func(a, b int) bool { return tokens[a].Name < tokens[b].Name })
+	return tokens
+}
+
+// RevokeAPIToken deletes a named API token.
+func (n *Node) RevokeAPIToken(name string) error {
+	n.apiTokensLock.Lock()
+	defer n.apiTokensLock.Unlock()
+	t := n.apiTokens[name]
+	if t == nil {
+		return ErrAPITokenNotFound
+	}
+	delete(n.apiTokens, name)
+	if err := n.saveAPITokens(); err != nil {
+		n.apiTokens[name] = t
+		return err
+	}
+	return nil
+}
+
+// apiTokenFor looks up the token matching a secret, comparing hashes in constant time.
+func (n *Node) apiTokenFor(secret string) *apiToken {
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(n.apiAuthToken)) == 1 {
+		return &apiToken{APIToken: APIToken{Name: APITokenMasterName, Scopes: []string{APITokenScopeAdmin}}}
+	}
+	h := sha256.Sum256([]byte(secret))
+	var found *apiToken
+	n.apiTokensLock.Lock()
+	for _, t := range n.apiTokens {
+		if subtle.ConstantTimeCompare(h[:], t.TokenHash) == 1 {
+			found = t
+		}
+	}
+	n.apiTokensLock.Unlock()
+	return found
+}
+
+// apiTokenHandler checks bearer tokens and rate limits on all requests that present one.
+// Requests without a token pass through and are authorized only for public endpoints unless from localhost.
+func (n *Node) apiTokenHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, req *http.Request) {
+		auth := req.Header.Get("Authorization")
+		if len(auth) == 0 {
+			h.ServeHTTP(out, req)
+			return
+		}
+		var t *apiToken
+		if strings.HasPrefix(auth, "Bearer ") {
+			t = n.apiTokenFor(strings.TrimSpace(auth[7:]))
+		}
+		if t == nil {
+			apiSetStandardHeaders(out)
+			out.Header().Set("WWW-Authenticate", "Bearer")
+			apiSendObj(out, req, http.StatusUnauthorized, &ErrAPI{Code: http.StatusUnauthorized, Message: "invalid or revoked API token"})
+			return
+		}
+		n.apiTokensLock.Lock()
+		allowed := t.allow(time.Now())
+		n.apiTokensLock.Unlock()
+		if !allowed {
+			apiSetStandardHeaders(out)
+			out.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(1.0/t.RateLimit))))
+			apiSendObj(out, req, http.StatusTooManyRequests, &ErrAPI{Code: http.StatusTooManyRequests, Message: "API token rate limit exceeded"})
+			return
+		}
+		h.ServeHTTP(out, req.WithContext(context.WithValue(req.Context(), apiTokenContextKey{}, t)))
+	})
+}
+
+// apiIsAuthorized returns true if a request presented a token with the given scope or is from localhost or a Unix domain socket
+// (unless trust of localhost has been disabled in the node's config). Requests with an Origin header come from browsers, which
+// will send requests to localhost on behalf of any web page, so they are never trusted for being local and need a token.
+func (n *Node) apiIsAuthorized(req *http.Request, scope string) bool {
+	if t, _ := req.Context().Value(apiTokenContextKey{}).(*apiToken); t != nil && t.hasScope(scope) {
+		return true
+	}
+	if atomic.LoadUint32(&n.apiTrustLoopback) == 0 || len(req.Header.Get("Origin")) > 0 {
+		return false
+	}
+	return requestIsLocal(req)
+}
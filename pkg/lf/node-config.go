@@ -0,0 +1,164 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the runtime configuration part of Node, see node.go for main object.
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+// NodeConfigName is the name of the node config file in a node's base path.
+const NodeConfigName = "node.json"
+
+// NodeConfig is the JSON format for the node configuration file.
+// Listen addresses, TLS, LetsEncrypt, local test mode, and log destination take effect when a node starts.
+// Everything else can be changed on a running node with ApplyConfig. API tokens are kept separately
+// in apitokens.json and can be reloaded with ReloadAPITokens.
+type NodeConfig struct {
+	P2PListen   []string       ``                  // P2P listen addresses (host:port or port, first port is announced to peers)
+	HTTPListen  []string       ``                  // HTTP API listen addresses (host:port, port, or unix:/path)
+	TLS         *NodeTLSConfig `json:",omitempty"` // Serve the HTTP API over TLS (HTTPS) if non-nil
+	LetsEncrypt []string       `json:",omitempty"` // Domains for which to serve the HTTP API on port 443 with LetsEncrypt certificates
+	LocalTest   bool           ``                  // Local test mode (no P2P, proof of work optional)
+	LogStderr   bool           ``                  // Log to stderr instead of node.log
+
+	LogLevel          string            ``                  // Log level: normal, verbose, or trace
+	LogLevels         map[string]string `json:",omitempty"` // Log levels by subsystem (node, p2p, sync, db, oracle, api) overriding LogLevel
+	LogFormat         string            ``                  // Log format: text or json (one JSON object per line)
+	DesiredPeers      int               ``                  // Number of P2P connections the node tries to maintain
+	MaxPeers          int               ``                  // Maximum P2P connections including inbound connections (0 for no limit)
+	AllowedOrigins    []string          `json:",omitempty"` // Web origins allowed to use the API from browsers
+	TrustLoopback     bool              ``                  // Authorize all API requests from localhost without a token
+	Commentary        bool              ``                  // Use spare CPU to add work to the DAG and publish commentary (oracle mode)
+	MinFreeDiskSpace  uint64            ``                  // Stop if free space on the data device falls below this many bytes
+	LimboRetention    uint64            ``                  // Seconds to keep records in limbo after the last one for an owner arrives (0 to keep forever)
+	LimboMaxOwnerSize int64             ``                  // Maximum bytes of records in limbo per owner (0 for no limit)
+	ReadyMinPeers     int               ``                  // Connected peers required for /readyz to report ready (ignored in local test mode)
+	ReadyRequireSync  bool              ``                  // Require the node to be synchronized for /readyz to report ready
+
+	Dirty bool `json:"-"` // Non-persisted flag that indicates the config was initialized with defaults and should be saved
+}
+
+// LogLevelFromString returns a log level by name (normal, verbose, or trace).
+func LogLevelFromString(s string) (int, bool) {
+	switch s {
+	case "normal":
+		return LogLevelNormal, true
+	case "verbose":
+		return LogLevelVerbose, true
+	case "trace":
+		return LogLevelTrace, true
+	}
+	return LogLevelVerbose, false
+}
+
+// Load loads this node config from disk or initializes it with defaults if it does not exist.
+// Fields missing from the file keep their default values.
+func (c *NodeConfig) Load(path string) error {
+	*c = NodeConfig{
+		P2PListen:        []string{strconv.Itoa(DefaultP2PPort)},
+		HTTPListen:       []string{strconv.Itoa(DefaultHTTPPort)},
+		LogLevel:         "verbose",
+		LogFormat:        LogFormatText,
+		DesiredPeers:     p2pDesiredConnectionCount,
+		TrustLoopback:    true,
+		MinFreeDiskSpace: MinFreeDiskSpace,
+		ReadyMinPeers:    1,
+		ReadyRequireSync: true,
+	}
+	d, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			c.Dirty = true
+			return nil
+		}
+		return err
+	}
+	if len(d) > 0 {
+		return json.Unmarshal(d, c)
+	}
+	return nil
+}
+
+// Save writes this node config to disk.
+func (c *NodeConfig) Save(path string) error {
+	err := ioutil.WriteFile(path, []byte(PrettyJSON(c)), 0600)
+	if err == nil {
+		c.Dirty = false
+	}
+	return err
+}
+
+// ApplyConfig applies the settings in a node config that can be changed while a node is running.
+// Settings that only take effect on start (listen addresses, TLS, LetsEncrypt, local test mode, log destination) are ignored.
+func (n *Node) ApplyConfig(c *NodeConfig) error {
+	logLevel, ok := LogLevelFromString(c.LogLevel)
+	if !ok || c.DesiredPeers < 0 || c.MaxPeers < 0 || c.LimboMaxOwnerSize < 0 || c.ReadyMinPeers < 0 {
+		return ErrInvalidParameter
+	}
+	var logLevels [logSubsystemCount]int
+	for i := range logLevels {
+		logLevels[i] = logLevel
+	}
+	for name, level := range c.LogLevels {
+		sub, ok := LogSubsystemFromString(name)
+		if !ok {
+			return ErrInvalidParameter
+		}
+		if logLevels[sub], ok = LogLevelFromString(level); !ok {
+			return ErrInvalidParameter
+		}
+	}
+	logFormatJSON := uint32(0)
+	switch c.LogFormat {
+	case "", LogFormatText:
+	case LogFormatJSON:
+		logFormatJSON = 1
+	default:
+		return ErrInvalidParameter
+	}
+
+	for i, level := range logLevels {
+		n.setLogLevel(i, level)
+	}
+	atomic.StoreUint32(&n.logFormatJSON, logFormatJSON)
+	atomic.StoreInt32(&n.desiredPeers, int32(c.DesiredPeers))
+	atomic.StoreInt32(&n.maxPeers, int32(c.MaxPeers))
+	trustLoopback := uint32(0)
+	if c.TrustLoopback {
+		trustLoopback = 1
+	}
+	atomic.StoreUint32(&n.apiTrustLoopback, trustLoopback)
+	atomic.StoreUint64(&n.minFreeDiskSpace, c.MinFreeDiskSpace)
+	atomic.StoreUint64(&n.limboRetention, c.LimboRetention)
+	atomic.StoreInt64(&n.limboMaxOwnerSize, c.LimboMaxOwnerSize)
+	atomic.StoreInt32(&n.readyMinPeers, int32(c.ReadyMinPeers))
+	readyRequireSync := uint32(0)
+	if c.ReadyRequireSync {
+		readyRequireSync = 1
+	}
+	atomic.StoreUint32(&n.readyRequireSync, readyRequireSync)
+	n.SetAllowedOrigins(c.AllowedOrigins)
+	if (atomic.LoadUint32(&n.commentary) != 0) != c.Commentary {
+		n.SetCommentaryEnabled(c.Commentary)
+	}
+
+	n.apiLog[LogLevelNormal].Printf("config: log level %s (format %s), desired peers %d, max peers %d, commentary %t", c.LogLevel, c.LogFormat, c.DesiredPeers, c.MaxPeers, c.Commentary)
+	return nil
+}
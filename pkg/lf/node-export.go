@@ -0,0 +1,318 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the record export part of Node, see node.go for main object.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Export stream frame types.
+// An export stream is a series of frames, each starting with one of these type bytes. A record frame
+// is followed by a varint length and the record. A checkpoint frame is followed by the 8-byte resume
+// offset, the 8-byte number of records since the previous checkpoint, and the SHA-256 hash of those
+// records concatenated. The end frame has no body and is always preceded by a checkpoint.
+const (
+	ExportFrameRecord     byte = 1
+	ExportFrameCheckpoint byte = 2
+	ExportFrameEnd        byte = 3
+)
+
+const (
+	exportPageSize             = 1024
+	exportCheckpointRecords    = 4096
+	exportCheckpointBytes      = 4194304
+	exportCheckpointFrameBytes = 1 + 8 + 8 + 32
+)
+
+// ExportCheckpoint is a marker in an export stream after which an interrupted download can resume.
+type ExportCheckpoint struct {
+	Offset  uint64   // Pass as offset to resume export after this checkpoint
+	Records uint64   // Number of records since the previous checkpoint (or start)
+	SHA256  [32]byte // SHA-256 of all records since the previous checkpoint (or start) concatenated
+}
+
+// exportPending is a record waiting for one or more of its links to be exported first.
+type exportPending struct {
+	hash    [32]byte
+	data    []byte
+	waiting int
+	leftOut bool
+}
+
+// exportWriter writes frames to an export stream and tracks the state needed for checkpoints.
+type exportWriter struct {
+	w       io.Writer
+	sha     hash.Hash
+	records uint64
+	size    uint64
+	tmp     [exportCheckpointFrameBytes]byte
+}
+
+func (ew *exportWriter) writeRecord(data []byte) error {
+	ew.tmp[0] = ExportFrameRecord
+	l := binary.PutUvarint(ew.tmp[1:], uint64(len(data)))
+	if _, err := ew.w.Write(ew.tmp[0 : 1+l]); err != nil {
+		return err
+	}
+	if _, err := ew.w.Write(data); err != nil {
+		return err
+	}
+	_, _ = ew.sha.Write(data)
+	ew.records++
+	ew.size += uint64(len(data))
+	return nil
+}
+
+func (ew *exportWriter) writeCheckpoint(offset uint64) error {
+	ew.tmp[0] = ExportFrameCheckpoint
+	binary.BigEndian.PutUint64(ew.tmp[1:9], offset)
+	binary.BigEndian.PutUint64(ew.tmp[9:17], ew.records)
+	ew.sha.Sum(ew.tmp[17:17])
+	if _, err := ew.w.Write(ew.tmp[:]); err != nil {
+		return err
+	}
+	ew.sha.Reset()
+	ew.records = 0
+	ew.size = 0
+	if f, ok := ew.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// ExportRecords writes synchronized records to w as an export stream, starting at an offset in the record data file.
+// Records are written in an order where every record comes after all the records it links to, and records that are not
+// yet synchronized (or that link to such records) are left out. Records before the start offset are assumed to have
+// already been exported, so a stream resumed from any of its checkpoints' offsets continues exactly where it left off.
+// Checkpoints are only written where no record is still waiting for its links.
+//
+// Two limits apply. A record that only becomes synchronized after a stream has passed its offset is not exported by
+// that stream or by any stream resumed from one of its checkpoints, so it must come from P2P sync or a new export from
+// an earlier offset. The /export handler lifts the HTTP server's 600 second write timeout for HTTP/1.x, but HTTP/2
+// streams keep it, so long exports over HTTP/2 are cut off and must be resumed from their last checkpoint (as
+// node-bootstrap does).
+func (n *Node) ExportRecords(w io.Writer, offset uint64) error {
+	leftOut, err := exportRecords(&n.db, w, offset, exportCheckpointRecords, &n.shutdown)
+	if leftOut > 0 {
+		n.syncLog[LogLevelVerbose].Printf("sync: export from offset %d left out %d records linking to unsynchronized records", offset, leftOut)
+	}
+	return err
+}
+
+// exportRecords implements ExportRecords for a database, writing a checkpoint every checkpointRecords records (or
+// exportCheckpointBytes bytes) where possible. It stops with ErrIO if shutdown becomes non-zero. The number of records
+// left out because they link to records that aren't synchronized is returned.
+func exportRecords(d *db, w io.Writer, offset uint64, checkpointRecords uint64, shutdown *uint32) (int, error) {
+	ew := exportWriter{w: w, sha: sha256.New()}
+	waiters := make(map[[32]byte][]*exportPending) // records waiting on a link by link hash
+	pending := make(map[[32]byte]bool)             // hashes of records not yet written because they're waiting on links
+	leftOut := make(map[[32]byte]bool)             // hashes of records left out because they link to unsynchronized records
+	startOffset := offset
+	var release [][32]byte
+
+	// leaveOut marks a record as left out along with everything waiting on it, directly or indirectly.
+	leaveOut := func(h [32]byte) {
+		release = append(release[:0], h)
+		for len(release) > 0 {
+			h := release[len(release)-1]
+			release = release[0 : len(release)-1]
+			leftOut[h] = true
+			for _, wp := range waiters[h] {
+				if !wp.leftOut {
+					wp.leftOut = true
+					wp.data = nil
+					delete(pending, wp.hash)
+					release = append(release, wp.hash)
+				}
+			}
+			delete(waiters, h)
+		}
+	}
+
+	var buf []byte
+	for {
+		var page [][2]uint64
+		err := d.getSynchronized(offset, exportPageSize, func(doff, dlen uint64, _ int) bool {
+			page = append(page, [2]uint64{doff, dlen})
+			return true
+		})
+		if err != nil {
+			return 0, err
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		for _, r := range page {
+			offset = r[0] + 1
+			if atomic.LoadUint32(shutdown) != 0 {
+				return 0, ErrIO
+			}
+
+			buf, err = d.getDataByOffset(r[0], uint(r[1]), buf[:0])
+			if err != nil {
+				return 0, err
+			}
+			var rec Record
+			if rec.UnmarshalFrom(bytes.NewReader(buf)) != nil {
+				continue
+			}
+
+			// Links that are pending or come later in the data file must be written first. Links at or after the start
+			// offset that aren't synchronized never will be (by this stream), so records linking to them are left out
+			// right away. Anything before the start offset is assumed to have already been exported.
+			p := &exportPending{hash: rec.Hash()}
+			for _, l := range rec.Links {
+				if pending[l] {
+					waiters[l] = append(waiters[l], p)
+					p.waiting++
+				} else if leftOut[l] {
+					p.leftOut = true
+					break
+				} else if found, ldoff := d.getOffsetByHash(l[:]); found && ldoff >= startOffset {
+					if !d.isSynchronized(ldoff) {
+						p.leftOut = true
+						break
+					}
+					if ldoff >= offset {
+						waiters[l] = append(waiters[l], p)
+						p.waiting++
+					}
+				}
+			}
+			if p.leftOut {
+				leaveOut(p.hash)
+			} else if p.waiting > 0 {
+				p.data = append(make([]byte, 0, len(buf)), buf...)
+				pending[p.hash] = true
+				continue
+			} else {
+				if err = ew.writeRecord(buf); err != nil {
+					return 0, err
+				}
+				release = append(release[:0], p.hash)
+				for len(release) > 0 {
+					h := release[len(release)-1]
+					release = release[0 : len(release)-1]
+					for _, wp := range waiters[h] {
+						if wp.leftOut {
+							continue
+						}
+						wp.waiting--
+						if wp.waiting == 0 {
+							delete(pending, wp.hash)
+							if err = ew.writeRecord(wp.data); err != nil {
+								return 0, err
+							}
+							release = append(release, wp.hash)
+						}
+					}
+					delete(waiters, h)
+				}
+			}
+
+			if len(pending) == 0 && (ew.records >= checkpointRecords || ew.size >= exportCheckpointBytes) {
+				if err = ew.writeCheckpoint(offset); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+
+	// Anything still pending is waiting on a record that was synchronized when it was checked but that this
+	// stream never reached, so it's left out too.
+	if err := ew.writeCheckpoint(offset); err != nil {
+		return 0, err
+	}
+	_, err := w.Write([]byte{ExportFrameEnd})
+	return len(leftOut) + len(pending), err
+}
+
+// ExportOffsetByHash returns the offset at which to start an export to include a record and those after it.
+func (n *Node) ExportOffsetByHash(hash []byte) (uint64, error) {
+	found, doff := n.db.getOffsetByHash(hash)
+	if !found {
+		return 0, ErrRecordNotFound
+	}
+	return doff, nil
+}
+
+//////////////////////////////////////////////////////////////////////////////
+
+// ExportReader reads an export stream, verifying each checkpoint against the records read before it.
+type ExportReader struct {
+	r       *bufio.Reader
+	sha     hash.Hash
+	records uint64
+}
+
+// NewExportReader creates a reader for an export stream.
+func NewExportReader(r io.Reader) *ExportReader {
+	return &ExportReader{r: bufio.NewReader(r), sha: sha256.New()}
+}
+
+// Next reads the next frame and returns its type along with either a record or a verified checkpoint.
+// ErrExportChecksumMismatch is returned if a checkpoint doesn't match the records read since the last one,
+// in which case those records must be discarded and the export resumed from the last good checkpoint.
+func (er *ExportReader) Next() (byte, []byte, *ExportCheckpoint, error) {
+	t, err := er.r.ReadByte()
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	switch t {
+	case ExportFrameRecord:
+		l, err := binary.ReadUvarint(er.r)
+		if err != nil {
+			return 0, nil, nil, err
+		}
+		if l == 0 || l > RecordMaxSize {
+			return 0, nil, nil, ErrInvalidObject
+		}
+		data := make([]byte, int(l))
+		if _, err = io.ReadFull(er.r, data); err != nil {
+			return 0, nil, nil, err
+		}
+		_, _ = er.sha.Write(data)
+		er.records++
+		return t, data, nil, nil
+	case ExportFrameCheckpoint:
+		var tmp [exportCheckpointFrameBytes - 1]byte
+		if _, err = io.ReadFull(er.r, tmp[:]); err != nil {
+			return 0, nil, nil, err
+		}
+		cp := &ExportCheckpoint{Offset: binary.BigEndian.Uint64(tmp[0:8]), Records: binary.BigEndian.Uint64(tmp[8:16])}
+		copy(cp.SHA256[:], tmp[16:48])
+		var sum [32]byte
+		er.sha.Sum(sum[:0])
+		ok := cp.Records == er.records && sum == cp.SHA256
+		er.sha.Reset()
+		er.records = 0
+		if !ok {
+			return 0, nil, nil, ErrExportChecksumMismatch
+		}
+		return t, nil, cp, nil
+	case ExportFrameEnd:
+		return t, nil, nil, nil
+	}
+	return 0, nil, nil, ErrInvalidObject
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the health and readiness check part of Node, see node.go for main object.
+
+import (
+	"sync/atomic"
+)
+
+// NodeHealth (response) reports whether a node is alive and able to operate (/healthz).
+type NodeHealth struct {
+	Healthy          bool   // True if all of the checks below pass
+	Running          bool   // False once the node has begun shutting down
+	DatabaseOpen     bool   // True if the database is open
+	FreeDiskSpace    uint64 // Free space on the device holding the node's data
+	MinFreeDiskSpace uint64 // Free space below which the node is unhealthy (and stops)
+}
+
+// NodeReadiness (response) reports whether a node is ready to serve consistent reads (/readyz).
+type NodeReadiness struct {
+	Ready          bool // True if the node is healthy and all of the checks below pass
+	Healthy        bool // Result of the health check
+	Synchronized   bool // True if the node has all records referenced by others it knows about
+	RequireSync    bool // True if being synchronized is required to be ready
+	Peers          int  // Number of connected P2P peers
+	MinPeers       int  // Connected peers required to be ready (ignored in local test mode)
+	WorkTableReady bool // True if the proof of work table has been initialized
+	LocalTestMode  bool `json:",omitempty"` // True if the node is in local test mode (no P2P)
+}
+
+// Health checks whether this node is alive, its database is open, and free disk space is above the configured minimum.
+func (n *Node) Health() *NodeHealth {
+	h := &NodeHealth{
+		Running:          atomic.LoadUint32(&n.shutdown) == 0,
+		DatabaseOpen:     n.db.isOpen(),
+		MinFreeDiskSpace: atomic.LoadUint64(&n.minFreeDiskSpace),
+	}
+	h.FreeDiskSpace, _ = getFreeSpaceOnDevice(n.basePath)
+	h.Healthy = h.Running && h.DatabaseOpen && h.FreeDiskSpace >= h.MinFreeDiskSpace
+	return h
+}
+
+// Readiness checks whether this node is healthy, synchronized, connected to enough peers, and able to verify work.
+// The peer and synchronization requirements are set with ReadyMinPeers and ReadyRequireSync in the node config.
+func (n *Node) Readiness() *NodeReadiness {
+	r := &NodeReadiness{
+		Healthy:        n.Health().Healthy,
+		Synchronized:   atomic.LoadUint32(&n.synchronized) != 0,
+		RequireSync:    atomic.LoadUint32(&n.readyRequireSync) != 0,
+		MinPeers:       int(atomic.LoadInt32(&n.readyMinPeers)),
+		WorkTableReady: wharrgarblTableReady(),
+		LocalTestMode:  n.localTest,
+	}
+	n.peersLock.RLock()
+	r.Peers = len(n.peers)
+	n.peersLock.RUnlock()
+	r.Ready = r.Healthy && (r.Synchronized || !r.RequireSync) && (r.Peers >= r.MinPeers || n.localTest) && r.WorkTableReady
+	return r
+}
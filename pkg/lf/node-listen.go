@@ -0,0 +1,227 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the listen address part of Node, see node.go for main object.
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ListenAddressUnixPrefix prefixes HTTP API listen addresses that are Unix domain socket paths, e.g. unix:/var/run/lf.sock.
+const ListenAddressUnixPrefix = "unix:"
+
+// parseListenAddress parses a listen address of the form host:port, [ipv6%zone]:port, port, or unix:/path.
+// A bare port listens on all interfaces. Unix domain socket paths are only accepted if allowUnix is true.
+func parseListenAddress(a string, allowUnix bool) (network string, address string, err error) {
+	a = strings.TrimSpace(a)
+	if strings.HasPrefix(a, ListenAddressUnixPrefix) {
+		if !allowUnix || len(a) == len(ListenAddressUnixPrefix) {
+			return "", "", fmt.Errorf("invalid listen address '%s'", a)
+		}
+		return "unix", a[len(ListenAddressUnixPrefix):], nil
+	}
+	if _, err := strconv.ParseUint(a, 10, 16); err == nil {
+		return "tcp", ":" + a, nil
+	}
+	if _, _, err := net.SplitHostPort(a); err != nil {
+		return "", "", fmt.Errorf("invalid listen address '%s' (%s)", a, err.Error())
+	}
+	return "tcp", a, nil
+}
+
+// listen opens a listener for an address returned by parseListenAddress.
+// A stale Unix domain socket left behind by a node that did not exit cleanly is removed first.
+func listen(network, address string) (net.Listener, error) {
+	if network == "unix" {
+		if fi, err := os.Lstat(address); err == nil && (fi.Mode()&os.ModeSocket) != 0 {
+			if c, err := net.Dial("unix", address); err == nil {
+				_ = c.Close()
+				return nil, fmt.Errorf("unix domain socket %s is in use", address)
+			}
+			_ = os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
+
+// listenAddressPort returns the port of a TCP listen address or 0 if it has none.
+func listenAddressPort(address string) int {
+	_, p, _ := net.SplitHostPort(address)
+	port, _ := strconv.ParseUint(p, 10, 16)
+	return int(port)
+}
+
+// p2pAddressIsAnnounceable returns true if an IP is meaningful to other nodes.
+// IPv6 link-local addresses are only valid with a zone (interface) on the local host and are never announced.
+func p2pAddressIsAnnounceable(ip net.IP) bool {
+	return len(ip) > 0 && !ip.IsUnspecified() && !ip.IsMulticast() && (ip.To4() != nil || !ip.IsLinkLocalUnicast())
+}
+
+// requestIsLocal returns true if an HTTP request came from a loopback address or over a Unix domain socket.
+// Access to Unix domain sockets is controlled by file system permissions so they are treated like localhost.
+func requestIsLocal(req *http.Request) bool {
+	localAddr, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if ca, isHTTPConn := localAddr.(httpConnAddr); isHTTPConn {
+		localAddr = ca.Addr
+	}
+	if _, isUnix := localAddr.(*net.UnixAddr); isUnix {
+		return true
+	}
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	if i := strings.IndexByte(ip, '%'); i >= 0 {
+		ip = ip[0:i]
+	}
+	return net.ParseIP(ip).IsLoopback()
+}
+
+// httpListener wraps an HTTP API listener so handlers can lift the server's write timeout for their connection.
+type httpListener struct {
+	net.Listener
+}
+
+func (l httpListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &httpConn{Conn: c}, nil
+}
+
+// httpConn is an HTTP API connection that ignores write deadlines while noWriteTimeout is non-zero.
+// Its local address is an httpConnAddr so handlers can find it via http.LocalAddrContextKey.
+type httpConn struct {
+	net.Conn
+	noWriteTimeout uint32
+}
+
+// httpConnAddr is the local address of an httpConn.
+type httpConnAddr struct {
+	net.Addr
+	c *httpConn
+}
+
+func (c *httpConn) LocalAddr() net.Addr { return httpConnAddr{Addr: c.Conn.LocalAddr(), c: c} }
+
+func (c *httpConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+func (c *httpConn) SetWriteDeadline(t time.Time) error {
+	if atomic.LoadUint32(&c.noWriteTimeout) != 0 {
+		t = time.Time{}
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
+
+// httpLiftWriteTimeout removes the server's write timeout for the rest of a response that may take longer to send,
+// returning a function to call when the response is done so later requests on the connection get it back.
+// This only works for HTTP/1.x. HTTP/2 streams have their own write timer that Go 1.12 provides no way to change.
+func httpLiftWriteTimeout(req *http.Request) func() {
+	ca, isHTTPConn := req.Context().Value(http.LocalAddrContextKey).(httpConnAddr)
+	if !isHTTPConn || req.ProtoMajor != 1 {
+		return func() {}
+	}
+	atomic.StoreUint32(&ca.c.noWriteTimeout, 1)
+	_ = ca.c.Conn.SetWriteDeadline(time.Time{})
+	return func() { atomic.StoreUint32(&ca.c.noWriteTimeout, 0) }
+}
+
+// localClientURL returns the URL written to the node's own client.json so the CLI on this host can reach it.
+// Loopback listeners are preferred since requests from them are trusted (see apiIsAuthorized). With TLS the URL's
+// host is one the certificate covers if possible; if the certificate covers no loopback name, serverName is a
+// name to verify it against instead (see RemoteNodeTLSConfig.LocalServerName). A non-empty warning should be logged.
+func (n *Node) localClientURL() (u RemoteNode, serverName string, warning string) {
+	var ta *net.TCPAddr
+	taLocal := false
+	for _, l := range n.httpListeners {
+		a, isTCP := l.Addr().(*net.TCPAddr)
+		if !isTCP {
+			continue
+		}
+		local := a.IP.IsLoopback() || a.IP.IsUnspecified()
+		if ta == nil || (local && !taLocal) {
+			ta, taLocal = a, local
+		}
+	}
+	if ta == nil {
+		return "", "", "HTTP API has no TCP listener (the CLI can't use Unix domain sockets), client.json not updated"
+	}
+
+	var hosts []string
+	if ta.IP.IsUnspecified() {
+		hosts = []string{"127.0.0.1", "::1", "localhost"}
+	} else if ta.IP.IsLoopback() {
+		hosts = []string{ta.IP.String(), "localhost"}
+	} else {
+		hosts = []string{ta.IP.String()}
+	}
+	host := hosts[0]
+	scheme := "http"
+	if n.httpTLSConfig != nil {
+		scheme = "https"
+		if n.httpTLSConfig.ClientAuth == tls.RequireAndVerifyClientCert {
+			scheme = RemoteNodeMTLSScheme
+		}
+		if len(n.httpTLSConfig.Certificates) > 0 && len(n.httpTLSConfig.Certificates[0].Certificate) > 0 {
+			if leaf, err := x509.ParseCertificate(n.httpTLSConfig.Certificates[0].Certificate[0]); err == nil {
+				covered := false
+				for _, h := range hosts {
+					if leaf.VerifyHostname(h) == nil {
+						host, covered = h, true
+						break
+					}
+				}
+				if !covered {
+					for _, name := range leaf.DNSNames {
+						if !strings.HasPrefix(name, "*") {
+							serverName = name
+							break
+						}
+					}
+					if len(serverName) == 0 {
+						serverName = leaf.Subject.CommonName
+					}
+					warning = "TLS certificate does not cover " + host + ", client.json will verify it as " + serverName
+				}
+			}
+		}
+	}
+	if !taLocal {
+		if len(warning) > 0 {
+			warning += "; "
+		}
+		warning += "HTTP API has no loopback listener so the CLI on this host is not trusted as local and privileged commands need an API token (see node-token)"
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.Equal(ta.IP) && len(ta.Zone) > 0 {
+		host = host + "%25" + ta.Zone // zones are escaped in URLs (RFC 6874)
+	}
+	return RemoteNode(fmt.Sprintf("%s://%s", scheme, net.JoinHostPort(host, strconv.Itoa(ta.Port)))), serverName, warning
+}
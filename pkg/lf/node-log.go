@@ -0,0 +1,190 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the logging part of Node, see node.go for main object.
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Log subsystems whose levels can be set independently.
+const (
+	LogSubsystemNode   int = 0 // Startup, shutdown, genesis, certificates, and anything not covered below
+	LogSubsystemP2P    int = 1 // Peer connections and P2P messages
+	LogSubsystemSync   int = 2 // Record synchronization, limbo, and bootstrap import
+	LogSubsystemDB     int = 3 // Messages from the native database layer
+	LogSubsystemOracle int = 4 // Commentary, oracle mode, and remote proof of work
+	LogSubsystemAPI    int = 5 // HTTP API, WebSocket sessions, and config changes
+
+	logSubsystemCount = 6
+)
+
+// LogFormatText and LogFormatJSON are the names of the supported log output formats.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogSubsystemNames are the names of log subsystems by subsystem index.
+var LogSubsystemNames = [logSubsystemCount]string{"node", "p2p", "sync", "db", "oracle", "api"}
+
+var logLevelNames = [logLevelCount]string{"fatal", "warning", "normal", "verbose", "trace"}
+
+// LogSubsystemFromString returns a log subsystem index by name.
+func LogSubsystemFromString(s string) (int, bool) {
+	for i, name := range LogSubsystemNames {
+		if name == s {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// logFields are structured fields attached to log messages in JSON output.
+// They are ignored in text output since the same information is already in the message.
+type logFields struct {
+	record      []byte // Record hash
+	peer        []byte // Peer identity (compressed public key)
+	peerAddress string // Peer IP and port
+	err         error  // Error being reported
+}
+
+// withErr returns a copy of these fields with an error added.
+func (f *logFields) withErr(err error) *logFields {
+	var c logFields
+	if f != nil {
+		c = *f
+	}
+	c.err = err
+	return &c
+}
+
+// logEntryJSON is the format of one line of JSON log output.
+type logEntryJSON struct {
+	Time        string ``                  // Time in RFC3339 format with nanoseconds (UTC)
+	Level       string ``                  // fatal, warning, normal, verbose, or trace
+	Subsystem   string ``                  // node, p2p, sync, db, oracle, or api
+	Message     string ``                  // Human readable message
+	Record      string `json:",omitempty"` // Record hash (base62)
+	Peer        string `json:",omitempty"` // Peer identity (base62)
+	PeerAddress string `json:",omitempty"` // Peer IP and port
+	Error       string `json:",omitempty"` // Error message
+	ErrorType   string `json:",omitempty"` // Error type name, e.g. ErrRecord
+}
+
+// nodeLogWriter writes log output at one level of one subsystem if the node's current level for that subsystem includes it.
+// This lets log levels and format change while the node is running without replacing the node's loggers.
+type nodeLogWriter struct {
+	n         *Node
+	subsystem int
+	level     int
+	fields    *logFields
+}
+
+func (w *nodeLogWriter) Write(b []byte) (int, error) {
+	if int32(w.level) > atomic.LoadInt32(&w.n.logLevels[w.subsystem]) {
+		return len(b), nil
+	}
+	msg := strings.TrimRight(string(b), "\n")
+	if atomic.LoadUint32(&w.n.logFormatJSON) == 0 {
+		return len(b), w.n.logger.Output(2, msg)
+	}
+
+	e := logEntryJSON{
+		Time:      time.Now().UTC().Format(time.RFC3339Nano),
+		Level:     logLevelNames[w.level],
+		Subsystem: LogSubsystemNames[w.subsystem],
+		Message:   msg,
+	}
+	for _, p := range []string{"FATAL: ", "WARNING: ", "BUG: "} {
+		if strings.HasPrefix(e.Message, p) {
+			e.Message = e.Message[len(p):]
+		}
+	}
+	if w.fields != nil {
+		if len(w.fields.record) > 0 {
+			e.Record = Base62Encode(w.fields.record)
+		}
+		if len(w.fields.peer) > 0 {
+			e.Peer = Base62Encode(w.fields.peer)
+		}
+		e.PeerAddress = w.fields.peerAddress
+		if w.fields.err != nil {
+			e.Error = w.fields.err.Error()
+			e.ErrorType = errTypeName(w.fields.err)
+		}
+	}
+	if len(e.Record) == 0 {
+		e.Record = logMessageRecordHash(msg)
+	}
+	j, err := json.Marshal(&e)
+	if err != nil {
+		return 0, err
+	}
+	return len(b), w.n.logJSON.Output(2, string(j))
+}
+
+// logMessageRecordHash returns the first =<base62 hash> in a log message or an empty string if there is none.
+// Most messages about records already include the hash this way, so this saves passing it separately.
+func logMessageRecordHash(msg string) string {
+	for i := strings.IndexByte(msg, '='); i >= 0 && i < len(msg); {
+		j := i + 1
+		for j < len(msg) && ((msg[j] >= '0' && msg[j] <= '9') || (msg[j] >= 'a' && msg[j] <= 'z') || (msg[j] >= 'A' && msg[j] <= 'Z')) {
+			j++
+		}
+		if j-i > 40 { // a 32-byte hash is at least 41 base62 characters
+			return msg[i+1 : j]
+		}
+		k := strings.IndexByte(msg[j:], '=')
+		if k < 0 {
+			break
+		}
+		i = j + k
+	}
+	return ""
+}
+
+// newLog creates loggers for each level of a subsystem, optionally with structured fields for JSON output.
+func (n *Node) newLog(subsystem int, fields *logFields) (l [logLevelCount]*log.Logger) {
+	for i := 0; i < logLevelCount; i++ {
+		if n.logger == nil {
+			l[i] = nullLogger
+		} else {
+			l[i] = log.New(&nodeLogWriter{n: n, subsystem: subsystem, level: i, fields: fields}, "", 0)
+		}
+	}
+	return
+}
+
+// logEntry returns a logger for one message with structured fields, or a logger that discards output if the level is not enabled.
+func (n *Node) logEntry(subsystem, level int, fields *logFields) *log.Logger {
+	if n.logger == nil || int32(level) > atomic.LoadInt32(&n.logLevels[subsystem]) {
+		return nullLogger
+	}
+	return log.New(&nodeLogWriter{n: n, subsystem: subsystem, level: level, fields: fields}, "", 0)
+}
+
+// setLogLevel sets the log level for one subsystem or for all subsystems if subsystem is negative.
+func (n *Node) setLogLevel(subsystem, level int) {
+	for i := 0; i < logSubsystemCount; i++ {
+		if subsystem < 0 || subsystem == i {
+			atomic.StoreInt32(&n.logLevels[i], int32(level))
+		}
+	}
+}
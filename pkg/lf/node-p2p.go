@@ -52,7 +52,7 @@ const (
 	// p2pProtoMaxRetries is the maximum number of times we'll try to retry a record
 	p2pProtoMaxRetries = 256
 
-	// p2pDesiredConnectionCount is how many P2P TCP connections we want to have open
+	// p2pDesiredConnectionCount is how many P2P TCP connections we want to have open by default
 	p2pDesiredConnectionCount = 32
 
 	// Minimum interval between peer connection attempts
@@ -91,6 +91,9 @@ type connectedPeer struct {
 	identity       []byte               // Remote node's identity (public key)
 	peerHelloMsg   peerHelloMsg         // Hello message received from peer
 	inbound        bool                 // True if this is an incoming connection
+	connectedSince uint64               // Time (seconds) connection was established
+	bytesSent      uint64               // Bytes sent (atomic)
+	bytesReceived  uint64               // Bytes received (atomic)
 }
 
 // knownPeer contains info about a peer we know about via another peer or the API
@@ -101,10 +104,12 @@ type knownPeer struct {
 	LastSuccessfulConnection  uint64 // Time (seconds) of most recent successful connection
 	LastReconnectionAttempt   uint64 // Time (seconds) of most recent connection attempt (zeroed on success)
 	TotalReconnectionAttempts int    // Total connection attempts (zeroed on success)
+	Pinned                    bool   `json:",omitempty"` // Always reconnect and never forget (see node-peers.go)
+	Banned                    bool   `json:",omitempty"` // Refuse connections to and from this peer
 }
 
 // updateKnownPeersOnConnectSuccess is called from p2pConnectionHandler to update n.knownPeers.
-func (n *Node) updateKnownPeersOnConnectSuccess(ip net.IP, port int, identity []byte) {
+func (n *Node) updateKnownPeersOnConnectSuccess(ip net.IP, zone string, port int, identity []byte) {
 	if len(identity) == 0 {
 		return
 	}
@@ -122,6 +127,7 @@ func (n *Node) updateKnownPeersOnConnectSuccess(ip net.IP, port int, identity []
 		n.knownPeers[idStr] = &knownPeer{
 			Peer: Peer{
 				IP:       ip,
+				Zone:     zone,
 				Port:     port,
 				Identity: identity,
 			},
@@ -131,13 +137,14 @@ func (n *Node) updateKnownPeersOnConnectSuccess(ip net.IP, port int, identity []
 			TotalReconnectionAttempts: 0,
 		}
 	} else {
-		if kp.IP.Equal(ip) && kp.Port == port {
+		if kp.IP.Equal(ip) && kp.Zone == zone && kp.Port == port {
 			if kp.FirstConnect == 0 {
 				kp.FirstConnect = now
 			}
 			kp.LastSuccessfulConnection = now
 		} else {
 			kp.IP = ip
+			kp.Zone = zone
 			kp.Port = port
 			kp.FirstConnect = now
 			kp.LastSuccessfulConnection = now
@@ -153,7 +160,7 @@ func (n *Node) writeKnownPeers() {
 	defer n.knownPeersLock.Unlock()
 
 	for knownPeerID, kp := range n.knownPeers {
-		if kp.TotalReconnectionAttempts > p2pPeerMaxAttempts {
+		if kp.TotalReconnectionAttempts > p2pPeerMaxAttempts && !kp.Pinned && !kp.Banned {
 			delete(n.knownPeers, knownPeerID)
 		}
 	}
@@ -161,8 +168,12 @@ func (n *Node) writeKnownPeers() {
 	_ = ioutil.WriteFile(n.peersFilePath, []byte(PrettyJSON(&n.knownPeers)), 0644)
 }
 
-// sendPeerAnnouncement sends a peer announcement to this peer for the given address and public key
+// sendPeerAnnouncement sends a peer announcement to this peer for the given address and public key.
+// Addresses that would be meaningless to the other peer such as IPv6 link-local addresses are not announced.
 func (p *connectedPeer) sendPeerAnnouncement(tcpAddr *net.TCPAddr, identity []byte) {
+	if !p2pAddressIsAnnounceable(tcpAddr.IP) {
+		return
+	}
 	var peerMsg Peer
 	peerMsg.IP = tcpAddr.IP
 	peerMsg.Port = tcpAddr.Port
@@ -209,27 +220,42 @@ func (p *connectedPeer) send(msg []byte) {
 			_, err := p.c.Write(buf)
 			if err != nil {
 				_ = p.c.Close()
+			} else {
+				atomic.AddUint64(&p.bytesSent, uint64(len(buf)))
 			}
 		}
 	}()
 }
 
+// p2pConnectionCount returns the number of connected peers plus connections still in startup.
+func (n *Node) p2pConnectionCount() int {
+	n.peersLock.RLock()
+	c := len(n.peers)
+	n.peersLock.RUnlock()
+	n.connectionsInStartupLock.Lock()
+	c += len(n.connectionsInStartup)
+	n.connectionsInStartupLock.Unlock()
+	return c
+}
+
 func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound bool) {
 	var err error
 	var p *connectedPeer
 
 	tcpAddr, tcpAddrOk := c.RemoteAddr().(*net.TCPAddr)
 	if tcpAddr == nil || !tcpAddrOk {
-		n.log[LogLevelWarning].Print("BUG: P2P connection RemoteAddr() did not return a TCPAddr object, connection closed")
+		n.p2pLog[LogLevelWarning].Print("BUG: P2P connection RemoteAddr() did not return a TCPAddr object, connection closed")
 		_ = c.Close()
 		return
 	}
 	peerAddressStr := tcpAddr.String()
+	pf := &logFields{peer: identity, peerAddress: peerAddressStr} // peer is filled in below for inbound connections
+	plog := n.newLog(LogSubsystemP2P, pf)
 
 	defer func() {
 		e := recover()
 		if e != nil {
-			n.log[LogLevelWarning].Printf("WARNING: P2P connection to %s closed: caught panic: %v", peerAddressStr, e)
+			plog[LogLevelWarning].Printf("WARNING: P2P connection to %s closed: caught panic: %v", peerAddressStr, e)
 		}
 
 		_ = c.Close()
@@ -277,7 +303,7 @@ func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound boo
 	_ = c.SetWriteDeadline(time.Now().Add(time.Second * 30))
 	_, err = c.Write(helloMessage)
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 
@@ -285,39 +311,44 @@ func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound boo
 	_ = c.SetReadDeadline(time.Now().Add(time.Second * 30))
 	_, err = io.ReadFull(reader, helloMessage[0:2])
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 	if helloMessage[0] != p2pProtoModeAES256GCMECCP384 || helloMessage[1] == 0 {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: protocol mode not supported or invalid key length", peerAddressStr)
+		plog[LogLevelNormal].Printf("P2P connection to %s closed: protocol mode not supported or invalid key length", peerAddressStr)
 		return
 	}
 	remoteIdentity := make([]byte, uint(helloMessage[1]))
 	_ = c.SetReadDeadline(time.Now().Add(time.Second * 30))
 	_, err = io.ReadFull(reader, remoteIdentity)
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 	if bytes.Equal(remoteIdentity, n.identity) {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: other side has same identity!", peerAddressStr)
+		plog[LogLevelNormal].Printf("P2P connection to %s closed: other side has same identity!", peerAddressStr)
 		return
 	}
 	if !inbound && !bytes.Equal(identity, remoteIdentity) {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: remote identity (public key) does not match expected identity", peerAddressStr)
+		plog[LogLevelNormal].Printf("P2P connection to %s closed: remote identity (public key) does not match expected identity", peerAddressStr)
+		return
+	}
+	if n.peerIsBanned(remoteIdentity) {
+		plog[LogLevelNormal].Printf("P2P connection to %s closed: peer is banned", peerAddressStr)
 		return
 	}
 	helloMessage = nil
+	pf.peer = remoteIdentity
 
 	// Perform ECDH key agreement and init encryption
 	remotePubX, remotePubY, err := ECCDecompressPublicKey(elliptic.P384(), remoteIdentity)
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: invalid public key: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: invalid public key: %s", peerAddressStr, err.Error())
 		return
 	}
 	remoteShared, err := ECDHAgreeECDSA(remotePubX, remotePubY, n.owner.Private.(*ecdsa.PrivateKey))
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: key agreement failed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: key agreement failed: %s", peerAddressStr, err.Error())
 		return
 	}
 	for i := 0; i < 32; i++ {
@@ -331,20 +362,20 @@ func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound boo
 	var nonceExchangeTmp, outgoingNonce, incomingNonce [16]byte
 	_, err = secureRandom.Read(outgoingNonce[:])
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 	aesCipher.Encrypt(nonceExchangeTmp[:], outgoingNonce[:])
 	_ = c.SetWriteDeadline(time.Now().Add(time.Second * 30))
 	_, err = c.Write(nonceExchangeTmp[:])
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 	_ = c.SetReadDeadline(time.Now().Add(time.Second * 30))
 	_, err = io.ReadFull(reader, incomingNonce[:])
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 	aesCipher.Decrypt(incomingNonce[:], incomingNonce[:])
@@ -354,26 +385,27 @@ func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound boo
 	_ = c.SetWriteDeadline(time.Now().Add(time.Second * 30))
 	_, err = c.Write(incomingNonceHash[0:16])
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 	_ = c.SetReadDeadline(time.Now().Add(time.Second * 30))
 	_, err = io.ReadFull(reader, nonceExchangeTmp[:])
 	if !bytes.Equal(outgoingNonceHash[0:16], nonceExchangeTmp[:]) {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: challenge/response failed (key incorrect?)", peerAddressStr)
+		plog[LogLevelNormal].Printf("P2P connection to %s closed: challenge/response failed (key incorrect?)", peerAddressStr)
 		return
 	}
 
 	p = &connectedPeer{
-		n:             n,
-		address:       peerAddressStr,
-		tcpAddress:    tcpAddr,
-		c:             c,
-		cryptor:       cryptor,
-		hasRecords:    make(map[[32]byte]uintptr),
-		outgoingNonce: outgoingNonce,
-		identity:      remoteIdentity,
-		inbound:       inbound,
+		n:              n,
+		address:        peerAddressStr,
+		tcpAddress:     tcpAddr,
+		c:              c,
+		cryptor:        cryptor,
+		hasRecords:     make(map[[32]byte]uintptr),
+		outgoingNonce:  outgoingNonce,
+		identity:       remoteIdentity,
+		inbound:        inbound,
+		connectedSince: TimeSec(),
 	}
 
 	msgbuf, err := json.Marshal(&peerHelloMsg{
@@ -385,7 +417,7 @@ func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound boo
 		SubscribeToNewRecords: true,
 	})
 	if err != nil {
-		n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+		n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 		return
 	}
 	p.send(append([]byte{p2pProtoMessageTypeHello}, msgbuf...))
@@ -393,7 +425,7 @@ func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound boo
 	n.peersLock.Lock()
 	for _, existingPeer := range n.peers {
 		if bytes.Equal(existingPeer.identity, remoteIdentity) {
-			n.log[LogLevelNormal].Printf("P2P connection to %s closed: replaced by new link %s to same peer", existingPeer.tcpAddress.String(), peerAddressStr)
+			plog[LogLevelNormal].Printf("P2P connection to %s closed: replaced by new link %s to same peer", existingPeer.tcpAddress.String(), peerAddressStr)
 			_ = existingPeer.c.Close()
 		} else {
 			if !inbound {
@@ -412,10 +444,10 @@ func (n *Node) p2pConnectionHandler(c *net.TCPConn, identity []byte, inbound boo
 	n.connectionsInStartupLock.Unlock()
 
 	if !inbound {
-		n.updateKnownPeersOnConnectSuccess(tcpAddr.IP, tcpAddr.Port, remoteIdentity)
+		n.updateKnownPeersOnConnectSuccess(tcpAddr.IP, tcpAddr.Zone, tcpAddr.Port, remoteIdentity)
 	}
 
-	n.log[LogLevelNormal].Printf("P2P connection established to %s %d %s", tcpAddr.IP.String(), tcpAddr.Port, Base62Encode(remoteIdentity))
+	plog[LogLevelNormal].Printf("P2P connection established to %s %s", peerAddressStr, Base62Encode(remoteIdentity))
 
 	performedInboundReachabilityTest := false
 mainReaderLoop:
@@ -425,11 +457,11 @@ mainReaderLoop:
 		// Read size of message (varint)
 		msgSize, err := binary.ReadUvarint(reader)
 		if err != nil {
-			n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+			n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 			break
 		}
 		if msgSize == 0 || msgSize > p2pProtoMaxMessageSize {
-			n.log[LogLevelNormal].Printf("P2P connection to %s closed: invalid message size", peerAddressStr)
+			plog[LogLevelNormal].Printf("P2P connection to %s closed: invalid message size", peerAddressStr)
 			break
 		}
 
@@ -448,9 +480,10 @@ mainReaderLoop:
 		msg := msgbuf[0 : uint(msgSize)+16]
 		_, err = io.ReadFull(reader, msg)
 		if err != nil {
-			n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+			n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 			break
 		}
+		atomic.AddUint64(&p.bytesReceived, uint64(len(msg)))
 
 		if atomic.LoadUint32(&n.shutdown) != 0 {
 			break
@@ -467,11 +500,11 @@ mainReaderLoop:
 		// Decrypt and authenticate message
 		msg, err = p.cryptor.Open(msg[:0], incomingNonce[0:12], msg, nil)
 		if err != nil {
-			n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+			n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 			break
 		}
 		if len(msg) < 1 {
-			n.log[LogLevelNormal].Printf("P2P connection to %s closed: invalid message size", peerAddressStr)
+			plog[LogLevelNormal].Printf("P2P connection to %s closed: invalid message size", peerAddressStr)
 			break
 		}
 		fullMsg := msg
@@ -484,7 +517,7 @@ mainReaderLoop:
 			if len(msg) > 0 {
 				err := json.Unmarshal(msg, &p.peerHelloMsg)
 				if err != nil {
-					n.log[LogLevelNormal].Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
+					n.logEntry(LogSubsystemP2P, LogLevelNormal, pf.withErr(err)).Printf("P2P connection to %s closed: %s", peerAddressStr, err.Error())
 					break mainReaderLoop
 				}
 
@@ -498,11 +531,12 @@ mainReaderLoop:
 						testAddr := &net.TCPAddr{
 							IP:   tcpAddr.IP,
 							Port: p.peerHelloMsg.P2PPort,
+							Zone: tcpAddr.Zone,
 						}
 						testConn, err := net.DialTimeout("tcp", testAddr.String(), time.Second*5)
 						if testConn != nil && err == nil {
-							n.log[LogLevelVerbose].Printf("reverse reachability test to port %d successful for inbound connection from %s", p.peerHelloMsg.P2PPort, tcpAddr.IP.String())
-							n.updateKnownPeersOnConnectSuccess(tcpAddr.IP, p.peerHelloMsg.P2PPort, remoteIdentity)
+							plog[LogLevelVerbose].Printf("reverse reachability test to port %d successful for inbound connection from %s", p.peerHelloMsg.P2PPort, tcpAddr.IP.String())
+							n.updateKnownPeersOnConnectSuccess(tcpAddr.IP, tcpAddr.Zone, p.peerHelloMsg.P2PPort, remoteIdentity)
 							if atomic.LoadUint32(&n.shutdown) == 0 {
 								n.peersLock.RLock()
 								for _, otherPeer := range n.peers {
@@ -514,7 +548,7 @@ mainReaderLoop:
 							}
 							_ = testConn.Close()
 						} else {
-							n.log[LogLevelVerbose].Printf("reverse reachability test to port %d unsuccessful for inbound connection from %s", p.peerHelloMsg.P2PPort, tcpAddr.IP.String())
+							plog[LogLevelVerbose].Printf("reverse reachability test to port %d unsuccessful for inbound connection from %s", p.peerHelloMsg.P2PPort, tcpAddr.IP.String())
 						}
 						n.backgroundThreadWG.Done()
 					}()
@@ -529,7 +563,7 @@ mainReaderLoop:
 					p.hasRecordsLock.Lock()
 					p.hasRecords[rh] = atomic.LoadUintptr(&n.timeTicker)
 					p.hasRecordsLock.Unlock()
-					_ = n.addRemoteRecord(msg, rh[:], rec, tcpAddr.IP.String())
+					_ = n.addRemoteRecord(msg, rh[:], rec, tcpAddr.IP.String(), remoteIdentity)
 				}
 			}
 
@@ -552,7 +586,7 @@ mainReaderLoop:
 				copy(h[:], msg[0:32])
 				msg = msg[32:]
 
-				n.log[LogLevelTrace].Printf("have record notification for =%s from %s", Base62Encode(h[:]), tcpAddr.IP.String())
+				plog[LogLevelTrace].Printf("have record notification for =%s from %s", Base62Encode(h[:]), tcpAddr.IP.String())
 
 				ticker := atomic.LoadUintptr(&n.timeTicker)
 				p.hasRecordsLock.Lock()
@@ -560,12 +594,12 @@ mainReaderLoop:
 				p.hasRecordsLock.Unlock()
 
 				if n.db.haveRecordIncludeLimbo(h[:]) {
-					n.log[LogLevelTrace].Printf("not requesting =%s from %s: already have record", Base62Encode(h[:]), tcpAddr.IP.String())
+					plog[LogLevelTrace].Printf("not requesting =%s from %s: already have record", Base62Encode(h[:]), tcpAddr.IP.String())
 				} else {
 					n.recordsRequestedLock.Lock()
 					if (ticker - n.recordsRequested[h]) <= 2 {
 						n.recordsRequestedLock.Unlock()
-						n.log[LogLevelTrace].Printf("not requesting =%s from %s: recently requested", Base62Encode(h[:]), tcpAddr.IP.String())
+						plog[LogLevelTrace].Printf("not requesting =%s from %s: recently requested", Base62Encode(h[:]), tcpAddr.IP.String())
 						continue
 					}
 					n.recordsRequested[h] = ticker
@@ -586,14 +620,10 @@ mainReaderLoop:
 			if len(msg) > 0 {
 				var peerMsg Peer
 				if json.Unmarshal(msg, &peerMsg) == nil {
-					if len(peerMsg.Identity) > 0 {
-						n.peersLock.RLock()
-						connectionCount := len(n.peers)
-						n.peersLock.RUnlock()
-						n.connectionsInStartupLock.Lock()
-						connectionCount += len(n.connectionsInStartup) // include this to prevent flooding attacks
-						n.connectionsInStartupLock.Unlock()
-						if connectionCount < p2pDesiredConnectionCount {
+					// Zones are local to a host, so link-local addresses from other nodes can't be used.
+					if len(peerMsg.Identity) > 0 && p2pAddressIsAnnounceable(peerMsg.IP) {
+						// Connections in startup are counted to prevent flooding attacks.
+						if n.p2pConnectionCount() < int(atomic.LoadInt32(&n.desiredPeers)) {
 							_ = n.Connect(peerMsg.IP, peerMsg.Port, peerMsg.Identity)
 						}
 					}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the peer management part of Node, see node.go for main object.
+
+import (
+	"bytes"
+	"sort"
+	"sync/atomic"
+)
+
+// PeerInfo describes a known or connected peer for peer management.
+type PeerInfo struct {
+	Peer
+
+	Connected                 bool   ``                  // True if there is currently a P2P connection to this peer
+	Inbound                   bool   `json:",omitempty"` // True if the current connection is inbound
+	Address                   string `json:",omitempty"` // Remote IP and port of the current connection
+	ConnectedSince            uint64 `json:",omitempty"` // Time (seconds) the current connection was established
+	BytesSent                 uint64 `json:",omitempty"` // Bytes sent over the current connection
+	BytesReceived             uint64 `json:",omitempty"` // Bytes received over the current connection
+	Known                     bool   ``                  // True if peer is in peers.json
+	Pinned                    bool   `json:",omitempty"` // Always reconnected and never forgotten
+	Banned                    bool   `json:",omitempty"` // Connections to and from this peer are refused
+	FirstConnect              uint64 `json:",omitempty"` // Time (seconds) of first connection to this peer at this endpoint
+	LastSuccessfulConnection  uint64 `json:",omitempty"` // Time (seconds) of most recent successful connection
+	LastReconnectionAttempt   uint64 `json:",omitempty"` // Time (seconds) of most recent connection attempt (zeroed on success)
+	TotalReconnectionAttempts int    `json:",omitempty"` // Connection attempts since last success
+}
+
+// ListPeers returns all known peers from peers.json and all currently connected peers, sorted by identity.
+func (n *Node) ListPeers() []PeerInfo {
+	byID := make(map[string]*PeerInfo)
+
+	n.knownPeersLock.Lock()
+	for id, kp := range n.knownPeers {
+		byID[id] = &PeerInfo{
+			Peer:                      kp.Peer,
+			Known:                     true,
+			Pinned:                    kp.Pinned,
+			Banned:                    kp.Banned,
+			FirstConnect:              kp.FirstConnect,
+			LastSuccessfulConnection:  kp.LastSuccessfulConnection,
+			LastReconnectionAttempt:   kp.LastReconnectionAttempt,
+			TotalReconnectionAttempts: kp.TotalReconnectionAttempts,
+		}
+	}
+	n.knownPeersLock.Unlock()
+
+	n.peersLock.RLock()
+	for _, p := range n.peers {
+		id := Base62Encode(p.identity)
+		pi := byID[id]
+		if pi == nil {
+			port := p.tcpAddress.Port
+			if p.inbound {
+				port = -1
+			}
+			pi = &PeerInfo{Peer: Peer{IP: p.tcpAddress.IP, Zone: p.tcpAddress.Zone, Port: port, Identity: p.identity}}
+			byID[id] = pi
+		}
+		pi.Connected = true
+		pi.Inbound = p.inbound
+		pi.Address = p.address
+		pi.ConnectedSince = p.connectedSince
+		pi.BytesSent = atomic.LoadUint64(&p.bytesSent)
+		pi.BytesReceived = atomic.LoadUint64(&p.bytesReceived)
+	}
+	n.peersLock.RUnlock()
+
+	peers := make([]PeerInfo, 0, len(byID))
+	for _, pi := range byID {
+		peers = append(peers, *pi)
+	}
+	sort.Slice(peers, func(a, b int) bool { return bytes.Compare(peers[a].Identity, peers[b].Identity) < 0 })
+	return peers
+}
+
+// PinPeer pins or unpins a peer by identity.
+// Pinned peers are reconnected whenever they are not connected and are never forgotten. A peer
+// not yet in peers.json can be pinned if its IP and port are included.
+func (n *Node) PinPeer(peer *Peer, pinned bool) error {
+	if len(peer.Identity) == 0 {
+		return ErrInvalidParameter
+	}
+	id := Base62Encode(peer.Identity)
+
+	n.knownPeersLock.Lock()
+	kp := n.knownPeers[id]
+	if len(peer.IP) > 0 {
+		if peer.Port <= 0 || peer.Port > 65535 || (peer.IP.To4() == nil && peer.IP.IsLinkLocalUnicast() && len(peer.Zone) == 0) {
+			n.knownPeersLock.Unlock()
+			return ErrInvalidParameter
+		}
+		if kp == nil {
+			kp = &knownPeer{Peer: Peer{Identity: peer.Identity}}
+			n.knownPeers[id] = kp
+		}
+		kp.IP = peer.IP
+		kp.Zone = peer.Zone
+		kp.Port = peer.Port
+	} else if kp == nil {
+		n.knownPeersLock.Unlock()
+		return ErrPeerNotFound
+	}
+	kp.Pinned = pinned
+	kp.LastReconnectionAttempt = 0
+	n.knownPeersLock.Unlock()
+
+	n.writeKnownPeers()
+	n.p2pLog[LogLevelNormal].Printf("P2P peer %s pinned: %t", id, pinned)
+	return nil
+}
+
+// BanPeer bans or unbans a peer by identity.
+// Banning a peer closes any connections to it and refuses new ones in either direction. The ban is
+// kept in peers.json even if the node has never connected to the peer.
+func (n *Node) BanPeer(identity []byte, banned bool) error {
+	if len(identity) == 0 || bytes.Equal(identity, n.identity) {
+		return ErrInvalidParameter
+	}
+	id := Base62Encode(identity)
+
+	n.knownPeersLock.Lock()
+	kp := n.knownPeers[id]
+	if kp == nil {
+		if !banned {
+			n.knownPeersLock.Unlock()
+			return ErrPeerNotFound
+		}
+		kp = &knownPeer{Peer: Peer{Identity: identity}}
+		n.knownPeers[id] = kp
+	}
+	kp.Banned = banned
+	if !banned && len(kp.IP) == 0 {
+		delete(n.knownPeers, id) // entry only existed to record the ban
+	}
+	n.knownPeersLock.Unlock()
+
+	n.writeKnownPeers()
+	if banned {
+		_ = n.DropPeer(identity)
+	}
+	n.p2pLog[LogLevelNormal].Printf("P2P peer %s banned: %t", id, banned)
+	return nil
+}
+
+// DropPeer closes any current P2P connections to a peer.
+// The peer stays in peers.json and may be reconnected later (use BanPeer to prevent this).
+func (n *Node) DropPeer(identity []byte) error {
+	found := false
+	n.peersLock.RLock()
+	for _, p := range n.peers {
+		if bytes.Equal(p.identity, identity) {
+			_ = p.c.Close()
+			found = true
+		}
+	}
+	n.peersLock.RUnlock()
+	if !found {
+		return ErrPeerNotFound
+	}
+	return nil
+}
+
+// ForgetPeer removes a peer from peers.json, including any pin or ban.
+// Current connections are not closed.
+func (n *Node) ForgetPeer(identity []byte) error {
+	id := Base62Encode(identity)
+	n.knownPeersLock.Lock()
+	_, known := n.knownPeers[id]
+	delete(n.knownPeers, id)
+	n.knownPeersLock.Unlock()
+	if !known {
+		return ErrPeerNotFound
+	}
+	n.writeKnownPeers()
+	return nil
+}
+
+// peerIsBanned returns true if a peer identity has been banned with BanPeer.
+func (n *Node) peerIsBanned(identity []byte) bool {
+	n.knownPeersLock.Lock()
+	kp := n.knownPeers[Base62Encode(identity)]
+	banned := kp != nil && kp.Banned
+	n.knownPeersLock.Unlock()
+	return banned
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the TLS part of Node and RemoteNode, see node.go and remotenode.go for main objects.
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RemoteNodeMTLSScheme is a URL scheme for HTTPS nodes that require a client certificate.
+// RemoteNode URLs with this scheme are requested over HTTPS using the client certificate set with SetRemoteNodeTLSConfig.
+const RemoteNodeMTLSScheme = "https+mtls"
+
+// NodeTLSConfig configures a node to serve its HTTP API over TLS.
+type NodeTLSConfig struct {
+	CertFile     string // PEM certificate (chain) file
+	KeyFile      string // PEM private key file
+	ClientCAFile string // If non-empty, require client certificates signed by a CA in this PEM file
+}
+
+// RemoteNodeTLSConfig configures TLS for requests made to HTTPS nodes by RemoteNode.
+type RemoteNodeTLSConfig struct {
+	RootCAFile string `json:",omitempty"` // PEM file of CAs to trust in addition to system roots
+	CertFile   string `json:",omitempty"` // PEM client certificate for https+mtls URLs
+	KeyFile    string `json:",omitempty"` // PEM client private key for https+mtls URLs
+
+	// LocalServerName, if set, is the name certificates of nodes at loopback addresses are verified against
+	// instead of the address. A node sets it in its own client.json if its certificate covers no loopback name.
+	LocalServerName string `json:",omitempty"`
+}
+
+type remoteNodeMTLSContextKey struct{}
+
+// remoteNodeTransport sends requests over transports configured by SetRemoteNodeTLSConfig.
+type remoteNodeTransport struct{}
+
+var (
+	remoteNodeTLSLock       sync.RWMutex
+	remoteNodeHTTPTransport http.RoundTripper = http.DefaultTransport
+	remoteNodeMTLSTransport http.RoundTripper
+
+	// Transports for loopback addresses if LocalServerName is set, nil otherwise.
+	remoteNodeLocalHTTPTransport http.RoundTripper
+	remoteNodeLocalMTLSTransport http.RoundTripper
+)
+
+// loadCertPool reads CA certificates from a PEM file, adding them to the system pool if addToSystemPool is true.
+func loadCertPool(caFile string, addToSystemPool bool) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	var pool *x509.CertPool
+	if addToSystemPool {
+		pool, _ = x509.SystemCertPool()
+	}
+	if pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// serverTLSConfig loads certificates and creates a TLS configuration for an HTTP server.
+func (c *NodeTLSConfig) serverTLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tc := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if len(c.ClientCAFile) > 0 {
+		tc.ClientCAs, err = loadCertPool(c.ClientCAFile, false)
+		if err != nil {
+			return nil, err
+		}
+		tc.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tc, nil
+}
+
+// newRemoteNodeHTTPTransport creates a transport like http.DefaultTransport with a custom TLS configuration.
+func newRemoteNodeHTTPTransport(tc *tls.Config) *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tc,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// SetRemoteNodeTLSConfig sets root CAs and a client certificate for all RemoteNode requests.
+// Root CAs are trusted for both https and https+mtls URLs. The client certificate is only presented
+// to https+mtls URLs. A nil config restores the defaults (system roots, no client certificate).
+// This configuration is global to the process: every RemoteNode shares the same root CAs and client
+// certificate, so one process can't use different CAs or certificates for different nodes.
+func SetRemoteNodeTLSConfig(c *RemoteNodeTLSConfig) error {
+	var httpTransport http.RoundTripper = http.DefaultTransport
+	var mtlsTransport, localHTTPTransport, localMTLSTransport http.RoundTripper
+	if c != nil {
+		tc := &tls.Config{MinVersion: tls.VersionTLS12}
+		if len(c.RootCAFile) > 0 {
+			var err error
+			tc.RootCAs, err = loadCertPool(c.RootCAFile, true)
+			if err != nil {
+				return err
+			}
+			httpTransport = newRemoteNodeHTTPTransport(tc)
+		}
+		if len(c.CertFile) > 0 || len(c.KeyFile) > 0 {
+			cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+			if err != nil {
+				return err
+			}
+			mtc := tc.Clone()
+			mtc.Certificates = []tls.Certificate{cert}
+			mtlsTransport = newRemoteNodeHTTPTransport(mtc)
+			if len(c.LocalServerName) > 0 {
+				mtc = mtc.Clone()
+				mtc.ServerName = c.LocalServerName
+				localMTLSTransport = newRemoteNodeHTTPTransport(mtc)
+			}
+		}
+		if len(c.LocalServerName) > 0 {
+			ltc := tc.Clone()
+			ltc.ServerName = c.LocalServerName
+			localHTTPTransport = newRemoteNodeHTTPTransport(ltc)
+		}
+	}
+	remoteNodeTLSLock.Lock()
+	remoteNodeHTTPTransport = httpTransport
+	remoteNodeMTLSTransport = mtlsTransport
+	remoteNodeLocalHTTPTransport = localHTTPTransport
+	remoteNodeLocalMTLSTransport = localMTLSTransport
+	remoteNodeTLSLock.Unlock()
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper, using the client certificate transport for requests made to https+mtls URLs.
+func (remoteNodeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	local := false
+	if host := req.URL.Hostname(); host == "localhost" {
+		local = true
+	} else if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		local = true
+	}
+	remoteNodeTLSLock.RLock()
+	t := remoteNodeHTTPTransport
+	if local && remoteNodeLocalHTTPTransport != nil {
+		t = remoteNodeLocalHTTPTransport
+	}
+	if mtls, _ := req.Context().Value(remoteNodeMTLSContextKey{}).(bool); mtls {
+		t = remoteNodeMTLSTransport
+		if local && remoteNodeLocalMTLSTransport != nil {
+			t = remoteNodeLocalMTLSTransport
+		}
+	}
+	remoteNodeTLSLock.RUnlock()
+	if t == nil {
+		if req.Body != nil {
+			_ = req.Body.Close()
+		}
+		return nil, ErrTLSClientCertificateRequired
+	}
+	return t.RoundTrip(req)
+}
+
+// withRemoteNodeMTLS marks a request as requiring a client certificate.
+func withRemoteNodeMTLS(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), remoteNodeMTLSContextKey{}, true))
+}
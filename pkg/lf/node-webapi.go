@@ -16,6 +16,7 @@ package lf
 // This is the HTTP API parts of Node, see node.go for main object.
 
 import (
+	"crypto/subtle"
 	"encoding/json"
 	"io"
 	"net"
@@ -67,22 +68,17 @@ func apiSendObj(out http.ResponseWriter, req *http.Request, httpStatusCode int,
 	}
 }
 
+// apiMaxRequestSize is the maximum size of a JSON request body.
+const apiMaxRequestSize = 4194304
+
 func apiReadObj(out http.ResponseWriter, req *http.Request, dest interface{}) (err error) {
-	err = json.NewDecoder(req.Body).Decode(&dest)
+	err = json.NewDecoder(http.MaxBytesReader(out, req.Body, apiMaxRequestSize)).Decode(&dest)
 	if err != nil {
 		apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "invalid or malformed payload: " + err.Error()})
 	}
 	return
 }
 
-func (n *Node) apiIsTrusted(req *http.Request) bool {
-	ip, _, err := net.SplitHostPort(req.RemoteAddr)
-	if err != nil {
-		return false
-	}
-	return net.ParseIP(ip).IsLoopback()
-}
-
 func (n *Node) createHTTPServeMux() *http.ServeMux {
 	smux := http.NewServeMux()
 
@@ -104,6 +100,24 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 		}
 	})
 
+	smux.HandleFunc("/query/batch", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m QueryBatch
+			if apiReadObj(out, req, &m) == nil {
+				results, err := m.execute(n)
+				if err != nil {
+					apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "query batch failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+				} else {
+					apiSendObj(out, req, http.StatusOK, results)
+				}
+			}
+		} else {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
 	smux.HandleFunc("/post", func(out http.ResponseWriter, req *http.Request) {
 		apiSetStandardHeaders(out)
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
@@ -146,10 +160,10 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 	smux.HandleFunc("/makerecord", func(out http.ResponseWriter, req *http.Request) {
 		apiSetStandardHeaders(out)
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
-			if n.apiIsTrusted(req) {
+			if n.apiIsAuthorized(req, APITokenScopeMakeRecord) {
 				var m MakeRecord
 				if apiReadObj(out, req, &m) == nil {
-					rec, pulse, ok, err := m.execute(n)
+					rec, pulse, ok, err := m.execute(req.Context(), n)
 					if err != nil {
 						apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "record creation failed: " + err.Error()})
 					} else {
@@ -157,7 +171,50 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 					}
 				}
 			} else {
-				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: "only trusted clients can delegate record creation"})
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: "only trusted clients or tokens with makerecord scope can delegate record creation"})
+			}
+		} else {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/work", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m MakeWork
+			if apiReadObj(out, req, &m) == nil {
+				if n.apiIsAuthorized(req, APITokenScopeMakeRecord) || (len(m.AuthToken) > 0 && subtle.ConstantTimeCompare([]byte(m.AuthToken), []byte(n.workAuthToken)) == 1) {
+					client, _, _ := net.SplitHostPort(req.RemoteAddr)
+					result, err := m.execute(req.Context(), n, client)
+					if err == ErrWorkQueueFull {
+						apiSendObj(out, req, http.StatusTooManyRequests, &ErrAPI{Code: http.StatusTooManyRequests, Message: "proof of work failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+					} else if err != nil {
+						apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "proof of work failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+					} else {
+						apiSendObj(out, req, http.StatusOK, result)
+					}
+				} else {
+					apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "valid work token required to delegate proof of work"})
+				}
+			}
+		} else {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/estimate", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m Estimate
+			if apiReadObj(out, req, &m) == nil {
+				result, err := m.execute(n)
+				if err != nil {
+					apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "estimate failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+				} else {
+					apiSendObj(out, req, http.StatusOK, result)
+				}
 			}
 		} else {
 			out.Header().Set("Allow", "POST, PUT")
@@ -170,7 +227,7 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
 			var m MakePulse
 			if apiReadObj(out, req, &m) == nil {
-				pulse, rec, ok, err := m.execute(n)
+				pulse, rec, ok, err := m.execute(req.Context(), n)
 				if err != nil {
 					apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "record creation failed: " + err.Error()})
 				} else {
@@ -186,14 +243,17 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 	smux.HandleFunc("/connect", func(out http.ResponseWriter, req *http.Request) {
 		apiSetStandardHeaders(out)
 		if req.Method == http.MethodPost || req.Method == http.MethodPut {
-			if n.apiIsTrusted(req) {
+			if n.apiIsAuthorized(req, APITokenScopeConnect) {
 				var m Peer
 				if apiReadObj(out, req, &m) == nil {
-					_ = n.Connect(m.IP, m.Port, m.Identity)
-					apiSendObj(out, req, http.StatusOK, nil)
+					if err := n.ConnectPeer(&m); err != nil {
+						apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "invalid P2P endpoint: " + err.Error(), ErrTypeName: errTypeName(err)})
+					} else {
+						apiSendObj(out, req, http.StatusOK, nil)
+					}
 				}
 			} else {
-				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: "only trusted clients can suggest P2P endpoints"})
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: "only trusted clients or tokens with connect scope can suggest P2P endpoints"})
 			}
 		} else {
 			out.Header().Set("Allow", "POST, PUT")
@@ -201,6 +261,158 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 		}
 	})
 
+	smux.HandleFunc("/comment", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			if n.apiIsAuthorized(req, APITokenScopeOracleFlag) {
+				var m Comment
+				if apiReadObj(out, req, &m) == nil {
+					err := m.execute(n)
+					if err != nil {
+						apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "comment rejected: " + err.Error(), ErrTypeName: errTypeName(err)})
+					} else {
+						apiSendObj(out, req, http.StatusOK, nil)
+					}
+				}
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients or tokens with oracle-flag scope can submit comments"})
+			}
+		} else {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/comments", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m CommentQuery
+			if apiReadObj(out, req, &m) == nil {
+				comments, err := m.execute(n)
+				if err != nil {
+					apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "comment query failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+				} else {
+					apiSendObj(out, req, http.StatusOK, comments)
+				}
+			}
+		} else {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/token/create", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			if n.apiIsAuthorized(req, APITokenScopeAdmin) {
+				var m APITokenCreate
+				if apiReadObj(out, req, &m) == nil {
+					t, err := n.CreateAPIToken(&m)
+					if err != nil {
+						apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "token creation failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+					} else {
+						apiSendObj(out, req, http.StatusOK, t)
+					}
+				}
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients or tokens with admin scope can manage tokens"})
+			}
+		} else {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/token/revoke", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			if n.apiIsAuthorized(req, APITokenScopeAdmin) {
+				var m APITokenRevoke
+				if apiReadObj(out, req, &m) == nil {
+					err := n.RevokeAPIToken(m.Name)
+					if err == ErrAPITokenNotFound {
+						apiSendObj(out, req, http.StatusNotFound, &ErrAPI{Code: http.StatusNotFound, Message: err.Error(), ErrTypeName: errTypeName(err)})
+					} else if err != nil {
+						apiSendObj(out, req, http.StatusInternalServerError, &ErrAPI{Code: http.StatusInternalServerError, Message: "token revocation failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+					} else {
+						apiSendObj(out, req, http.StatusOK, nil)
+					}
+				}
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients or tokens with admin scope can manage tokens"})
+			}
+		} else {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/token/list", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			if n.apiIsAuthorized(req, APITokenScopeAdmin) {
+				apiSendObj(out, req, http.StatusOK, n.ListAPITokens())
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients or tokens with admin scope can manage tokens"})
+			}
+		} else {
+			out.Header().Set("Allow", "GET, HEAD")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/peer/", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if !n.apiIsAuthorized(req, APITokenScopeAdmin) {
+			apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients or tokens with admin scope can manage peers"})
+			return
+		}
+		op := strings.TrimPrefix(req.URL.Path, "/peer/")
+		if op == "list" {
+			if req.Method == http.MethodGet || req.Method == http.MethodHead {
+				apiSendObj(out, req, http.StatusOK, n.ListPeers())
+			} else {
+				out.Header().Set("Allow", "GET, HEAD")
+				apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			}
+			return
+		}
+		if req.Method != http.MethodPost && req.Method != http.MethodPut {
+			out.Header().Set("Allow", "POST, PUT")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+			return
+		}
+		var m Peer
+		if apiReadObj(out, req, &m) != nil {
+			return
+		}
+		var err error
+		switch op {
+		case "pin":
+			err = n.PinPeer(&m, true)
+		case "unpin":
+			err = n.PinPeer(&m, false)
+		case "drop":
+			err = n.DropPeer(m.Identity)
+		case "forget":
+			err = n.ForgetPeer(m.Identity)
+		case "ban":
+			err = n.BanPeer(m.Identity, true)
+		case "unban":
+			err = n.BanPeer(m.Identity, false)
+		default:
+			apiSendObj(out, req, http.StatusNotFound, &ErrAPI{Code: http.StatusNotFound, Message: req.URL.Path + " not found"})
+			return
+		}
+		if err == ErrPeerNotFound {
+			apiSendObj(out, req, http.StatusNotFound, &ErrAPI{Code: http.StatusNotFound, Message: err.Error(), ErrTypeName: errTypeName(err)})
+		} else if err != nil {
+			apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "peer " + op + " failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+		} else {
+			apiSendObj(out, req, http.StatusOK, nil)
+		}
+	})
+
 	smux.HandleFunc("/record/raw/", func(out http.ResponseWriter, req *http.Request) {
 		apiSetStandardHeaders(out)
 		if req.Method == http.MethodGet || req.Method == http.MethodHead {
@@ -297,6 +509,36 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 		}
 	})
 
+	smux.HandleFunc("/healthz", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			h := n.Health()
+			if h.Healthy {
+				apiSendObj(out, req, http.StatusOK, h)
+			} else {
+				apiSendObj(out, req, http.StatusServiceUnavailable, h)
+			}
+		} else {
+			out.Header().Set("Allow", "GET, HEAD")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
+	smux.HandleFunc("/readyz", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			r := n.Readiness()
+			if r.Ready {
+				apiSendObj(out, req, http.StatusOK, r)
+			} else {
+				apiSendObj(out, req, http.StatusServiceUnavailable, r)
+			}
+		} else {
+			out.Header().Set("Allow", "GET, HEAD")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
 	smux.HandleFunc("/dumprecords", func(out http.ResponseWriter, req *http.Request) {
 		apiSetStandardHeaders(out)
 		if req.Method == http.MethodGet || req.Method == http.MethodHead {
@@ -317,6 +559,38 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 		}
 	})
 
+	smux.HandleFunc("/export", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			var offset uint64
+			var err error
+			if offsetStr := req.URL.Query().Get("offset"); len(offsetStr) > 0 {
+				offset, err = strconv.ParseUint(offsetStr, 10, 64)
+				if err != nil {
+					apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "invalid offset"})
+					return
+				}
+			} else if hashStr := req.URL.Query().Get("hash"); len(hashStr) > 0 {
+				offset, err = n.ExportOffsetByHash(Base62Decode(strings.TrimPrefix(hashStr, "=")))
+				if err != nil {
+					apiSendObj(out, req, http.StatusNotFound, &ErrAPI{Code: http.StatusNotFound, Message: err.Error(), ErrTypeName: errTypeName(err)})
+					return
+				}
+			}
+			out.Header().Set("Content-Type", "application/octet-stream")
+			out.WriteHeader(http.StatusOK)
+			if req.Method == http.MethodGet {
+				defer httpLiftWriteTimeout(req)() // exports can take much longer than the server's write timeout
+				if err = n.ExportRecords(out, offset); err != nil {
+					n.apiLog[LogLevelVerbose].Printf("API export from offset %d ended early: %s", offset, err.Error())
+				}
+			}
+		} else {
+			out.Header().Set("Allow", "GET, HEAD")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
 	smux.HandleFunc("/owner/", func(out http.ResponseWriter, req *http.Request) {
 		apiSetStandardHeaders(out)
 		if req.Method == http.MethodGet || req.Method == http.MethodHead {
@@ -342,6 +616,22 @@ func (n *Node) createHTTPServeMux() *http.ServeMux {
 		}
 	})
 
+	smux.Handle("/ws", n.createWebSocketServer())
+
+	smux.HandleFunc("/openapi.json", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			out.Header().Set("Content-Type", "application/json")
+			out.WriteHeader(http.StatusOK)
+			if req.Method != http.MethodHead {
+				_, _ = out.Write(openAPIJSON())
+			}
+		} else {
+			out.Header().Set("Allow", "GET, HEAD")
+			apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+		}
+	})
+
 	smux.HandleFunc("/", func(out http.ResponseWriter, req *http.Request) {
 		apiSetStandardHeaders(out)
 		if req.Method == http.MethodGet || req.Method == http.MethodHead {
@@ -0,0 +1,411 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the WebSocket and CORS part of Node, see node.go for main object.
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocket request and response types.
+const (
+	WebSocketTypeQuery       = "query"       // Run a query (request: Query, response: Results)
+	WebSocketTypePost        = "post"        // Submit a binary record (request: Record, response: Record)
+	WebSocketTypePulse       = "pulse"       // Submit a binary pulse (request: Pulse, response: Accepted)
+	WebSocketTypeSubscribe   = "subscribe"   // Receive matching records as they're synchronized (request: Subscribe)
+	WebSocketTypeUnsubscribe = "unsubscribe" // Cancel the subscription with this request's ID
+	WebSocketTypePing        = "ping"        // Do nothing but respond, e.g. to keep a connection alive
+	WebSocketTypeRecord      = "record"      // Response containing a record for the subscription with this ID
+)
+
+const (
+	webSocketMaxSessions              = 1024
+	webSocketMaxSubscriptions         = 64
+	webSocketMaxConcurrentRequests    = 16
+	webSocketMaxRequestSize           = 4194304
+	webSocketSendQueueSize            = 256
+	webSocketIdleTimeout              = 5 * time.Minute
+	webSocketWriteTimeout             = 30 * time.Second
+	webSocketCORSPreflightMaxAgeStr   = "600"
+	webSocketCORSAllowedMethodsHeader = "GET, HEAD, POST, PUT"
+)
+
+// WebSocketRequest is a JSON frame sent by a client over a WebSocket session.
+// Requests are handled concurrently and responses may arrive in any order, so clients should use
+// unique IDs to match them up.
+type WebSocketRequest struct {
+	ID        string                 `json:",omitempty"` // Client-chosen ID echoed in responses
+	Type      string                 ``                  // Request type
+	Query     *Query                 `json:",omitempty"` // Query to run (query)
+	Record    Blob                   `json:",omitempty"` // Record in binary form (post)
+	Pulse     Blob                   `json:",omitempty"` // Pulse in binary form (pulse)
+	Subscribe *WebSocketSubscription `json:",omitempty"` // Records to receive (subscribe)
+}
+
+// WebSocketSubscription selects records to send to a WebSocket client as they're synchronized.
+// Records must match all ranges in order (records may have more selectors) and if any owners are
+// given must be owned by one of them. Only records of normal or better reputation are sent.
+type WebSocketSubscription struct {
+	Ranges []QueryRange  `json:",omitempty"` // Selector ranges as in Query
+	Owners []OwnerPublic `json:",omitempty"` // Owners to include (default: all)
+}
+
+// WebSocketResponse is a JSON frame sent by a node over a WebSocket session.
+type WebSocketResponse struct {
+	ID       string       `json:",omitempty"` // ID of request or subscription
+	Type     string       ``                  // Request type or record for subscription results
+	Error    *ErrAPI      `json:",omitempty"` // Error if request failed
+	Results  QueryResults `json:",omitempty"` // Results (query)
+	Record   *Record      `json:",omitempty"` // Posted record (post) or record matching a subscription (record)
+	Accepted bool         `json:",omitempty"` // True if pulse was accepted (pulse)
+}
+
+type webSocketSubscription struct {
+	selectorRanges [][2][]byte
+	owners         []OwnerPublic
+}
+
+type webSocketSession struct {
+	n                 *Node
+	conn              *websocket.Conn
+	out               chan *WebSocketResponse
+	done              chan struct{}
+	closed            uint32
+	subscriptions     map[string]*webSocketSubscription
+	subscriptionsLock sync.Mutex
+}
+
+// matches returns true if a record should be sent to this subscription.
+func (s *webSocketSubscription) matches(r *Record) bool {
+	if len(s.owners) > 0 {
+		found := false
+		for _, o := range s.owners {
+			if bytes.Equal(o, r.Owner) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Selectors) < len(s.selectorRanges) {
+		return false
+	}
+	for i := range s.selectorRanges {
+		sk := r.SelectorKey(i)
+		if bytes.Compare(sk, s.selectorRanges[i][0]) < 0 || bytes.Compare(sk, s.selectorRanges[i][1]) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// send queues a response, closing the session if the client isn't keeping up.
+func (s *webSocketSession) send(r *WebSocketResponse) {
+	select {
+	case s.out <- r:
+	default:
+		s.n.apiLog[LogLevelVerbose].Printf("websocket: closing session with %s (send queue full)", s.conn.Request().RemoteAddr)
+		s.close()
+	}
+}
+
+func (s *webSocketSession) close() {
+	if atomic.SwapUint32(&s.closed, 1) == 0 {
+		close(s.done)
+		_ = s.conn.Close()
+	}
+}
+
+func (s *webSocketSession) writer() {
+	for {
+		select {
+		case r := <-s.out:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+			if websocket.JSON.Send(s.conn, r) != nil {
+				s.close()
+				return
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func webSocketError(req *WebSocketRequest, code int, err error) *WebSocketResponse {
+	return &WebSocketResponse{ID: req.ID, Type: req.Type, Error: &ErrAPI{Code: code, Message: err.Error(), ErrTypeName: errTypeName(err)}}
+}
+
+// handle executes a request and returns its response.
+func (s *webSocketSession) handle(req *WebSocketRequest) *WebSocketResponse {
+	n := s.n
+	switch req.Type {
+
+	case WebSocketTypeQuery:
+		if req.Query == nil {
+			return webSocketError(req, http.StatusBadRequest, ErrInvalidParameter)
+		}
+		results, err := req.Query.execute(n)
+		if err != nil {
+			return webSocketError(req, http.StatusBadRequest, err)
+		}
+		return &WebSocketResponse{ID: req.ID, Type: req.Type, Results: results}
+
+	case WebSocketTypePost:
+		rec, err := NewRecordFromBytes(req.Record)
+		if err != nil {
+			return webSocketError(req, http.StatusBadRequest, err)
+		}
+		err = n.AddRecord(rec)
+		if err != nil && err != ErrDuplicateRecord {
+			return webSocketError(req, http.StatusBadRequest, err)
+		}
+		return &WebSocketResponse{ID: req.ID, Type: req.Type, Record: rec}
+
+	case WebSocketTypePulse:
+		if len(req.Pulse) != PulseSize {
+			return webSocketError(req, http.StatusBadRequest, ErrInvalidParameter)
+		}
+		ok, _ := n.DoPulse(Pulse(req.Pulse), true)
+		return &WebSocketResponse{ID: req.ID, Type: req.Type, Accepted: ok}
+
+	case WebSocketTypeSubscribe:
+		if len(req.ID) == 0 || req.Subscribe == nil {
+			return webSocketError(req, http.StatusBadRequest, ErrInvalidParameter)
+		}
+		selectorRanges, err := querySelectorKeyRanges(req.Subscribe.Ranges)
+		if err != nil {
+			return webSocketError(req, http.StatusBadRequest, err)
+		}
+		s.subscriptionsLock.Lock()
+		_, exists := s.subscriptions[req.ID]
+		if !exists && len(s.subscriptions) >= webSocketMaxSubscriptions {
+			s.subscriptionsLock.Unlock()
+			return webSocketError(req, http.StatusTooManyRequests, ErrInvalidParameter)
+		}
+		s.subscriptions[req.ID] = &webSocketSubscription{selectorRanges: selectorRanges, owners: req.Subscribe.Owners}
+		s.subscriptionsLock.Unlock()
+		return &WebSocketResponse{ID: req.ID, Type: req.Type}
+
+	case WebSocketTypeUnsubscribe:
+		s.subscriptionsLock.Lock()
+		_, exists := s.subscriptions[req.ID]
+		delete(s.subscriptions, req.ID)
+		s.subscriptionsLock.Unlock()
+		if !exists {
+			return webSocketError(req, http.StatusNotFound, ErrInvalidParameter)
+		}
+		return &WebSocketResponse{ID: req.ID, Type: req.Type}
+
+	case WebSocketTypePing:
+		return &WebSocketResponse{ID: req.ID, Type: req.Type}
+
+	}
+	return &WebSocketResponse{ID: req.ID, Type: req.Type, Error: &ErrAPI{Code: http.StatusBadRequest, Message: "unrecognized request type"}}
+}
+
+// webSocketHandler runs a session, reading requests until the client disconnects or goes idle.
+func (n *Node) webSocketHandler(conn *websocket.Conn) {
+	s := &webSocketSession{
+		n:             n,
+		conn:          conn,
+		out:           make(chan *WebSocketResponse, webSocketSendQueueSize),
+		done:          make(chan struct{}),
+		subscriptions: make(map[string]*webSocketSubscription),
+	}
+	conn.MaxPayloadBytes = webSocketMaxRequestSize
+
+	n.webSocketSessionsLock.Lock()
+	if len(n.webSocketSessions) >= webSocketMaxSessions || atomic.LoadUint32(&n.shutdown) != 0 {
+		n.webSocketSessionsLock.Unlock()
+		_ = websocket.JSON.Send(conn, &WebSocketResponse{Error: &ErrAPI{Code: http.StatusServiceUnavailable, Message: "too many WebSocket sessions"}})
+		return
+	}
+	n.webSocketSessions[s] = true
+	n.webSocketSessionsLock.Unlock()
+	defer func() {
+		n.webSocketSessionsLock.Lock()
+		delete(n.webSocketSessions, s)
+		n.webSocketSessionsLock.Unlock()
+		s.close()
+	}()
+
+	n.apiLog[LogLevelVerbose].Printf("websocket: new session with %s", conn.Request().RemoteAddr)
+	go s.writer()
+
+	// The HTTP server's deadlines still apply to the hijacked connection, so they're replaced here.
+	_ = conn.SetDeadline(time.Time{})
+	inFlight := make(chan struct{}, webSocketMaxConcurrentRequests)
+	for atomic.LoadUint32(&s.closed) == 0 {
+		_ = conn.SetReadDeadline(time.Now().Add(webSocketIdleTimeout))
+		var req WebSocketRequest
+		err := websocket.JSON.Receive(conn, &req)
+		if err != nil {
+			switch err.(type) {
+			case *json.SyntaxError, *json.UnmarshalTypeError:
+				s.send(&WebSocketResponse{Error: &ErrAPI{Code: http.StatusBadRequest, Message: "invalid or malformed payload: " + err.Error()}})
+				continue
+			}
+			break
+		}
+		inFlight <- struct{}{}
+		go func(req *WebSocketRequest) {
+			defer func() { <-inFlight }()
+			s.send(s.handle(req))
+		}(&req)
+	}
+}
+
+// webSocketNotify sends a newly synchronized record to all matching subscriptions.
+func (n *Node) webSocketNotify(r *Record) {
+	n.webSocketSessionsLock.Lock()
+	sessions := make([]*webSocketSession, 0, len(n.webSocketSessions))
+	for s := range n.webSocketSessions {
+		sessions = append(sessions, s)
+	}
+	n.webSocketSessionsLock.Unlock()
+
+	for _, s := range sessions {
+		var ids []string
+		s.subscriptionsLock.Lock()
+		for id, sub := range s.subscriptions {
+			if sub.matches(r) {
+				ids = append(ids, id)
+			}
+		}
+		s.subscriptionsLock.Unlock()
+		for _, id := range ids {
+			s.send(&WebSocketResponse{ID: id, Type: WebSocketTypeRecord, Record: r})
+		}
+	}
+}
+
+// closeWebSockets closes all WebSocket sessions, which aren't closed when the HTTP server is closed.
+func (n *Node) closeWebSockets() {
+	n.webSocketSessionsLock.Lock()
+	for s := range n.webSocketSessions {
+		s.close()
+	}
+	n.webSocketSessionsLock.Unlock()
+}
+
+// SetAllowedOrigins sets the web origins (e.g. https://app.example.com) allowed to use the HTTP API
+// from browsers via CORS and WebSockets. An origin of * allows any origin (except for privileged
+// paths, see apiPrivilegedPaths) and nil allows none.
+// Clients that send no Origin header (anything but browsers) are not affected.
+func (n *Node) SetAllowedOrigins(origins []string) {
+	var o []string
+	for _, origin := range origins {
+		origin = strings.TrimRight(strings.ToLower(strings.TrimSpace(origin)), "/")
+		if len(origin) > 0 {
+			o = append(o, origin)
+		}
+	}
+	n.allowedOriginsLock.Lock()
+	n.allowedOrigins = o
+	n.allowedOriginsLock.Unlock()
+}
+
+// apiPrivilegedPaths are API paths that require a token or a local client (path prefixes if they end in /).
+// CORS access to these is only granted to origins listed explicitly, never via *.
+var apiPrivilegedPaths = []string{"/makerecord", "/work", "/connect", "/comment", "/token/", "/peer/"}
+
+// apiPathIsPrivileged returns true if a request path, with or without the API version prefix, is in apiPrivilegedPaths.
+func apiPathIsPrivileged(p string) bool {
+	p = strings.TrimPrefix(p, apiVersionPrefix)
+	for _, pp := range apiPrivilegedPaths {
+		if p == pp || (strings.HasSuffix(pp, "/") && strings.HasPrefix(p, pp)) {
+			return true
+		}
+	}
+	return false
+}
+
+// originAllowed returns true if a web origin is allowed to use the API.
+// If wildcard is false only explicitly listed origins are allowed and * is ignored.
+func (n *Node) originAllowed(origin string, wildcard bool) bool {
+	origin = strings.ToLower(origin)
+	n.allowedOriginsLock.RLock()
+	defer n.allowedOriginsLock.RUnlock()
+	for _, o := range n.allowedOrigins {
+		if (wildcard && o == "*") || o == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// apiCORSHandler adds CORS headers for allowed origins and answers CORS preflight requests.
+// Origins allowed by * are not allowed to use privileged paths, and browsers never get loopback trust (see apiIsAuthorized).
+func (n *Node) apiCORSHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(out http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if len(origin) == 0 {
+			h.ServeHTTP(out, req)
+			return
+		}
+		out.Header().Add("Vary", "Origin")
+		allowed := n.originAllowed(origin, !apiPathIsPrivileged(req.URL.Path))
+		if allowed {
+			hdr := out.Header()
+			hdr.Set("Access-Control-Allow-Origin", origin)
+			hdr.Set("Access-Control-Allow-Methods", webSocketCORSAllowedMethodsHeader)
+			hdr.Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			hdr.Set("Access-Control-Expose-Headers", "X-LF-Version, X-LF-APIVersion, X-LF-Time, Retry-After")
+			hdr.Set("Access-Control-Max-Age", webSocketCORSPreflightMaxAgeStr)
+		}
+		if req.Method == http.MethodOptions && len(req.Header.Get("Access-Control-Request-Method")) > 0 {
+			if allowed {
+				out.WriteHeader(http.StatusNoContent)
+			} else {
+				apiSetStandardHeaders(out)
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "origin not allowed"})
+			}
+			return
+		}
+		h.ServeHTTP(out, req)
+	})
+}
+
+// createWebSocketServer creates the handler for WebSocket sessions, which checks the origin of browser clients.
+func (n *Node) createWebSocketServer() http.Handler {
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) (err error) {
+			config.Origin, err = websocket.Origin(config, req)
+			if err == nil && config.Origin != nil && !n.originAllowed(config.Origin.Scheme+"://"+config.Origin.Host, true) {
+				err = ErrAPI{Code: http.StatusForbidden, Message: "origin not allowed"}
+			}
+			return
+		},
+		Handler: n.webSocketHandler,
+	}
+	return http.HandlerFunc(func(out http.ResponseWriter, req *http.Request) {
+		if _, canHijack := out.(http.Hijacker); !canHijack || !strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			apiSetStandardHeaders(out)
+			apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "WebSocket upgrade required"})
+			return
+		}
+		server.ServeHTTP(out, req)
+	})
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This is the remote proof of work service parts of Node, see node.go for main object.
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// workQueueMaxLength is the maximum number of jobs that can be queued or running at once.
+	workQueueMaxLength = 64
+
+	// workQueueMaxPerClient is the maximum number of queued or running jobs per client.
+	workQueueMaxPerClient = 2
+
+	// workBenchmarkDuration is how long the node benchmarks its work function for estimates. Jobs that run at
+	// least this long also measure the search rate.
+	workBenchmarkDuration = time.Second * 2
+)
+
+// workJob is a single queued request for proof of work.
+type workJob struct {
+	workHash   []byte
+	difficulty uint32
+	client     string                     // client identity for quota purposes (usually remote IP)
+	element    *list.Element              // element in n.workQueue while queued
+	cancel     context.CancelFunc         // cancels computation while running
+	work       [WharrgarblOutputSize]byte //
+	iterations uint64                     //
+	err        error                      //
+	done       chan struct{}              // closed when job is done, failed, or dropped
+}
+
+// doWork queues a proof of work job and waits for it to complete or for ctx to be canceled.
+func (n *Node) doWork(ctx context.Context, client string, workHash []byte, difficulty uint32) (work [WharrgarblOutputSize]byte, iterations uint64, err error) {
+	j := &workJob{
+		workHash:   workHash,
+		difficulty: difficulty,
+		client:     client,
+		done:       make(chan struct{}),
+	}
+
+	n.workQueueLock.Lock()
+	if atomic.LoadUint32(&n.shutdown) != 0 {
+		n.workQueueLock.Unlock()
+		err = ErrWorkCanceled
+		return
+	}
+	if n.workQueue.Len() >= workQueueMaxLength || n.workJobsByClient[client] >= workQueueMaxPerClient {
+		n.workQueueLock.Unlock()
+		err = ErrWorkQueueFull
+		return
+	}
+	n.workJobsByClient[client]++
+	j.element = n.workQueue.PushBack(j)
+	n.workQueueCond.Signal()
+	n.workQueueLock.Unlock()
+
+	select {
+	case <-j.done:
+		work, iterations, err = j.work, j.iterations, j.err
+	case <-ctx.Done():
+		n.cancelWork(j)
+		err = ErrWorkCanceled
+	}
+	return
+}
+
+// cancelWork drops a job from the queue or aborts it if it is currently running.
+func (n *Node) cancelWork(j *workJob) {
+	n.workQueueLock.Lock()
+	if n.workCurrentJob == j {
+		j.cancel()
+	} else if j.element != nil {
+		n.workQueue.Remove(j.element)
+		j.element = nil
+		n.workJobDoneLocked(j)
+		j.err = ErrWorkCanceled
+		close(j.done)
+	}
+	n.workQueueLock.Unlock()
+}
+
+// workJobDoneLocked decrements a job's client's quota count; workQueueLock must be held.
+func (n *Node) workJobDoneLocked(j *workJob) {
+	if c := n.workJobsByClient[j.client]; c <= 1 {
+		delete(n.workJobsByClient, j.client)
+	} else {
+		n.workJobsByClient[j.client] = c - 1
+	}
+}
+
+// backgroundThreadWorkService runs queued remote proof of work jobs one at a time.
+func (n *Node) backgroundThreadWorkService() {
+	defer n.backgroundThreadWG.Done()
+
+	// This waits for the table the maintenance thread is initializing so jobs don't generate one without the cache.
+	WharrgarblInitTable(path.Join(n.basePath, "wharrgarbl-table.bin"))
+
+	for {
+		n.workQueueLock.Lock()
+		for n.workQueue.Len() == 0 && atomic.LoadUint32(&n.shutdown) == 0 {
+			n.workQueueCond.Wait()
+		}
+		if atomic.LoadUint32(&n.shutdown) != 0 {
+			for e := n.workQueue.Front(); e != nil; e = e.Next() {
+				j := e.Value.(*workJob)
+				j.element = nil
+				j.err = ErrWorkCanceled
+				close(j.done)
+			}
+			n.workQueue.Init()
+			n.workQueueLock.Unlock()
+			return
+		}
+		j := n.workQueue.Remove(n.workQueue.Front()).(*workJob)
+		j.element = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		j.cancel = cancel
+		n.workCurrentJob = j
+		wf := n.workServiceFunctionLocked()
+		n.workQueueLock.Unlock()
+
+		n.oracleLog[LogLevelVerbose].Printf("work: computing proof of work with difficulty %.8x for %s", j.difficulty, j.client)
+		startTime := time.Now()
+		j.work, j.iterations, j.err = wf.ComputeContext(ctx, j.workHash, j.difficulty, nil)
+		if j.err == context.Canceled {
+			j.err = ErrWorkCanceled
+		}
+		cancel()
+
+		// A job that ran long enough is as good as a benchmark, so estimates can use its rate.
+		if elapsed := time.Since(startTime); j.err == nil && elapsed >= workBenchmarkDuration {
+			n.setWorkIterationsPerSecond(float64(j.iterations)/elapsed.Seconds(), "measured")
+		}
+
+		n.workQueueLock.Lock()
+		n.workCurrentJob = nil
+		n.workJobDoneLocked(j)
+		n.workQueueLock.Unlock()
+		close(j.done)
+	}
+}
+
+// workServiceFunctionLocked returns the work function for jobs, creating it if needed; workQueueLock must be held.
+func (n *Node) workServiceFunctionLocked() *Wharrgarblr {
+	if n.workServiceFunction == nil {
+		n.workServiceFunction = NewWharrgarblr(RecordDefaultWharrgarblMemory, 0)
+	}
+	return n.workServiceFunction
+}
+
+// setWorkIterationsPerSecond caches this node's Wharrgarbl search rate if it hasn't been measured yet.
+func (n *Node) setWorkIterationsPerSecond(rate float64, how string) {
+	if rate > 0.0 && atomic.CompareAndSwapUint64(&n.workBenchmarkRate, 0, math.Float64bits(rate)) {
+		n.oracleLog[LogLevelNormal].Printf("work: %s proof of work at %.0f iterations/second", how, rate)
+	}
+}
+
+// workIterationsPerSecond returns this node's Wharrgarbl search rate, benchmarking the work function used for jobs
+// the first time it's needed and caching the result. If jobs are queued or running it returns 0 instead of waiting
+// for them, since the benchmark would have to wait for the work function and those jobs will measure the rate.
+func (n *Node) workIterationsPerSecond() float64 {
+	if rate := math.Float64frombits(atomic.LoadUint64(&n.workBenchmarkRate)); rate > 0.0 {
+		return rate
+	}
+
+	n.workBenchmarkLock.Lock()
+	defer n.workBenchmarkLock.Unlock()
+	if rate := math.Float64frombits(atomic.LoadUint64(&n.workBenchmarkRate)); rate > 0.0 {
+		return rate
+	}
+	n.workQueueLock.Lock()
+	busy := n.workCurrentJob != nil || n.workQueue.Len() > 0 || atomic.LoadUint32(&n.shutdown) != 0
+	wf := n.workServiceFunctionLocked()
+	n.workQueueLock.Unlock()
+	if busy {
+		return 0.0
+	}
+
+	n.setWorkIterationsPerSecond(wf.Benchmark(workBenchmarkDuration), "benchmarked")
+	return math.Float64frombits(atomic.LoadUint64(&n.workBenchmarkRate))
+}
@@ -17,7 +17,9 @@ import (
 	"bufio"
 	"bytes"
 	"container/list"
+	"context"
 	"crypto/ecdsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -57,12 +59,20 @@ type Node struct {
 	basePath                   string
 	peersFilePath              string
 	p2pPort                    int
-	httpPort                   int
 	localTest                  bool
-	log                        [logLevelCount]*log.Logger
-	httpTCPListener            *net.TCPListener
-	httpServer                 *http.Server
-	p2pTCPListener             *net.TCPListener
+	logger                     *log.Logger                // Destination for text output (nil to discard all logs)
+	logJSON                    *log.Logger                // Same destination without prefix or flags for JSON output
+	log                        [logLevelCount]*log.Logger // Node subsystem (see node-log.go)
+	p2pLog                     [logLevelCount]*log.Logger //
+	syncLog                    [logLevelCount]*log.Logger //
+	dbLog                      [logLevelCount]*log.Logger //
+	oracleLog                  [logLevelCount]*log.Logger //
+	apiLog                     [logLevelCount]*log.Logger //
+	httpHandler                http.Handler
+	httpTLSConfig              *tls.Config
+	httpListeners              []net.Listener
+	httpServers                []*http.Server
+	p2pListeners               []*net.TCPListener
 	workFunction               *Wharrgarblr
 	workFunctionLock           sync.Mutex
 	makeRecordWorkFunction     *Wharrgarblr
@@ -74,6 +84,24 @@ type Node struct {
 	identityStr  string // Identity in base62 format
 	apiAuthToken string // Secret auth token for HTTP API privileged commands
 
+	apiTokens     map[string]*apiToken // Named API tokens by name (see node-apitoken.go)
+	apiTokensLock sync.Mutex           //
+
+	webSocketSessions     map[*webSocketSession]bool // Active WebSocket sessions (see node-websocket.go)
+	webSocketSessionsLock sync.Mutex                 //
+	allowedOrigins        []string                   // Web origins allowed to use the API from browsers
+	allowedOriginsLock    sync.RWMutex               //
+
+	workAuthToken       string         // Secret auth token for remote proof of work requests
+	workQueue           *list.List     // Queued remote proof of work jobs (*workJob)
+	workQueueLock       sync.Mutex     //
+	workQueueCond       *sync.Cond     // Signaled when jobs are queued or on shutdown
+	workJobsByClient    map[string]int // Queued or running job count by client
+	workCurrentJob      *workJob       // Job currently being computed, if any
+	workServiceFunction *Wharrgarblr   // Work function for remote proof of work jobs (created when first needed)
+	workBenchmarkRate   uint64         // Measured iterations/second for estimates (float64 bits, 0 until measured)
+	workBenchmarkLock   sync.Mutex     // Held while benchmarking so only one benchmark runs
+
 	genesisParameters          GenesisParameters // Genesis configuration for this node's network
 	genesisOwner               OwnerPublic       // Owner of genesis record(s)
 	genesisRecords             []byte            // Genesis records concatenated together
@@ -104,6 +132,17 @@ type Node struct {
 	synchronized       uint32         // set to non-zero when database is synchronized
 	shutdown           uint32         // set to non-zero to cause many routines to exit
 	commentary         uint32         // set to non-zero to add work and render commentary
+
+	logLevels         [logSubsystemCount]int32 // current log level by subsystem (see node-log.go)
+	logFormatJSON     uint32                   // set to non-zero to write logs as JSON lines
+	desiredPeers      int32                    // number of P2P connections to try to maintain
+	maxPeers          int32                    // maximum P2P connections or 0 for no limit
+	apiTrustLoopback  uint32                   // set to non-zero to authorize all API requests from localhost
+	minFreeDiskSpace  uint64                   // free space on data device below which the node stops
+	limboRetention    uint64                   // seconds to keep records in limbo or 0 to keep forever
+	limboMaxOwnerSize int64                    // maximum bytes in limbo per owner or 0 for no limit
+	readyMinPeers     int32                    // connected peers required for readiness (see node-health.go)
+	readyRequireSync  uint32                   // set to non-zero if readiness requires being synchronized
 }
 
 //////////////////////////////////////////////////////////////////////////////
@@ -111,7 +150,13 @@ type Node struct {
 //////////////////////////////////////////////////////////////////////////////
 
 // NewNode creates and starts a node.
-func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, logLevel int, localTest bool) (*Node, error) {
+// P2P and HTTP listen addresses can be host:port, [ipv6%zone]:port, or just a port to listen on all interfaces.
+// HTTP listen addresses can also be Unix domain sockets (unix:/path). The first P2P address's port is the one
+// announced to peers. If tlsConfig is non-nil the HTTP API is served over TLS (HTTPS) instead of plain HTTP.
+// The log level applies to all subsystems and logFormat is LogFormatText or LogFormatJSON until ApplyConfig is called.
+// If config is non-nil its runtime settings are applied with ApplyConfig before the node accepts any connections,
+// so settings like TrustLoopback and AllowedOrigins are in effect from the first request.
+func NewNode(basePath string, p2pListen []string, httpListen []string, tlsConfig *NodeTLSConfig, logger *log.Logger, logLevel int, logFormat string, localTest bool, config *NodeConfig) (*Node, error) {
 	_ = os.MkdirAll(basePath, 0755)
 
 	if localTest {
@@ -130,28 +175,40 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 
 	n.basePath = basePath
 	n.peersFilePath = path.Join(basePath, "peers.json")
-	n.p2pPort = p2pPort
-	n.httpPort = httpPort
 	n.localTest = localTest
 	n.knownPeers = make(map[string]*knownPeer)
 	n.connectionsInStartup = make(map[*net.TCPConn]bool)
 	n.recordsRequested = make(map[[32]byte]uintptr)
 	n.ownerCertificates = make(map[string][2][]*x509.Certificate)
 	n.comments = list.New()
+	n.workQueue = list.New()
+	n.workQueueCond = sync.NewCond(&n.workQueueLock)
+	n.workJobsByClient = make(map[string]int)
+	n.webSocketSessions = make(map[*webSocketSession]bool)
 	n.startTime = time.Now()
+	n.desiredPeers = p2pDesiredConnectionCount
+	n.apiTrustLoopback = 1
+	n.minFreeDiskSpace = MinFreeDiskSpace
+	n.readyMinPeers = 1
+	n.readyRequireSync = 1
 
-	if logger == nil {
-		logger = nullLogger
-	}
 	if logLevel < 0 {
 		logLevel = 0
 	}
-	for i := 0; i <= logLevel && i < logLevelCount; i++ {
-		n.log[i] = logger
+	n.setLogLevel(-1, logLevel)
+	if logFormat == LogFormatJSON {
+		n.logFormatJSON = 1
 	}
-	for i := logLevel + 1; i < logLevelCount; i++ {
-		n.log[i] = nullLogger
+	if logger != nil {
+		n.logger = logger
+		n.logJSON = log.New(logger.Writer(), "", 0)
 	}
+	n.log = n.newLog(LogSubsystemNode, nil)
+	n.p2pLog = n.newLog(LogSubsystemP2P, nil)
+	n.syncLog = n.newLog(LogSubsystemSync, nil)
+	n.dbLog = n.newLog(LogSubsystemDB, nil)
+	n.oracleLog = n.newLog(LogSubsystemOracle, nil)
+	n.apiLog = n.newLog(LogSubsystemAPI, nil)
 
 	initOk := false
 	defer func() {
@@ -162,7 +219,13 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 
 	n.log[LogLevelNormal].Printf("--- node starting up at %s ---", n.startTime.String())
 
-	err := n.db.open(basePath, n.log, n.handleSynchronizedRecord)
+	if config != nil {
+		if err := n.ApplyConfig(config); err != nil {
+			return nil, fmt.Errorf("invalid node configuration: %s", err.Error())
+		}
+	}
+
+	err := n.db.open(basePath, n.dbLog, n.handleSynchronizedRecord)
 	if err != nil {
 		return nil, err
 	}
@@ -215,42 +278,97 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 			return nil, err
 		}
 	}
+	err = n.loadAPITokens()
+	if err != nil {
+		return nil, err
+	}
 
-	if httpPort > 0 {
-		n.httpTCPListener, err = net.ListenTCP("tcp", &net.TCPAddr{Port: httpPort})
+	// Load or generate worktoken.secret for remote proof of work requests.
+	workTokenPath := path.Join(basePath, "worktoken.secret")
+	workTokenBytes, _ := ioutil.ReadFile(workTokenPath)
+	if len(workTokenBytes) > 0 {
+		n.workAuthToken = string(workTokenBytes)
+	} else {
+		var junk [24]byte
+		_, _ = secureRandom.Read(junk[:])
+		n.workAuthToken = Base62Encode(junk[:])
+		err = ioutil.WriteFile(workTokenPath, []byte(n.workAuthToken), 0600)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	n.httpHandler = n.apiCORSHandler(httpCompressionHandler(n.apiTokenHandler(apiVersionedServeMux(n.createHTTPServeMux()))))
+	if tlsConfig != nil {
+		n.httpTLSConfig, err = tlsConfig.serverTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, a := range httpListen {
+		network, address, err := parseListenAddress(a, true)
 		if err != nil {
 			return nil, err
 		}
-		n.httpServer = &http.Server{
+		l, err := listen(network, address)
+		if err != nil {
+			return nil, err
+		}
+		// Each listener gets its own server, which keeps per-server state like HTTP/2 setup separate.
+		hs := &http.Server{
 			MaxHeaderBytes: 4096,
-			ErrorLog:       n.log[LogLevelWarning],
-			Handler:        httpCompressionHandler(n.createHTTPServeMux()),
+			ErrorLog:       n.apiLog[LogLevelWarning],
+			Handler:        n.httpHandler,
+			TLSConfig:      n.httpTLSConfig,
 			IdleTimeout:    10 * time.Second,
 			ReadTimeout:    10 * time.Second,
 			WriteTimeout:   600 * time.Second,
 		}
-		n.httpServer.SetKeepAlivesEnabled(true)
+		hs.SetKeepAlivesEnabled(true)
+		n.httpListeners = append(n.httpListeners, l)
+		n.httpServers = append(n.httpServers, hs)
 	}
 
-	if p2pPort > 0 && !n.localTest {
-		n.p2pTCPListener, err = net.ListenTCP("tcp", &net.TCPAddr{Port: p2pPort})
+	for _, a := range p2pListen {
+		_, address, err := parseListenAddress(a, false)
 		if err != nil {
 			return nil, err
 		}
+		if n.p2pPort == 0 {
+			n.p2pPort = listenAddressPort(address)
+		}
+		if !n.localTest {
+			ta, err := net.ResolveTCPAddr("tcp", address)
+			if err != nil {
+				return nil, err
+			}
+			l, err := net.ListenTCP("tcp", ta)
+			if err != nil {
+				return nil, err
+			}
+			if len(n.p2pListeners) == 0 {
+				n.p2pPort = l.Addr().(*net.TCPAddr).Port // in case port 0 (any port) was specified
+			}
+			n.p2pListeners = append(n.p2pListeners, l)
+		}
 	}
 
 	if n.localTest {
 		n.log[LogLevelNormal].Print("NOTICE: running in local test mode: p2p disabled, proof of work optional")
 	}
 
-	if n.p2pTCPListener != nil {
-		n.log[LogLevelNormal].Printf("P2P port: %d identity: %s", p2pPort, n.identityStr)
+	for _, l := range n.p2pListeners {
+		n.p2pLog[LogLevelNormal].Printf("P2P address: %s identity: %s", l.Addr().String(), n.identityStr)
 	}
-	if n.httpTCPListener != nil {
-		n.log[LogLevelNormal].Printf("HTTP API port: %d", httpPort)
+	for _, l := range n.httpListeners {
+		if n.httpTLSConfig != nil {
+			n.apiLog[LogLevelNormal].Printf("HTTPS API address: %s (client certificate required: %t)", l.Addr().String(), n.httpTLSConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+		} else {
+			n.apiLog[LogLevelNormal].Printf("HTTP API address: %s", l.Addr().String())
+		}
 	}
 
-	n.log[LogLevelNormal].Printf("oracle commentary owner: %s (if generated)", n.owner.String())
+	n.oracleLog[LogLevelNormal].Printf("oracle commentary owner: %s (if generated)", n.owner.String())
 
 	// Load genesis.lf or use compiled-in defaults for global LF network
 	var genesisReader io.Reader
@@ -312,7 +430,7 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 					gotGenesis = true
 				}
 			} else if err != nil {
-				n.log[LogLevelWarning].Print("error unmarshaling genesis record: " + err.Error())
+				n.logEntry(LogSubsystemNode, LogLevelWarning, (*logFields)(nil).withErr(err)).Print("error unmarshaling genesis record: " + err.Error())
 			}
 		}
 		return true
@@ -329,12 +447,13 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 		}
 	}
 
-	if n.p2pTCPListener != nil {
+	for _, l := range n.p2pListeners {
+		l := l
 		n.backgroundThreadWG.Add(1)
 		go func() {
 			defer n.backgroundThreadWG.Done()
-			for atomic.LoadUint32(&n.shutdown) == 0 && n.p2pTCPListener != nil {
-				c, _ := n.p2pTCPListener.AcceptTCP()
+			for atomic.LoadUint32(&n.shutdown) == 0 {
+				c, _ := l.AcceptTCP()
 				if atomic.LoadUint32(&n.shutdown) != 0 {
 					if c != nil {
 						_ = c.Close()
@@ -342,6 +461,10 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 					break
 				}
 				if c != nil {
+					if maxPeers := int(atomic.LoadInt32(&n.maxPeers)); maxPeers > 0 && n.p2pConnectionCount() >= maxPeers {
+						_ = c.Close()
+						continue
+					}
 					n.backgroundThreadWG.Add(1)
 					go n.p2pConnectionHandler(c, nil, true)
 				}
@@ -349,13 +472,15 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 		}()
 	}
 
-	if n.httpTCPListener != nil {
+	for i := range n.httpListeners {
+		l, hs := n.httpListeners[i], n.httpServers[i]
 		n.backgroundThreadWG.Add(1)
 		go func() {
 			defer n.backgroundThreadWG.Done()
-			_ = n.httpServer.Serve(n.httpTCPListener)
-			if n.httpServer != nil {
-				_ = n.httpServer.Close()
+			if hs.TLSConfig != nil {
+				_ = hs.ServeTLS(httpListener{l}, "", "")
+			} else {
+				_ = hs.Serve(httpListener{l})
 			}
 		}()
 	}
@@ -368,16 +493,30 @@ func NewNode(basePath string, p2pPort int, httpPort int, logger *log.Logger, log
 	n.backgroundThreadWG.Add(1)
 	go n.backgroundThreadOracle()
 
+	// Start background thread to compute proof of work for remote clients
+	n.backgroundThreadWG.Add(1)
+	go n.backgroundThreadWorkService()
+
 	// Read and process records in a bootstrap file, if any
 	n.backgroundThreadWG.Add(1)
 	go n.backgroundTaskReadBootstrapFile()
 
-	// Set server's client.json URL list to point to itself
-	if n.httpTCPListener != nil {
+	// Set server's client.json URL list to point to itself so the CLI on this host can reach it
+	localURL, localServerName, localWarning := n.localClientURL()
+	if len(localWarning) > 0 {
+		n.apiLog[LogLevelWarning].Printf("WARNING: %s", localWarning)
+	}
+	if len(localURL) > 0 {
 		clientConfigPath := path.Join(basePath, ClientConfigName)
 		var cc ClientConfig
 		_ = cc.Load(clientConfigPath)
-		cc.URLs = []RemoteNode{RemoteNode(fmt.Sprintf("http://127.0.0.1:%d", httpPort))}
+		cc.URLs = []RemoteNode{localURL}
+		if len(localServerName) > 0 {
+			if cc.TLS == nil {
+				cc.TLS = &RemoteNodeTLSConfig{}
+			}
+			cc.TLS.LocalServerName = localServerName
+		}
 		_ = cc.Save(clientConfigPath)
 	}
 
@@ -411,11 +550,15 @@ func (n *Node) Stop() {
 		}
 		n.peersLock.RUnlock()
 
-		if n.httpServer != nil {
-			_ = n.httpServer.Close()
+		for _, hs := range n.httpServers {
+			_ = hs.Close()
 		}
-		if n.p2pTCPListener != nil {
-			_ = n.p2pTCPListener.Close()
+		for _, l := range n.httpListeners {
+			_ = l.Close()
+		}
+		n.closeWebSockets()
+		for _, l := range n.p2pListeners {
+			_ = l.Close()
 		}
 
 		n.workFunctionLock.Lock()
@@ -428,12 +571,18 @@ func (n *Node) Stop() {
 			n.makeRecordWorkFunction.Abort()
 		}
 		n.makeRecordWorkFunctionLock.Unlock()
+		n.workQueueLock.Lock()
+		if n.workCurrentJob != nil {
+			n.workCurrentJob.cancel()
+		}
+		n.workQueueCond.Broadcast()
+		n.workQueueLock.Unlock()
 
 		n.backgroundThreadWG.Wait()
 
-		n.httpServer = nil
-		n.httpTCPListener = nil
-		n.p2pTCPListener = nil
+		n.httpServers = nil
+		n.httpListeners = nil
+		n.p2pListeners = nil
 
 		n.connectionsInStartupLock.Lock()
 		n.connectionsInStartup = nil
@@ -460,7 +609,7 @@ func (n *Node) WaitForStop() {
 // GetHTTPHandler gets the HTTP handler for this Node.
 // If you want to handle requests via e.g. a Lets Encrypt HTTPS server you can use
 // this to get the handler to pass to your server.
-func (n *Node) GetHTTPHandler() http.Handler { return n.httpServer.Handler }
+func (n *Node) GetHTTPHandler() http.Handler { return n.httpHandler }
 
 // ConnectedPeerCount returns the number of active P2P connections.
 func (n *Node) ConnectedPeerCount() int {
@@ -472,6 +621,16 @@ func (n *Node) ConnectedPeerCount() int {
 
 // Connect attempts to establish a peer-to-peer connection to a remote node.
 func (n *Node) Connect(ip net.IP, port int, identity []byte) error {
+	return n.ConnectPeer(&Peer{IP: ip, Port: port, Identity: identity})
+}
+
+// ConnectPeer attempts to establish a peer-to-peer connection to a remote node, including its zone if it is an IPv6 link-local address.
+func (n *Node) ConnectPeer(peer *Peer) error {
+	ta := net.TCPAddr{IP: peer.IP, Port: peer.Port, Zone: peer.Zone}
+	identity := peer.Identity
+	if len(ta.IP) == 0 || ta.Port <= 0 || ta.Port > 65535 || (ta.IP.To4() == nil && ta.IP.IsLinkLocalUnicast() && len(ta.Zone) == 0) {
+		return ErrInvalidParameter
+	}
 	if n.localTest || bytes.Equal(identity, n.identity) {
 		return nil
 	}
@@ -489,16 +648,19 @@ func (n *Node) Connect(ip net.IP, port int, identity []byte) error {
 	go func() {
 		defer n.backgroundThreadWG.Done()
 
-		n.log[LogLevelVerbose].Printf("P2P attempting to connect to %s %d %s", ip.String(), port, Base62Encode(identity))
+		if n.peerIsBanned(identity) {
+			return
+		}
+
+		n.p2pLog[LogLevelVerbose].Printf("P2P attempting to connect to %s %s", ta.String(), Base62Encode(identity))
 
-		ta := net.TCPAddr{IP: ip, Port: port}
 		conn, err := net.DialTimeout("tcp", ta.String(), time.Second*p2pPeerConnectTimeout)
 		if atomic.LoadUint32(&n.shutdown) == 0 {
 			if err == nil {
 				n.backgroundThreadWG.Add(1)
 				go n.p2pConnectionHandler(conn.(*net.TCPConn), identity, false)
 			} else {
-				n.log[LogLevelVerbose].Printf("P2P connection to %s failed: %s", ta.String(), err.Error())
+				n.logEntry(LogSubsystemP2P, LogLevelVerbose, &logFields{peer: identity, peerAddress: ta.String(), err: err}).Printf("P2P connection to %s failed: %s", ta.String(), err.Error())
 			}
 		} else if conn != nil {
 			_ = conn.Close()
@@ -753,6 +915,7 @@ func (n *Node) NodeStatus() (*NodeStatus, error) {
 		}
 		peers = append(peers, Peer{
 			IP:       p.tcpAddress.IP,
+			Zone:     p.tcpAddress.Zone,
 			Port:     port,
 			Identity: p.identity,
 		})
@@ -853,14 +1016,39 @@ func (n *Node) ExecuteQuery(query *Query) (QueryResults, error) {
 	return query.execute(n)
 }
 
+// ExecuteQueryBatch executes a batch of queries against this local node.
+func (n *Node) ExecuteQueryBatch(queries QueryBatch) ([]QueryBatchResult, error) {
+	return queries.execute(n)
+}
+
 // ExecuteMakeRecord executes a MakeRecord against this local node.
 func (n *Node) ExecuteMakeRecord(mr *MakeRecord) (*Record, Pulse, bool, error) {
-	return mr.execute(n)
+	return mr.execute(context.Background(), n)
 }
 
 // ExecuteMakePulse executes a MakePulse against this local node.
 func (n *Node) ExecuteMakePulse(mr *MakePulse) (Pulse, *Record, bool, error) {
-	return mr.execute(n)
+	return mr.execute(context.Background(), n)
+}
+
+// ExecuteMakeWork executes a MakeWork against this local node.
+func (n *Node) ExecuteMakeWork(mw *MakeWork) (*MakeWorkResult, error) {
+	return mw.execute(context.Background(), n, "local")
+}
+
+// ExecuteEstimate executes an Estimate against this local node.
+func (n *Node) ExecuteEstimate(e *Estimate) (*EstimateResult, error) {
+	return e.execute(n)
+}
+
+// AddComment queues a comment for publication if commentary is enabled.
+func (n *Node) AddComment(c *Comment) error {
+	return c.execute(n)
+}
+
+// GetComments gets comments about a record or owner by this node and other oracles.
+func (n *Node) GetComments(q *CommentQuery) ([]Comment, error) {
+	return q.execute(n)
 }
 
 // IsLocal implements IsLocal in the LF interface, always returns true for Node.
@@ -910,7 +1098,7 @@ func (n *Node) handleSynchronizedRecord(doff uint64, dlen uint, reputation int,
 		defer func() {
 			e := recover()
 			if e != nil && atomic.LoadUint32(&n.shutdown) != 0 {
-				n.log[LogLevelWarning].Printf("WARNING: BUG: unexpected panic handling synchronized record: %s", e)
+				n.syncLog[LogLevelWarning].Printf("WARNING: BUG: unexpected panic handling synchronized record: %s", e)
 			}
 			n.backgroundThreadWG.Done()
 		}()
@@ -930,13 +1118,13 @@ func (n *Node) handleSynchronizedRecord(doff uint64, dlen uint, reputation int,
 						ok, linkTS := n.db.getRecordTimestampByHash(r.Links[li][:])
 						if ok {
 							if linkTS > r.Timestamp && (linkTS-r.Timestamp) > uint64(n.genesisParameters.RecordMaxTimeDrift) {
-								n.log[LogLevelVerbose].Printf("record %s reputation adjusted from %d to %d since it links to records newer than itself (different is beyond max time drift of %d seconds)", r.HashString(), reputation, dbReputationTemporalViolation, n.genesisParameters.RecordMaxTimeDrift)
+								n.syncLog[LogLevelVerbose].Printf("record %s reputation adjusted from %d to %d since it links to records newer than itself (different is beyond max time drift of %d seconds)", r.HashString(), reputation, dbReputationTemporalViolation, n.genesisParameters.RecordMaxTimeDrift)
 								reputation = dbReputationTemporalViolation
 								n.db.updateRecordReputationByHash(hash[:], reputation)
 								break
 							}
 						} else {
-							n.log[LogLevelFatal].Printf("FATAL: I/O error or database corruption: record %s was reported by database as synchronized, but is not since link =%s is missing!", r.HashString(), Base62Encode(r.Links[li][:]))
+							n.syncLog[LogLevelFatal].Printf("FATAL: I/O error or database corruption: record %s was reported by database as synchronized, but is not since link =%s is missing!", r.HashString(), Base62Encode(r.Links[li][:]))
 							go n.Stop()
 							return
 						}
@@ -974,11 +1162,14 @@ func (n *Node) handleSynchronizedRecord(doff uint64, dlen uint, reputation int,
 						var c comment
 						for len(cdata) > 0 {
 							cdata, err = c.readFrom(cdata)
-							if err == nil {
-								n.log[LogLevelVerbose].Printf("comment: @%s: %s", Base62Encode(r.Owner), c.string())
+							if err != nil {
+								break
+							}
+							if c.valid() {
+								n.oracleLog[LogLevelVerbose].Printf("comment: @%s: %s", Base62Encode(r.Owner), c.string())
 								_ = n.db.logComment(doff, int(c.assertion), int(c.reason), c.subject)
 							} else {
-								break
+								n.oracleLog[LogLevelVerbose].Printf("comment: @%s: ignored invalid or unsupported comment: %s", Base62Encode(r.Owner), c.string())
 							}
 						}
 					}
@@ -991,7 +1182,7 @@ func (n *Node) handleSynchronizedRecord(doff uint64, dlen uint, reputation int,
 							for _, cert := range certs {
 								err := n.db.putCert(cert, doff)
 								if err != nil {
-									n.log[LogLevelWarning].Printf("WARNING: error adding certificate to database: %s", err.Error())
+									n.logEntry(LogSubsystemNode, LogLevelWarning, &logFields{record: hash[:], err: err}).Printf("WARNING: error adding certificate to database: %s", err.Error())
 								}
 
 								n.ownerCertificatesLock.Lock()
@@ -1033,6 +1224,8 @@ func (n *Node) handleSynchronizedRecord(doff uint64, dlen uint, reputation int,
 				// slowly, increasing the odds of other less synchronized nodes also flagging them as
 				// suspect for temporal heuristic reasons.
 				if reputation >= dbReputationDefault {
+					n.webSocketNotify(r)
+
 					var msg [33]byte
 					msg[0] = p2pProtoMessageTypeHaveRecords
 					copy(msg[1:], hash[:])
@@ -1056,17 +1249,17 @@ func (n *Node) handleSynchronizedRecord(doff uint64, dlen uint, reputation int,
 					}
 					n.peersLock.RUnlock()
 
-					n.log[LogLevelVerbose].Printf("sync: %s with local reputation %d (announced to %d peers)", r.HashString(), reputation, announcementCount)
+					n.syncLog[LogLevelVerbose].Printf("sync: %s with local reputation %d (announced to %d peers)", r.HashString(), reputation, announcementCount)
 				} else {
-					n.log[LogLevelVerbose].Printf("sync: %s with local reputation %d (not announced due to below normal reputation)", r.HashString(), reputation)
+					n.syncLog[LogLevelVerbose].Printf("sync: %s with local reputation %d (not announced due to below normal reputation)", r.HashString(), reputation)
 				}
 			} else {
-				n.log[LogLevelWarning].Printf("WARNING: could your node be really old? record =%s reputation adjusted from %d to %d since an error occured deserializing it (%s)", Base62Encode(hash[:]), reputation, dbReputationRecordDeserializationFailed, err.Error())
+				n.syncLog[LogLevelWarning].Printf("WARNING: could your node be really old? record =%s reputation adjusted from %d to %d since an error occured deserializing it (%s)", Base62Encode(hash[:]), reputation, dbReputationRecordDeserializationFailed, err.Error())
 				n.db.updateRecordReputationByHash(hash[:], dbReputationRecordDeserializationFailed)
 			}
 		} else {
 			if err != nil {
-				n.log[LogLevelFatal].Printf("FATAL: I/O error or database corruption: unable to read record at byte index %d with size %d in data file (%s)", doff, dlen, err.Error())
+				n.logEntry(LogSubsystemSync, LogLevelFatal, &logFields{record: hash[:], err: err}).Printf("FATAL: I/O error or database corruption: unable to read record at byte index %d with size %d in data file (%s)", doff, dlen, err.Error())
 			}
 			go n.Stop()
 		}
@@ -1089,13 +1282,18 @@ func (n *Node) backgroundThreadMaintenance() {
 		// Check free disk space to avoid corruption if the target device fills up
 		if (ticker % 30) == 3 {
 			freeDiskSpace, _ := getFreeSpaceOnDevice(n.basePath)
-			if freeDiskSpace < MinFreeDiskSpace {
-				n.log[LogLevelFatal].Printf("FATAL: insufficient free space detected on device containing '%s' (%d < %d)", n.basePath, freeDiskSpace, MinFreeDiskSpace)
+			if minFreeDiskSpace := atomic.LoadUint64(&n.minFreeDiskSpace); freeDiskSpace < minFreeDiskSpace {
+				n.log[LogLevelFatal].Printf("FATAL: insufficient free space detected on device containing '%s' (%d < %d)", n.basePath, freeDiskSpace, minFreeDiskSpace)
 				go n.Stop()
 				break
 			}
 		}
 
+		// Forget records that have been in limbo longer than the configured retention period.
+		if (ticker % 300) == 13 {
+			n.forgetExpiredRecordsInLimbo()
+		}
+
 		if !n.localTest {
 			// Clean record tracking entries of items older than 5 minutes.
 			if (ticker % 120) == 5 {
@@ -1149,24 +1347,39 @@ func (n *Node) backgroundThreadMaintenance() {
 				n.requestWantedRecords(0, 0)
 			}
 
-			// If we don't have enough connections, try to make more to peers we've learned about.
+			// Reconnect pinned peers, and if we don't have enough connections try to make more to peers we've learned about.
 			if (ticker % 10) == 1 {
 				n.peersLock.RLock()
-				if len(n.peers) < p2pDesiredConnectionCount {
-					n.knownPeersLock.Lock()
+				n.knownPeersLock.Lock()
+				now := TimeSec()
+				isConnected := func(kp *knownPeer) bool {
+					for _, cp := range n.peers {
+						if bytes.Equal(cp.identity, kp.Identity) {
+							return true
+						}
+					}
+					return false
+				}
+				for _, kp := range n.knownPeers {
+					if kp.Pinned && !kp.Banned && len(kp.IP) > 0 && (now-kp.LastReconnectionAttempt) >= p2pPeerAttemptInterval && !isConnected(kp) {
+						kp.LastReconnectionAttempt = now
+						kp.TotalReconnectionAttempts++
+						_ = n.ConnectPeer(&kp.Peer)
+					}
+				}
+				if len(n.peers) < int(atomic.LoadInt32(&n.desiredPeers)) {
 					if len(n.knownPeers) > 0 {
-						now := TimeSec()
-					tryKnownPeers:
 						for _, kp := range n.knownPeers { // exploits Go's random map iteration order
+							if kp.Pinned || kp.Banned || len(kp.IP) == 0 {
+								continue
+							}
 							if (now-kp.LastReconnectionAttempt) < p2pPeerAttemptInterval || (now-kp.LastReconnectionAttempt) > (p2pPeerAttemptInterval*uint64(len(n.knownPeers))) {
-								for _, cp := range n.peers {
-									if bytes.Equal(cp.identity, kp.Identity) {
-										continue tryKnownPeers
-									}
+								if isConnected(kp) {
+									continue
 								}
 								kp.LastReconnectionAttempt = now
 								kp.TotalReconnectionAttempts++
-								_ = n.Connect(kp.IP, kp.Port, kp.Identity)
+								_ = n.ConnectPeer(&kp.Peer)
 								break
 							}
 						}
@@ -1180,8 +1393,8 @@ func (n *Node) backgroundThreadMaintenance() {
 							_ = n.Connect(spp.IP, spp.Port, spp.Identity)
 						}
 					}
-					n.knownPeersLock.Unlock()
 				}
+				n.knownPeersLock.Unlock()
 				n.peersLock.RUnlock()
 			}
 		}
@@ -1190,11 +1403,11 @@ func (n *Node) backgroundThreadMaintenance() {
 		if (ticker % 5) == 0 {
 			if n.db.haveDanglingLinks(p2pProtoMaxRetries) {
 				if atomic.SwapUint32(&n.synchronized, 0) == 1 {
-					n.log[LogLevelVerbose].Println("sync: database no longer fully synchronized (as of now)")
+					n.syncLog[LogLevelVerbose].Println("sync: database no longer fully synchronized (as of now)")
 				}
 			} else {
 				if atomic.SwapUint32(&n.synchronized, 1) == 0 {
-					n.log[LogLevelVerbose].Println("sync: database fully synchronized! (as of now)")
+					n.syncLog[LogLevelVerbose].Println("sync: database fully synchronized! (as of now)")
 				}
 			}
 		}
@@ -1281,12 +1494,12 @@ func (n *Node) backgroundThreadOracle() {
 						if commentCount > 0 {
 							ll = LogLevelNormal
 						}
-						n.log[ll].Printf("oracle: %s submitted with %d comments (minimum difficulty %.8x, created in %f seconds)", rec.HashString(), commentCount, minWorkDifficulty, duration)
+						n.oracleLog[ll].Printf("oracle: %s submitted with %d comments (minimum difficulty %.8x, created in %f seconds)", rec.HashString(), commentCount, minWorkDifficulty, duration)
 					} else {
-						n.log[LogLevelWarning].Printf("WARNING: error adding commentary record: %s", err.Error())
+						n.logEntry(LogSubsystemOracle, LogLevelWarning, (*logFields)(nil).withErr(err)).Printf("WARNING: error adding commentary record: %s", err.Error())
 					}
 				} else {
-					n.log[LogLevelWarning].Printf("WARNING: error creating commentary record: %s", err.Error())
+					n.logEntry(LogSubsystemOracle, LogLevelWarning, (*logFields)(nil).withErr(err)).Printf("WARNING: error creating commentary record: %s", err.Error())
 				}
 			}
 		} else {
@@ -1307,7 +1520,7 @@ func (n *Node) backgroundTaskProcessRecordsInLimbo(ownerPublic OwnerPublic) {
 	defer func() {
 		e := recover()
 		if e != nil {
-			n.log[LogLevelWarning].Printf("WARNING: BUG: caught panic in background records in limbo processing task for owner %s: %v", ownerStr, e)
+			n.syncLog[LogLevelWarning].Printf("WARNING: BUG: caught panic in background records in limbo processing task for owner %s: %v", ownerStr, e)
 		}
 		n.limboLock.Unlock()
 		n.backgroundThreadWG.Done()
@@ -1319,14 +1532,14 @@ func (n *Node) backgroundTaskProcessRecordsInLimbo(ownerPublic OwnerPublic) {
 	}
 	f, err := os.Open(fp)
 	if err != nil {
-		n.log[LogLevelWarning].Printf("WARNING: sync: records in limbo in %s cannot be read for processing: %s", fp, err.Error())
+		n.logEntry(LogSubsystemSync, LogLevelWarning, (*logFields)(nil).withErr(err)).Printf("WARNING: sync: records in limbo in %s cannot be read for processing: %s", fp, err.Error())
 		return
 	}
 	if f == nil {
 		return
 	}
 
-	n.log[LogLevelNormal].Printf("sync: processing records in limbo for owner %s", ownerStr)
+	n.syncLog[LogLevelNormal].Printf("sync: processing records in limbo for owner %s", ownerStr)
 
 	bf := bufio.NewReader(f)
 	var rec Record
@@ -1341,11 +1554,39 @@ func (n *Node) backgroundTaskProcessRecordsInLimbo(ownerPublic OwnerPublic) {
 	_ = f.Close()
 
 	if numNotYetApproved == 0 {
-		n.log[LogLevelNormal].Printf("sync: all %d records in limbo for owner %s added", numFound, ownerStr)
+		n.syncLog[LogLevelNormal].Printf("sync: all %d records in limbo for owner %s added", numFound, ownerStr)
 		_ = os.Remove(fp)
 	} else {
-		n.log[LogLevelNormal].Printf("sync: %d records remain in limbo for owner %s", numNotYetApproved, ownerStr)
-		// TODO: eventually forget records in limbo?
+		n.syncLog[LogLevelNormal].Printf("sync: %d records remain in limbo for owner %s", numNotYetApproved, ownerStr)
+	}
+}
+
+// forgetExpiredRecordsInLimbo forgets records in limbo for owners that have not had a record
+// placed in limbo within the limbo retention period, if one is configured.
+func (n *Node) forgetExpiredRecordsInLimbo() {
+	retention := atomic.LoadUint64(&n.limboRetention)
+	if retention == 0 {
+		return
+	}
+	limboBasePath := path.Join(n.basePath, "limbo")
+	now := time.Now()
+	n.limboLock.Lock()
+	defer n.limboLock.Unlock()
+	files, _ := ioutil.ReadDir(limboBasePath)
+	for _, fi := range files {
+		age := now.Sub(fi.ModTime())
+		if !fi.Mode().IsRegular() || age <= 0 || uint64(age/time.Second) <= retention {
+			continue
+		}
+		owner, _ := NewOwnerPublicFromString(fi.Name())
+		if len(owner) > 0 {
+			if err := n.db.forgetLimboOwner(owner); err != nil {
+				n.logEntry(LogSubsystemSync, LogLevelWarning, (*logFields)(nil).withErr(err)).Printf("WARNING: sync: unable to forget records in limbo for owner %s: %s", fi.Name(), err.Error())
+				continue
+			}
+		}
+		_ = os.Remove(path.Join(limboBasePath, fi.Name()))
+		n.syncLog[LogLevelNormal].Printf("sync: forgot records in limbo for owner %s (retention period of %d seconds expired)", fi.Name(), retention)
 	}
 }
 
@@ -1361,19 +1602,19 @@ func (n *Node) backgroundTaskReadBootstrapFile() {
 				_ = os.Remove(bootstrapFilePath)
 			}
 		}()
-		n.log[LogLevelNormal].Printf("sync: found bootstrap.lf, importing records...")
+		n.syncLog[LogLevelNormal].Printf("sync: found bootstrap.lf, importing records...")
 		var count uint64
 		for atomic.LoadUint32(&n.shutdown) == 0 {
 			var rec Record
 			if rec.UnmarshalFrom(bootstrapFile) == nil {
 				rh := rec.Hash()
-				_ = n.addRemoteRecord(rec.Bytes(), rh[:], &rec, bootstrapFilePath)
+				_ = n.addRemoteRecord(rec.Bytes(), rh[:], &rec, bootstrapFilePath, nil)
 				count++
 				if (count % 1024) == 0 {
-					n.log[LogLevelNormal].Printf("sync: imported %d records from bootstrap file", count)
+					n.syncLog[LogLevelNormal].Printf("sync: imported %d records from bootstrap file", count)
 				}
 			} else {
-				n.log[LogLevelNormal].Printf("sync: imported %d records from bootstrap file, import complete, deleting bootstrap file", count)
+				n.syncLog[LogLevelNormal].Printf("sync: imported %d records from bootstrap file, import complete, deleting bootstrap file", count)
 				break
 			}
 		}
@@ -1384,19 +1625,29 @@ func (n *Node) backgroundTaskReadBootstrapFile() {
 // Miscellaneous internal methods
 //////////////////////////////////////////////////////////////////////////////
 
-// addRemoteRecord adds records received via P2P or bootstrap files.
-func (n *Node) addRemoteRecord(recordBytes, recordHash []byte, rec *Record, src string) error {
+// addRemoteRecord adds records received via P2P or bootstrap files (srcIdentity is the sending peer or nil).
+func (n *Node) addRemoteRecord(recordBytes, recordHash []byte, rec *Record, src string, srcIdentity []byte) error {
 	err := n.AddRecord(rec)
 	if err == ErrRecordNotApproved && !n.db.haveRecordIncludeLimbo(recordHash) {
 		// If a record is not approved we save it temporarily and mark it "in limbo" in
 		// the database. Records marked in limbo might get added later if certificates
 		// authorizing them arrive or there is a network config change.
-		_ = n.db.markInLimbo(recordHash, rec.Owner, TimeSec(), rec.Timestamp)
-
 		limboBasePath := path.Join(n.basePath, "limbo")
 		limboPath := path.Join(limboBasePath, rec.Owner.String())
-		n.log[LogLevelTrace].Printf("marking record =%s from %s as in limbo, adding to %s", Base62Encode(recordHash), src, limboPath)
 		n.limboLock.Lock()
+		if maxOwnerSize := atomic.LoadInt64(&n.limboMaxOwnerSize); maxOwnerSize > 0 {
+			var size int64
+			if fi, _ := os.Stat(limboPath); fi != nil {
+				size = fi.Size()
+			}
+			if (size + int64(len(recordBytes))) > maxOwnerSize {
+				n.limboLock.Unlock()
+				n.logEntry(LogSubsystemSync, LogLevelTrace, &logFields{record: recordHash, peer: srcIdentity, err: err}).Printf("rejected record =%s from %s: %s (limbo full for owner)", Base62Encode(recordHash), src, err.Error())
+				return err
+			}
+		}
+		n.logEntry(LogSubsystemSync, LogLevelTrace, &logFields{record: recordHash, peer: srcIdentity}).Printf("marking record =%s from %s as in limbo, adding to %s", Base62Encode(recordHash), src, limboPath)
+		_ = n.db.markInLimbo(recordHash, rec.Owner, TimeSec(), rec.Timestamp)
 		limboFile, _ := os.OpenFile(limboPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		if limboFile == nil {
 			_ = os.MkdirAll(limboBasePath, 0755)
@@ -1410,7 +1661,7 @@ func (n *Node) addRemoteRecord(recordBytes, recordHash []byte, rec *Record, src
 
 		return nil
 	} else if err != nil {
-		n.log[LogLevelTrace].Printf("rejected record =%s from %s: %s", Base62Encode(recordHash), src, err.Error())
+		n.logEntry(LogSubsystemSync, LogLevelTrace, &logFields{record: recordHash, peer: srcIdentity, err: err}).Printf("rejected record =%s from %s: %s", Base62Encode(recordHash), src, err.Error())
 		return err
 	}
 	return nil
@@ -1503,7 +1754,7 @@ func (n *Node) requestWantedRecords(minRetries, maxRetries int) {
 			p = n.peers[rand.Int()%len(n.peers)]
 		}
 		if p != nil {
-			n.log[LogLevelNormal].Printf("sync: requesting %d wanted records from %s (retry count range %d-%d)", count, p.address, minRetries, maxRetries)
+			n.syncLog[LogLevelNormal].Printf("sync: requesting %d wanted records from %s (retry count range %d-%d)", count, p.address, minRetries, maxRetries)
 			n.backgroundThreadWG.Add(1)
 			go func() {
 				defer func() {
@@ -0,0 +1,297 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+// This generates an OpenAPI 3 description of the HTTP API from the API's Go types.
+
+import (
+	"encoding"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// apiVersionPrefix is the path prefix for the current API version, e.g. /v1.
+var apiVersionPrefix = "/v" + APIVersionStr
+
+// apiEndpoint describes an HTTP API endpoint for the OpenAPI document.
+// A nil request or response type with a binary flag set indicates a raw binary (application/octet-stream) body.
+type apiEndpoint struct {
+	path           string
+	method         string
+	summary        string
+	scope          string       // API token scope required unless from localhost (empty if public)
+	parameters     []apiParam   // path and query parameters
+	request        reflect.Type // JSON request body type (nil for none)
+	requestBinary  bool         // request body is raw binary
+	response       reflect.Type // JSON response body type (nil for none)
+	responseBinary bool         // response body is raw binary
+}
+
+type apiParam struct {
+	name        string
+	in          string // "path" or "query"
+	description string
+	schema      map[string]interface{}
+}
+
+var apiEndpoints = []apiEndpoint{
+	{path: "/query", method: http.MethodPost, summary: "Query records by selector", request: reflect.TypeOf(Query{}), response: reflect.TypeOf(QueryResults{})},
+	{path: "/query/batch", method: http.MethodPost, summary: "Run several queries against one consistent view of the DAG", request: reflect.TypeOf(QueryBatch{}), response: reflect.TypeOf([]QueryBatchResult{})},
+	{path: "/post", method: http.MethodPost, summary: "Submit a record in binary form", requestBinary: true, response: reflect.TypeOf(Record{})},
+	{path: "/pulse", method: http.MethodPost, summary: "Submit a pulse in binary form", requestBinary: true, response: reflect.TypeOf(pulsePostResult{})},
+	{path: "/makerecord", method: http.MethodPost, summary: "Have the node create and submit a record", scope: APITokenScopeMakeRecord, request: reflect.TypeOf(MakeRecord{}), response: reflect.TypeOf(remoteMakeResult{})},
+	{path: "/work", method: http.MethodPost, summary: "Have the node compute proof of work for a record built by the client", scope: APITokenScopeMakeRecord, request: reflect.TypeOf(MakeWork{}), response: reflect.TypeOf(MakeWorkResult{})},
+	{path: "/estimate", method: http.MethodPost, summary: "Estimate proof of work for a record", request: reflect.TypeOf(Estimate{}), response: reflect.TypeOf(EstimateResult{})},
+	{path: "/makepulse", method: http.MethodPost, summary: "Have the node create a pulse (or record if needed) to extend a record's life", request: reflect.TypeOf(MakePulse{}), response: reflect.TypeOf(remoteMakeResult{})},
+	{path: "/connect", method: http.MethodPost, summary: "Suggest a P2P endpoint for the node to try", scope: APITokenScopeConnect, request: reflect.TypeOf(Peer{})},
+	{path: "/comment", method: http.MethodPost, summary: "Queue a comment for publication by the node's oracle", scope: APITokenScopeOracleFlag, request: reflect.TypeOf(Comment{})},
+	{path: "/comments", method: http.MethodPost, summary: "Get comments about a record or owner", request: reflect.TypeOf(CommentQuery{}), response: reflect.TypeOf([]Comment{})},
+	{path: "/token/create", method: http.MethodPost, summary: "Create a named API token (secret is only returned once)", scope: APITokenScopeAdmin, request: reflect.TypeOf(APITokenCreate{}), response: reflect.TypeOf(APIToken{})},
+	{path: "/token/revoke", method: http.MethodPost, summary: "Revoke a named API token", scope: APITokenScopeAdmin, request: reflect.TypeOf(APITokenRevoke{})},
+	{path: "/token/list", method: http.MethodGet, summary: "List named API tokens", scope: APITokenScopeAdmin, response: reflect.TypeOf([]APIToken{})},
+	{path: "/peer/list", method: http.MethodGet, summary: "List known and connected peers with connection stats", scope: APITokenScopeAdmin, response: reflect.TypeOf([]PeerInfo{})},
+	{path: "/peer/pin", method: http.MethodPost, summary: "Pin a peer so it is always reconnected (IP and port are needed if the peer is not known)", scope: APITokenScopeAdmin, request: reflect.TypeOf(Peer{})},
+	{path: "/peer/unpin", method: http.MethodPost, summary: "Unpin a peer", scope: APITokenScopeAdmin, request: reflect.TypeOf(Peer{})},
+	{path: "/peer/drop", method: http.MethodPost, summary: "Close connections to a peer (it may reconnect later)", scope: APITokenScopeAdmin, request: reflect.TypeOf(Peer{})},
+	{path: "/peer/forget", method: http.MethodPost, summary: "Remove a peer from the node's known peers", scope: APITokenScopeAdmin, request: reflect.TypeOf(Peer{})},
+	{path: "/peer/ban", method: http.MethodPost, summary: "Disconnect a peer and refuse connections to or from it", scope: APITokenScopeAdmin, request: reflect.TypeOf(Peer{})},
+	{path: "/peer/unban", method: http.MethodPost, summary: "Lift a peer ban", scope: APITokenScopeAdmin, request: reflect.TypeOf(Peer{})},
+	{path: "/record/={hash}", method: http.MethodGet, summary: "Get a record by hash", parameters: []apiParam{{name: "hash", in: "path", description: "Base62-encoded record hash", schema: map[string]interface{}{"type": "string"}}}, response: reflect.TypeOf(Record{})},
+	{path: "/record/raw/={hash}", method: http.MethodGet, summary: "Get a record in binary form by hash", parameters: []apiParam{{name: "hash", in: "path", description: "Base62-encoded record hash", schema: map[string]interface{}{"type": "string"}}}, responseBinary: true},
+	{path: "/owner/@{owner}", method: http.MethodGet, summary: "Get an owner's status", parameters: []apiParam{{name: "owner", in: "path", description: "Base62-encoded owner public key", schema: map[string]interface{}{"type": "string"}}}, response: reflect.TypeOf(OwnerStatus{})},
+	{path: "/links", method: http.MethodGet, summary: "Get suggested links for a new record as concatenated 32-byte hashes", parameters: []apiParam{{name: "count", in: "query", description: "Number of links (default: network minimum)", schema: map[string]interface{}{"type": "integer"}}}, responseBinary: true},
+	{path: "/status", method: http.MethodGet, summary: "Get node status", response: reflect.TypeOf(NodeStatus{})},
+	{path: "/healthz", method: http.MethodGet, summary: "Check that the node is running, its database is open, and disk space is sufficient (503 if not)", response: reflect.TypeOf(NodeHealth{})},
+	{path: "/readyz", method: http.MethodGet, summary: "Check that the node is healthy, synchronized, and connected to enough peers to serve consistent reads (503 if not)", response: reflect.TypeOf(NodeReadiness{})},
+	{path: "/dumprecords", method: http.MethodGet, summary: "Download all records in the node's records.lf format, including any that are not synchronized (see /export)", responseBinary: true},
+	{path: "/export", method: http.MethodGet, summary: "Stream synchronized records in link order with checksummed resume checkpoints (see ExportRecords)", parameters: []apiParam{{name: "offset", in: "query", description: "Resume offset from a checkpoint (default: 0)", schema: map[string]interface{}{"type": "integer"}}, {name: "hash", in: "query", description: "Base62-encoded hash of the record to start from (if offset is not given)", schema: map[string]interface{}{"type": "string"}}}, responseBinary: true},
+	{path: "/ws", method: http.MethodGet, summary: "Upgrade to a WebSocket session exchanging WebSocketRequest and WebSocketResponse JSON frames"},
+	{path: "/openapi.json", method: http.MethodGet, summary: "Get this OpenAPI document"},
+}
+
+// apiExtraSchemaTypes are types described in the OpenAPI document that don't appear in HTTP request or response bodies.
+var apiExtraSchemaTypes = []reflect.Type{
+	reflect.TypeOf(WebSocketRequest{}),
+	reflect.TypeOf(WebSocketResponse{}),
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// openAPISchemas generates JSON schemas for Go types, collecting named struct types as reusable components.
+type openAPISchemas map[string]interface{}
+
+// schemaFor returns a JSON schema for a type as encoding/json would serialize it.
+func (s openAPISchemas) schemaFor(t reflect.Type) map[string]interface{} {
+	// Types with custom JSON encodings in this package are all strings.
+	switch t {
+	case reflect.TypeOf(Blob{}):
+		return map[string]interface{}{"type": "string", "description": "UTF-8 string, or \\b followed by base62 for binary data"}
+	case reflect.TypeOf(HashBlob{}):
+		return map[string]interface{}{"type": "string", "description": "= followed by base62-encoded 32-byte hash"}
+	case reflect.TypeOf(OwnerPublic{}):
+		return map[string]interface{}{"type": "string", "description": "@ followed by base62-encoded owner public key"}
+	case reflect.TypeOf(Ordinal{}):
+		return map[string]interface{}{"type": "string", "description": "\\b followed by base62-encoded 16-byte masked ordinal"}
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) || t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return s.schemaFor(t.Elem())
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32", "minimum": 0}
+	case reflect.Uint, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32:
+		return map[string]interface{}{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.schemaFor(t.Elem())}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": s.schemaFor(t.Elem()), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.schemaFor(t.Elem())}
+	case reflect.Struct:
+		// Exported named structs become components, others are described inline.
+		name := t.Name()
+		if len(name) == 0 || name[0] < 'A' || name[0] > 'Z' {
+			return s.structSchema(t)
+		}
+		if _, have := s[name]; !have {
+			s[name] = nil // placeholder in case of recursive types
+			s[name] = s.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	return map[string]interface{}{} // interface{} and anything else can be any JSON value
+}
+
+// structSchema returns an object schema for a struct's JSON fields, flattening embedded structs.
+func (s openAPISchemas) structSchema(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	s.addStructFields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+func (s openAPISchemas) addStructFields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				s.addStructFields(ft, props)
+				continue
+			}
+		}
+		if len(f.PkgPath) > 0 { // unexported
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		props[name] = s.schemaFor(f.Type)
+	}
+}
+
+func openAPIJSONBody(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"content": map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}}
+}
+
+func openAPIBinaryBody() map[string]interface{} {
+	return map[string]interface{}{"content": map[string]interface{}{"application/octet-stream": map[string]interface{}{"schema": map[string]interface{}{"type": "string", "format": "binary"}}}}
+}
+
+// openAPIDocument generates the OpenAPI 3 document describing this version of the HTTP API.
+func openAPIDocument() map[string]interface{} {
+	schemas := make(openAPISchemas)
+	errorResponse := openAPIJSONBody(schemas.schemaFor(reflect.TypeOf(ErrAPI{})))
+	errorResponse["description"] = "Error"
+	for _, t := range apiExtraSchemaTypes {
+		schemas.schemaFor(t)
+	}
+
+	paths := make(map[string]interface{})
+	for _, ep := range apiEndpoints {
+		op := map[string]interface{}{
+			"summary":     ep.summary,
+			"operationId": strings.Trim(strings.NewReplacer("/", "_", "=", "", "@", "", "{", "", "}", "", ".", "_").Replace(ep.path), "_"),
+		}
+		if len(ep.scope) > 0 {
+			op["description"] = "Requires an API token with " + ep.scope + " scope (or admin scope) unless the request is from localhost."
+			op["security"] = []interface{}{map[string]interface{}{"bearerAuth": []string{}}}
+		}
+		if len(ep.parameters) > 0 {
+			var params []interface{}
+			for _, p := range ep.parameters {
+				params = append(params, map[string]interface{}{
+					"name":        p.name,
+					"in":          p.in,
+					"description": p.description,
+					"required":    p.in == "path",
+					"schema":      p.schema,
+				})
+			}
+			op["parameters"] = params
+		}
+		if ep.request != nil {
+			body := openAPIJSONBody(schemas.schemaFor(ep.request))
+			body["required"] = true
+			op["requestBody"] = body
+		} else if ep.requestBinary {
+			body := openAPIBinaryBody()
+			body["required"] = true
+			op["requestBody"] = body
+		}
+		var ok map[string]interface{}
+		if ep.response != nil {
+			ok = openAPIJSONBody(schemas.schemaFor(ep.response))
+		} else if ep.responseBinary {
+			ok = openAPIBinaryBody()
+		} else if ep.path == "/openapi.json" {
+			ok = openAPIJSONBody(map[string]interface{}{"type": "object"})
+		} else {
+			ok = make(map[string]interface{})
+		}
+		ok["description"] = "Success"
+		op["responses"] = map[string]interface{}{"200": ok, "default": errorResponse}
+		paths[ep.path] = map[string]interface{}{strings.ToLower(ep.method): op}
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   SoftwareName + " HTTP API",
+			"version": APIVersionStr,
+			"description": "Paths are relative to " + apiVersionPrefix + ". Unversioned paths are aliases for the current API version. " +
+				"POST endpoints also accept PUT and GET endpoints also accept HEAD.",
+		},
+		"servers": []interface{}{map[string]interface{}{"url": apiVersionPrefix}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+	}
+}
+
+var (
+	openAPIDocumentJSON     []byte
+	openAPIDocumentJSONOnce sync.Once
+)
+
+// openAPIJSON returns the OpenAPI document as JSON, generating it the first time it's requested.
+func openAPIJSON() []byte {
+	openAPIDocumentJSONOnce.Do(func() {
+		openAPIDocumentJSON, _ = json.MarshalIndent(openAPIDocument(), "", "\t")
+	})
+	return openAPIDocumentJSON
+}
+
+// apiVersionedServeMux serves an API handler under the current version prefix and also without a prefix.
+func apiVersionedServeMux(api http.Handler) *http.ServeMux {
+	vmux := http.NewServeMux()
+	vmux.Handle(apiVersionPrefix+"/", http.StripPrefix(apiVersionPrefix, api))
+	vmux.Handle("/", api)
+	return vmux
+}
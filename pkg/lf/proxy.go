@@ -0,0 +1,514 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ProxyDefaultCacheTTL is the default time to cache query and owner responses.
+	ProxyDefaultCacheTTL = time.Second * 10
+
+	// proxyRecordCacheTTL is how long records are cached, which can be long since records are immutable.
+	proxyRecordCacheTTL = time.Hour
+
+	// proxyMaxCacheEntries limits the size of the response cache.
+	proxyMaxCacheEntries = 65536
+
+	// proxyMaxRequestSize limits the size of request bodies that are cached by content.
+	proxyMaxRequestSize = 1048576
+)
+
+type proxyCacheEntry struct {
+	body    []byte
+	expires time.Time
+}
+
+// Proxy serves the LF HTTP API by forwarding requests to upstream nodes and caching responses.
+// Records are checked before being cached and served, and queries can be checked too by using
+// a VerifyingNode as the upstream. Requests that nodes only accept from trusted (local) clients
+// are only forwarded for clients that are local to the proxy, since upstream nodes would otherwise
+// see them as coming from the proxy.
+type Proxy struct {
+	upstream LF
+	ttl      time.Duration
+	log      *log.Logger
+
+	cache     map[string]*proxyCacheEntry
+	cacheLock sync.Mutex
+}
+
+// NewProxy creates a new proxy for an upstream node (often a MultiNode) that caches responses for ttl.
+func NewProxy(upstream LF, ttl time.Duration, logger *log.Logger) *Proxy {
+	if ttl <= 0 {
+		ttl = ProxyDefaultCacheTTL
+	}
+	if logger == nil {
+		logger = log.New(ioutil.Discard, "", 0)
+	}
+	return &Proxy{
+		upstream: upstream,
+		ttl:      ttl,
+		log:      logger,
+		cache:    make(map[string]*proxyCacheEntry),
+	}
+}
+
+// GetHTTPHandler returns an HTTP handler serving the LF API through this proxy.
+func (p *Proxy) GetHTTPHandler() http.Handler {
+	return httpCompressionHandler(apiVersionedServeMux(p.createHTTPServeMux()))
+}
+
+// cacheGet returns a cached response body if one exists and has not expired.
+func (p *Proxy) cacheGet(key string) []byte {
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+	e := p.cache[key]
+	if e != nil {
+		if time.Now().Before(e.expires) {
+			return e.body
+		}
+		delete(p.cache, key)
+	}
+	return nil
+}
+
+// cachePut caches a response body, first removing expired entries (or arbitrary ones) if the cache is full.
+func (p *Proxy) cachePut(key string, body []byte, ttl time.Duration) {
+	now := time.Now()
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+	if len(p.cache) >= proxyMaxCacheEntries {
+		for k, e := range p.cache {
+			if now.After(e.expires) {
+				delete(p.cache, k)
+			}
+		}
+		for k := range p.cache {
+			if len(p.cache) < proxyMaxCacheEntries {
+				break
+			}
+			delete(p.cache, k)
+		}
+	}
+	p.cache[key] = &proxyCacheEntry{body: body, expires: now.Add(ttl)}
+}
+
+// isTrusted returns true if a request comes from a client on this host.
+// Requests with an Origin header come from browsers, which will send requests to localhost on behalf of
+// any web page, so they are never trusted (see Node.apiIsAuthorized).
+func (p *Proxy) isTrusted(req *http.Request) bool {
+	if len(req.Header.Get("Origin")) > 0 {
+		return false
+	}
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	return net.ParseIP(ip).IsLoopback()
+}
+
+// sendUpstreamError relays an error from upstream, using 502 for errors that are not HTTP API errors.
+func (p *Proxy) sendUpstreamError(out http.ResponseWriter, req *http.Request, err error) {
+	if e, ok := err.(ErrAPI); ok && e.Code >= 400 {
+		apiSendObj(out, req, e.Code, &e)
+		return
+	}
+	p.log.Printf("WARNING: upstream request for %s failed: %s", req.URL.Path, err.Error())
+	apiSendObj(out, req, http.StatusBadGateway, &ErrAPI{Code: http.StatusBadGateway, Message: "upstream request failed: " + err.Error(), ErrTypeName: errTypeName(err)})
+}
+
+// proxySendJSON sends a cached or freshly fetched JSON response body.
+func proxySendJSON(out http.ResponseWriter, req *http.Request, body []byte) {
+	out.Header().Set("Content-Type", "application/json")
+	out.WriteHeader(http.StatusOK)
+	if req.Method != http.MethodHead {
+		_, _ = out.Write(body)
+	}
+}
+
+// getRecord gets a record from cache or upstream, checking its hash and signatures before caching it.
+func (p *Proxy) getRecord(hash []byte) (*Record, error) {
+	key := "r:" + string(hash)
+	if b := p.cacheGet(key); b != nil {
+		return NewRecordFromBytes(b)
+	}
+	rec, err := p.upstream.GetRecord(hash)
+	if err != nil {
+		return nil, err
+	}
+	rh := rec.Hash()
+	if !bytes.Equal(rh[:], hash) {
+		return nil, ErrVerifyHashMismatch
+	}
+	if err = rec.Validate(); err != nil {
+		return nil, err
+	}
+	p.cachePut(key, rec.Bytes(), proxyRecordCacheTTL)
+	return rec, nil
+}
+
+func (p *Proxy) createHTTPServeMux() *http.ServeMux {
+	smux := http.NewServeMux()
+
+	methodNotAllowed := func(out http.ResponseWriter, req *http.Request, allow string) {
+		out.Header().Set("Allow", allow)
+		apiSendObj(out, req, http.StatusMethodNotAllowed, &ErrAPI{Code: http.StatusMethodNotAllowed, Message: req.Method + " not supported for this path"})
+	}
+
+	smux.HandleFunc("/query", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			body, err := ioutil.ReadAll(&io.LimitedReader{R: req.Body, N: proxyMaxRequestSize})
+			var m Query
+			if err == nil {
+				err = json.Unmarshal(body, &m)
+			}
+			if err != nil {
+				apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "invalid or malformed payload: " + err.Error()})
+				return
+			}
+			bodyHash := sha256.Sum256(body)
+			key := "q:" + string(bodyHash[:])
+			if cached := p.cacheGet(key); cached != nil {
+				proxySendJSON(out, req, cached)
+				return
+			}
+			results, err := p.upstream.ExecuteQuery(&m)
+			if err != nil {
+				p.sendUpstreamError(out, req, err)
+				return
+			}
+			j, _ := json.Marshal(results)
+			p.cachePut(key, j, p.ttl)
+			proxySendJSON(out, req, j)
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/query/batch", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m QueryBatch
+			if apiReadObj(out, req, &m) == nil {
+				results, err := p.upstream.ExecuteQueryBatch(m)
+				if err != nil {
+					p.sendUpstreamError(out, req, err)
+				} else {
+					apiSendObj(out, req, http.StatusOK, results)
+				}
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/post", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var rec Record
+			err := rec.UnmarshalFrom(req.Body)
+			if err == nil {
+				err = rec.Validate()
+			}
+			if err != nil {
+				apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "record deserialization failed: " + err.Error()})
+				return
+			}
+			if err = p.upstream.AddRecord(&rec); err != nil {
+				p.sendUpstreamError(out, req, err)
+			} else {
+				apiSendObj(out, req, http.StatusOK, rec)
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/pulse", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var pbuf [PulseSize]byte
+			pulse := Pulse(pbuf[:])
+			_, err := io.ReadFull(req.Body, pulse[:])
+			if err != nil {
+				apiSendObj(out, req, http.StatusBadRequest, &ErrAPI{Code: http.StatusBadRequest, Message: "read error: " + err.Error()})
+			} else {
+				ok, _ := p.upstream.DoPulse(pulse, true)
+				apiSendObj(out, req, http.StatusOK, &pulsePostResult{pulse, ok})
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/makerecord", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			if p.isTrusted(req) {
+				var m MakeRecord
+				if apiReadObj(out, req, &m) == nil {
+					rec, pulse, ok, err := p.upstream.ExecuteMakeRecord(&m)
+					if err != nil {
+						p.sendUpstreamError(out, req, err)
+					} else {
+						apiSendObj(out, req, http.StatusOK, &remoteMakeResult{pulse, rec, ok})
+					}
+				}
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients can delegate record creation"})
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/work", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			// The proxy's own upstream credentials (or loopback trust) would authorize the work upstream, so only
+			// trusted clients can use them. Any AuthToken in the request is not checked here and isn't enough.
+			if p.isTrusted(req) {
+				var m MakeWork
+				if apiReadObj(out, req, &m) == nil {
+					result, err := p.upstream.ExecuteMakeWork(&m)
+					if err != nil {
+						p.sendUpstreamError(out, req, err)
+					} else {
+						apiSendObj(out, req, http.StatusOK, result)
+					}
+				}
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients can delegate proof of work"})
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/estimate", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m Estimate
+			if apiReadObj(out, req, &m) == nil {
+				result, err := p.upstream.ExecuteEstimate(&m)
+				if err != nil {
+					p.sendUpstreamError(out, req, err)
+				} else {
+					apiSendObj(out, req, http.StatusOK, result)
+				}
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/makepulse", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m MakePulse
+			if apiReadObj(out, req, &m) == nil {
+				pulse, rec, ok, err := p.upstream.ExecuteMakePulse(&m)
+				if err != nil {
+					p.sendUpstreamError(out, req, err)
+				} else {
+					apiSendObj(out, req, http.StatusOK, &remoteMakeResult{pulse, rec, ok})
+				}
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/connect", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			if p.isTrusted(req) {
+				var m Peer
+				if apiReadObj(out, req, &m) == nil {
+					if err := p.upstream.Connect(m.IP, m.Port, m.Identity); err != nil {
+						p.sendUpstreamError(out, req, err)
+					} else {
+						apiSendObj(out, req, http.StatusOK, nil)
+					}
+				}
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients can suggest P2P endpoints"})
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/comment", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			if p.isTrusted(req) {
+				var m Comment
+				if apiReadObj(out, req, &m) == nil {
+					if err := p.upstream.AddComment(&m); err != nil {
+						p.sendUpstreamError(out, req, err)
+					} else {
+						apiSendObj(out, req, http.StatusOK, nil)
+					}
+				}
+			} else {
+				apiSendObj(out, req, http.StatusForbidden, &ErrAPI{Code: http.StatusForbidden, Message: "only trusted clients can submit comments"})
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/comments", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodPost || req.Method == http.MethodPut {
+			var m CommentQuery
+			if apiReadObj(out, req, &m) == nil {
+				comments, err := p.upstream.GetComments(&m)
+				if err != nil {
+					p.sendUpstreamError(out, req, err)
+				} else {
+					apiSendObj(out, req, http.StatusOK, comments)
+				}
+			}
+		} else {
+			methodNotAllowed(out, req, "POST, PUT")
+		}
+	})
+
+	smux.HandleFunc("/record/", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			urlPath := req.URL.Path
+			raw := strings.HasPrefix(urlPath, "/record/raw/")
+			if raw {
+				urlPath = urlPath[12:]
+			} else {
+				urlPath = urlPath[8:]
+			}
+			if len(urlPath) > 1 && urlPath[0] == '=' {
+				recordHash := Base62Decode(urlPath[1:])
+				if len(recordHash) == 32 {
+					rec, err := p.getRecord(recordHash)
+					if err == nil {
+						if raw {
+							out.Header().Set("Content-Type", "application/octet-stream")
+							out.WriteHeader(http.StatusOK)
+							if req.Method != http.MethodHead {
+								_, _ = out.Write(rec.Bytes())
+							}
+						} else {
+							apiSendObj(out, req, http.StatusOK, rec)
+						}
+						return
+					}
+					if e, ok := err.(ErrAPI); !ok || e.Code != http.StatusNotFound {
+						p.sendUpstreamError(out, req, err)
+						return
+					}
+				}
+			}
+			apiSendObj(out, req, http.StatusNotFound, &ErrAPI{Code: http.StatusNotFound, Message: req.URL.Path + " not found"})
+		} else {
+			methodNotAllowed(out, req, "GET, HEAD")
+		}
+	})
+
+	smux.HandleFunc("/links", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			desired, _ := strconv.ParseInt(req.URL.Query().Get("count"), 10, 64)
+			links, _, err := p.upstream.Links(int(desired))
+			if err != nil {
+				p.sendUpstreamError(out, req, err)
+				return
+			}
+			out.Header().Set("Content-Type", "application/octet-stream")
+			out.WriteHeader(http.StatusOK)
+			for i := range links {
+				_, _ = out.Write(links[i][:])
+			}
+		} else {
+			methodNotAllowed(out, req, "GET, HEAD")
+		}
+	})
+
+	smux.HandleFunc("/status", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			nodeStatus, err := p.upstream.NodeStatus()
+			if err != nil {
+				p.sendUpstreamError(out, req, err)
+			} else {
+				apiSendObj(out, req, http.StatusOK, nodeStatus)
+			}
+		} else {
+			methodNotAllowed(out, req, "GET, HEAD")
+		}
+	})
+
+	smux.HandleFunc("/owner/", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			urlPath := req.URL.Path[7:]
+			if len(urlPath) > 1 && urlPath[0] == '@' {
+				ownerPublic, _ := NewOwnerPublicFromString(urlPath)
+				if len(ownerPublic) > 0 {
+					key := "o:" + string(ownerPublic)
+					if cached := p.cacheGet(key); cached != nil {
+						proxySendJSON(out, req, cached)
+						return
+					}
+					ownerStatus, err := p.upstream.OwnerStatus(ownerPublic)
+					if err != nil {
+						p.sendUpstreamError(out, req, err)
+						return
+					}
+					j, _ := json.Marshal(ownerStatus)
+					p.cachePut(key, j, p.ttl)
+					proxySendJSON(out, req, j)
+					return
+				}
+			}
+			apiSendObj(out, req, http.StatusNotFound, &ErrAPI{Code: http.StatusNotFound, Message: req.URL.Path + " not found"})
+		} else {
+			methodNotAllowed(out, req, "GET, HEAD")
+		}
+	})
+
+	smux.HandleFunc("/", func(out http.ResponseWriter, req *http.Request) {
+		apiSetStandardHeaders(out)
+		if req.Method == http.MethodGet || req.Method == http.MethodHead {
+			apiSendObj(out, req, http.StatusNotFound, &ErrAPI{Code: http.StatusNotFound, Message: req.URL.Path + " not found"})
+		} else {
+			methodNotAllowed(out, req, "GET, HEAD")
+		}
+	})
+
+	return smux
+}
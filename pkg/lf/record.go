@@ -15,6 +15,7 @@ package lf
 
 import (
 	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha256"
@@ -100,6 +101,28 @@ const (
 	RecordCertificateMaskingKey = "lfCertificate"
 )
 
+// RecordWorkDifficulty estimates the Wharrgarbl difficulty that will be required for a new record.
+// The value size is the size of the value before compression, so the result is conservative for
+// compressible values. It also assumes the largest owner type and that records with selectors have
+// a pulse token, as records built by NewRecord do.
+func RecordWorkDifficulty(valueSize, selectorCount, linkCount int, selectorType byte) (uint32, error) {
+	if valueSize < 0 || valueSize > RecordMaxSize || selectorCount < 0 || selectorCount > RecordMaxSelectors || linkCount < 0 || linkCount > RecordMaxLinks || selectorClaimSize(selectorType) == 0 {
+		return 0, ErrInvalidParameter
+	}
+	var rb recordBody
+	if valueSize > 0 {
+		rb.Value = make([]byte, valueSize+2) // includes two byte CRC/flags header
+	}
+	rb.Owner = make([]byte, 32)
+	rb.Links = make([]HashBlob, linkCount)
+	rb.Timestamp = TimeSec()
+	if selectorCount > 0 {
+		rb.PulseToken = 0xffffffffffffffff
+	}
+	s := Selector{Type: selectorType, Claim: make([]byte, selectorClaimSize(selectorType))}
+	return recordWharrgarblCost(rb.sizeBytes() + (uint(selectorCount) * uint(len(s.Bytes())))), nil
+}
+
 // recordWharrgarblCost computes the cost in Wharrgarbl difficulty for a record of a given number of "billable" bytes.
 func recordWharrgarblCost(bytes uint) uint32 {
 	//
@@ -618,6 +641,13 @@ func (r *Record) Validate() (err error) {
 	if len(r.Selectors) > RecordMaxSelectors {
 		return ErrRecordTooManySelectors
 	}
+	recordBodyHash := r.recordBody.signingHash()
+	for i := range r.Selectors {
+		// BP160 claims always recover some key, but other types carry a signature that must verify.
+		if r.Selectors[i].Type != SelectorTypeBP160 && r.Selectors[i].claimKey(recordBodyHash[:]) == nil {
+			return ErrRecordInvalidSelector
+		}
+	}
 
 	if len(r.Links) > RecordMaxLinks {
 		return ErrRecordTooManyLinks
@@ -679,8 +709,11 @@ func (r *Record) workHash() ([]byte, uint) {
 
 // RecordBuilder allows records to be built in multiple steps.
 // This could be used in the future to support record building across different nodes
-// if we ever need that.
+// if we ever need that. SelectorTypes can be set before Start to create selectors of
+// types other than the default SelectorTypeBP160.
 type RecordBuilder struct {
+	SelectorTypes []byte // Optional types for selectors by index, default is SelectorTypeBP160
+
 	record            *Record
 	workHash          Blob
 	workBillableBytes uint
@@ -742,7 +775,14 @@ func (rb *RecordBuilder) Start(recordType int, value []byte, links [][32]byte, m
 	if len(selectorNames) > 0 {
 		rb.record.Selectors = make([]Selector, len(selectorNames))
 		for i := 0; i < len(selectorNames); i++ {
-			rb.record.Selectors[i].set(selectorNames[i], selectorOrdinals[i], recordBodyHash[:])
+			selectorType := SelectorTypeBP160
+			if i < len(rb.SelectorTypes) {
+				selectorType = rb.SelectorTypes[i]
+			}
+			err := rb.record.Selectors[i].set(selectorType, selectorNames[i], selectorOrdinals[i], recordBodyHash[:])
+			if err != nil {
+				return err
+			}
 			sb := rb.record.Selectors[i].Bytes()
 			rb.workBillableBytes += uint(len(sb))
 			workHasher.Write(sb)
@@ -759,12 +799,20 @@ func (rb *RecordBuilder) Start(recordType int, value []byte, links [][32]byte, m
 // The minWorkFunctionDifficulty parameter can be used if you want to do extra work to altruistically
 // add work to the DAG. Otherwise it should be zero.
 func (rb *RecordBuilder) AddWork(workFunction *Wharrgarblr, minWorkFunctionDifficulty uint32) error {
+	return rb.AddWorkContext(context.Background(), workFunction, minWorkFunctionDifficulty, nil)
+}
+
+// AddWorkContext is like AddWork but can be canceled via a context and can report progress (see Wharrgarblr.ComputeContext).
+func (rb *RecordBuilder) AddWorkContext(ctx context.Context, workFunction *Wharrgarblr, minWorkFunctionDifficulty uint32, progress WharrgarblProgressFunc) error {
 	if workFunction != nil {
 		diff := recordWharrgarblCost(rb.workBillableBytes)
 		if diff < minWorkFunctionDifficulty {
 			diff = minWorkFunctionDifficulty
 		}
-		w, iter := workFunction.Compute(rb.workHash, diff)
+		w, iter, err := workFunction.ComputeContext(ctx, rb.workHash, diff, progress)
+		if err != nil {
+			return err
+		}
 		if iter == 0 {
 			return ErrWharrgarblFailed
 		}
@@ -774,6 +822,22 @@ func (rb *RecordBuilder) AddWork(workFunction *Wharrgarblr, minWorkFunctionDiffi
 	return nil
 }
 
+// WorkHash returns the hash over which work must be computed and the minimum difficulty required.
+// This and SetWork can be used to delegate work to another node via ExecuteMakeWork.
+func (rb *RecordBuilder) WorkHash() ([]byte, uint32) {
+	return rb.workHash, recordWharrgarblCost(rb.workBillableBytes)
+}
+
+// SetWork sets work computed elsewhere, returning an error if it is not valid for this record.
+func (rb *RecordBuilder) SetWork(work []byte) error {
+	if len(work) != WharrgarblOutputSize || WharrgarblVerify(work, rb.workHash) < recordWharrgarblCost(rb.workBillableBytes) {
+		return ErrRecordInsufficientWork
+	}
+	rb.record.Work = work
+	rb.record.WorkAlgorithm = RecordWorkAlgorithmWharrgarbl
+	return nil
+}
+
 // Complete computes the signing hash, signs the record, and returns a pointer to completed record on success.
 // It must be supplied with an Owner containing a full private key as well as public information.
 func (rb *RecordBuilder) Complete(owner *Owner) (*Record, error) {
@@ -793,16 +857,26 @@ func (rb *RecordBuilder) Complete(owner *Owner) (*Record, error) {
 
 // NewRecord is a shortcut to running all incremental record creation functions.
 func NewRecord(recordType int, value []byte, links [][32]byte, maskingKey []byte, selectorNames [][]byte, selectorOrdinals []uint64, timestamp uint64, workFunction *Wharrgarblr, owner *Owner) (*Record, error) {
+	return NewRecordContext(context.Background(), recordType, value, links, maskingKey, selectorNames, selectorOrdinals, timestamp, workFunction, owner, nil)
+}
+
+// NewRecordContext is like NewRecord but work can be canceled via a context and can report progress.
+func NewRecordContext(ctx context.Context, recordType int, value []byte, links [][32]byte, maskingKey []byte, selectorNames [][]byte, selectorOrdinals []uint64, timestamp uint64, workFunction *Wharrgarblr, owner *Owner, progress WharrgarblProgressFunc) (*Record, error) {
+	return newRecord(ctx, recordType, value, links, maskingKey, selectorNames, selectorOrdinals, nil, timestamp, workFunction, owner, progress)
+}
+
+// newRecord runs all incremental record creation functions with optional selector types (see RecordBuilder).
+func newRecord(ctx context.Context, recordType int, value []byte, links [][32]byte, maskingKey []byte, selectorNames [][]byte, selectorOrdinals []uint64, selectorTypes []byte, timestamp uint64, workFunction *Wharrgarblr, owner *Owner, progress WharrgarblProgressFunc) (*Record, error) {
 	pulseToken, err := NewPulse(owner, selectorNames, selectorOrdinals, timestamp, 0)
 	if err != nil {
 		return nil, err
 	}
-	var rb RecordBuilder
+	rb := RecordBuilder{SelectorTypes: selectorTypes}
 	err = rb.Start(recordType, value, links, maskingKey, selectorNames, selectorOrdinals, owner.Public, pulseToken.Key(), timestamp)
 	if err != nil {
 		return nil, err
 	}
-	err = rb.AddWork(workFunction, 0)
+	err = rb.AddWorkContext(ctx, workFunction, 0, progress)
 	if err != nil {
 		return nil, err
 	}
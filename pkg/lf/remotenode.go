@@ -30,9 +30,61 @@ import (
 // APIMaxResponseSize is a sanity limit on the maximum size of a response from the LF HTTP API (can be increased)
 const APIMaxResponseSize = 4194304
 
-var httpClient = http.Client{Timeout: time.Second * 30}
+var httpClient = http.Client{Timeout: time.Second * 30, Transport: remoteNodeTransport{}}
+
+// httpWorkClient is used for remote proof of work, which can take a long time at high difficulties.
+var httpWorkClient = http.Client{Timeout: time.Hour, Transport: remoteNodeTransport{}}
+
+// httpExportClient is used for export streams, which have no overall time limit since they can be very large.
+var httpExportClient = http.Client{Transport: remoteNodeTransport{}}
+
+// newAPIRequest creates an HTTP request, sending any token in the URL's user info as a bearer token.
+// URLs with the https+mtls scheme are requested over HTTPS with a client certificate.
+func newAPIRequest(method, urlStr string, body io.Reader) (*http.Request, error) {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	mtls := u.Scheme == RemoteNodeMTLSScheme
+	if mtls {
+		u.Scheme = "https"
+	}
+	var token string
+	if u.User != nil {
+		token = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			token = password
+		}
+		u.User = nil
+	}
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if mtls {
+		req = withRemoteNodeMTLS(req)
+	}
+	return req, nil
+}
+
+// apiPost posts a binary request body to a URL.
+func apiPost(urlStr string, body []byte) (*http.Response, error) {
+	req, err := newAPIRequest(http.MethodPost, urlStr, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return httpClient.Do(req)
+}
 
 func apiRequest(url string, m interface{}) ([]byte, error) {
+	return apiRequestWithClient(&httpClient, url, m)
+}
+
+func apiRequestWithClient(client *http.Client, url string, m interface{}) ([]byte, error) {
 	var requestBody io.Reader
 	requestBody = http.NoBody
 	method := "GET"
@@ -45,12 +97,12 @@ func apiRequest(url string, m interface{}) ([]byte, error) {
 		requestBody = bytes.NewReader(msgJSON)
 	}
 
-	req, err := http.NewRequest(method, url, requestBody)
+	req, err := newAPIRequest(method, url, requestBody)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Add("Accept-Encoding", "gzip")
-	resp, err := httpClient.Do(req)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -102,9 +154,35 @@ func NewRemoteNode(urlStr string) (RemoteNode, error) {
 	return RemoteNode(upstr), nil
 }
 
+// WithToken returns this node's URL with an API token included as user info, replacing any token already present.
+func (rn RemoteNode) WithToken(token string) RemoteNode {
+	u, err := url.Parse(string(rn))
+	if err != nil {
+		return rn
+	}
+	u.User = url.User(token)
+	return RemoteNode(u.String())
+}
+
+// HasToken returns true if this node's URL includes an API token.
+func (rn RemoteNode) HasToken() bool {
+	u, err := url.Parse(string(rn))
+	return err == nil && u.User != nil
+}
+
+// DisplayURL returns this node's URL without any API token, for logs and messages.
+func (rn RemoteNode) DisplayURL() string {
+	u, err := url.Parse(string(rn))
+	if err != nil {
+		return string(rn)
+	}
+	u.User = nil
+	return u.String()
+}
+
 // AddRecord submits this record for addition to the data store.
 func (rn RemoteNode) AddRecord(rec *Record) error {
-	resp, err := httpClient.Post(string(rn)+"/post", "application/octet-stream", bytes.NewReader(rec.Bytes()))
+	resp, err := apiPost(string(rn)+"/post", rec.Bytes())
 	if err != nil {
 		return err
 	}
@@ -134,7 +212,7 @@ func (rn RemoteNode) AddRecord(rec *Record) error {
 
 // GetRecord looks up a record by its exact hash.
 func (rn RemoteNode) GetRecord(hash []byte) (*Record, error) {
-	if len(hash) == 32 {
+	if len(hash) != 32 {
 		return nil, ErrInvalidParameter
 	}
 	body, err := apiRequest(string(rn)+"/record/raw/="+Base62Encode(hash), nil)
@@ -190,7 +268,11 @@ func (rn RemoteNode) Links(count int) ([][32]byte, uint64, error) {
 	if count > 0 {
 		u = u + "?count=" + strconv.FormatUint(uint64(count), 10)
 	}
-	resp, err := httpClient.Get(u)
+	req, err := newAPIRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -216,6 +298,24 @@ func (rn RemoteNode) Links(count int) ([][32]byte, uint64, error) {
 	return nil, 0, ErrAPI{Code: resp.StatusCode}
 }
 
+// Export starts an export stream from the remote node at a checkpoint offset (0 for everything).
+// The caller must close the returned reader, which can be read with NewExportReader.
+func (rn RemoteNode) Export(offset uint64) (io.ReadCloser, error) {
+	req, err := newAPIRequest(http.MethodGet, string(rn)+"/export?offset="+strconv.FormatUint(offset, 10), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpExportClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, ErrAPI{Code: resp.StatusCode, Message: resp.Status}
+	}
+	return resp.Body, nil
+}
+
 // ExecuteQuery executes a query against this remote node.
 func (rn RemoteNode) ExecuteQuery(q *Query) (QueryResults, error) {
 	body, err := apiRequest(string(rn)+"/query", q)
@@ -230,6 +330,20 @@ func (rn RemoteNode) ExecuteQuery(q *Query) (QueryResults, error) {
 	return qr, nil
 }
 
+// ExecuteQueryBatch executes a batch of queries against this remote node.
+func (rn RemoteNode) ExecuteQueryBatch(queries QueryBatch) ([]QueryBatchResult, error) {
+	body, err := apiRequest(string(rn)+"/query/batch", queries)
+	if err != nil {
+		return nil, err
+	}
+	var qbr []QueryBatchResult
+	err = json.Unmarshal(body, &qbr)
+	if err != nil {
+		return nil, err
+	}
+	return qbr, nil
+}
+
 // ExecuteMakeRecord instructs a remote node to create a record.
 func (rn RemoteNode) ExecuteMakeRecord(mr *MakeRecord) (*Record, Pulse, bool, error) {
 	body, err := apiRequest(string(rn)+"/makerecord", mr)
@@ -258,9 +372,37 @@ func (rn RemoteNode) ExecuteMakePulse(mr *MakePulse) (Pulse, *Record, bool, erro
 	return nil, nil, false, err
 }
 
+// ExecuteMakeWork asks a remote node to compute proof of work for a record built locally.
+func (rn RemoteNode) ExecuteMakeWork(mw *MakeWork) (*MakeWorkResult, error) {
+	body, err := apiRequestWithClient(&httpWorkClient, string(rn)+"/work", mw)
+	if err != nil {
+		return nil, err
+	}
+	var wr MakeWorkResult
+	err = json.Unmarshal(body, &wr)
+	if err != nil {
+		return nil, err
+	}
+	return &wr, nil
+}
+
+// ExecuteEstimate asks a remote node to estimate the proof of work required for a record.
+func (rn RemoteNode) ExecuteEstimate(e *Estimate) (*EstimateResult, error) {
+	body, err := apiRequest(string(rn)+"/estimate", e)
+	if err != nil {
+		return nil, err
+	}
+	var er EstimateResult
+	err = json.Unmarshal(body, &er)
+	if err != nil {
+		return nil, err
+	}
+	return &er, nil
+}
+
 // DoPulse posts a pulse to this node and returns whether or not it was accepted.
 func (rn RemoteNode) DoPulse(pulse Pulse, announce bool) (bool, error) {
-	resp, err := httpClient.Post(string(rn)+"/pulse", "application/octet-stream", bytes.NewReader(pulse))
+	resp, err := apiPost(string(rn)+"/pulse", pulse)
 	if err != nil {
 		return false, err
 	}
@@ -297,11 +439,116 @@ func (rn RemoteNode) DoPulse(pulse Pulse, announce bool) (bool, error) {
 
 // Connect instructs this node to initiate a remote connection
 func (rn RemoteNode) Connect(ip net.IP, port int, identity []byte) error {
-	_, err := apiRequest(string(rn)+"/connect", &Peer{
+	return rn.ConnectPeer(&Peer{
 		IP:       ip,
 		Port:     port,
 		Identity: identity,
 	})
+}
+
+// ConnectPeer instructs this node to initiate a remote connection, including a zone for IPv6 link-local addresses.
+func (rn RemoteNode) ConnectPeer(peer *Peer) error {
+	_, err := apiRequest(string(rn)+"/connect", peer)
+	return err
+}
+
+// AddComment asks this remote node to publish a comment (only accepted from localhost).
+func (rn RemoteNode) AddComment(c *Comment) error {
+	_, err := apiRequest(string(rn)+"/comment", c)
+	return err
+}
+
+// GetComments gets comments about a record or owner from this remote node.
+func (rn RemoteNode) GetComments(q *CommentQuery) ([]Comment, error) {
+	body, err := apiRequest(string(rn)+"/comments", q)
+	if err != nil {
+		return nil, err
+	}
+	var comments []Comment
+	err = json.Unmarshal(body, &comments)
+	if err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+// CreateAPIToken asks this node to create a named API token (requires admin scope).
+func (rn RemoteNode) CreateAPIToken(c *APITokenCreate) (*APIToken, error) {
+	body, err := apiRequest(string(rn)+"/token/create", c)
+	if err != nil {
+		return nil, err
+	}
+	var t APIToken
+	err = json.Unmarshal(body, &t)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// ListAPITokens gets this node's named API tokens without their secrets (requires admin scope).
+func (rn RemoteNode) ListAPITokens() ([]APIToken, error) {
+	body, err := apiRequest(string(rn)+"/token/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []APIToken
+	err = json.Unmarshal(body, &tokens)
+	if err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// RevokeAPIToken asks this node to revoke a named API token (requires admin scope).
+func (rn RemoteNode) RevokeAPIToken(name string) error {
+	_, err := apiRequest(string(rn)+"/token/revoke", &APITokenRevoke{Name: name})
+	return err
+}
+
+// ListPeers gets this node's known and connected peers (requires admin scope).
+func (rn RemoteNode) ListPeers() ([]PeerInfo, error) {
+	body, err := apiRequest(string(rn)+"/peer/list", nil)
+	if err != nil {
+		return nil, err
+	}
+	var peers []PeerInfo
+	err = json.Unmarshal(body, &peers)
+	if err != nil {
+		return nil, err
+	}
+	return peers, nil
+}
+
+// PinPeer asks this node to pin or unpin a peer (requires admin scope).
+func (rn RemoteNode) PinPeer(peer *Peer, pinned bool) error {
+	op := "/peer/unpin"
+	if pinned {
+		op = "/peer/pin"
+	}
+	_, err := apiRequest(string(rn)+op, peer)
+	return err
+}
+
+// BanPeer asks this node to ban or unban a peer by identity (requires admin scope).
+func (rn RemoteNode) BanPeer(identity []byte, banned bool) error {
+	op := "/peer/unban"
+	if banned {
+		op = "/peer/ban"
+	}
+	_, err := apiRequest(string(rn)+op, &Peer{Identity: identity})
+	return err
+}
+
+// DropPeer asks this node to close its connections to a peer (requires admin scope).
+func (rn RemoteNode) DropPeer(identity []byte) error {
+	_, err := apiRequest(string(rn)+"/peer/drop", &Peer{Identity: identity})
+	return err
+}
+
+// ForgetPeer asks this node to remove a peer from its known peers (requires admin scope).
+func (rn RemoteNode) ForgetPeer(identity []byte) error {
+	_, err := apiRequest(string(rn)+"/peer/forget", &Peer{Identity: identity})
 	return err
 }
 
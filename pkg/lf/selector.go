@@ -19,20 +19,104 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"io"
+	"strings"
+
+	"golang.org/x/crypto/ed25519"
 )
 
 // SelectorTypeBP160 is a 57-byte (serialized) selector built from the BrainpoolP160T1 small elliptic curve.
 // This is a protocol constant and cannot be changed.
 const SelectorTypeBP160 byte = 0 // valid range 0..15
 
+// SelectorTypeEd25519 is a 113-byte (serialized) selector built from Ed25519 with a stronger 128-bit security level.
+// Ed25519 signatures can't be used for key recovery, so its claim contains the public key followed by the signature.
+// This is a protocol constant and cannot be changed.
+const SelectorTypeEd25519 byte = 1
+
 // SelectorKeySize is the size of the sortable value used for database range queries.
 const SelectorKeySize = 32
 
+// selectorEd25519SeedPrefix is prepended to Ed25519 selector names to derive keys distinct from BP160 ones.
+const selectorEd25519SeedPrefix = "lfSelectorEd25519:"
+
 // Selector is a non-forgeable range queryable identifier for records.
 // It can also rewind on request from the MC.
 type Selector struct {
+	Type    byte    `json:",omitempty"` // Selector type (default: SelectorTypeBP160)
 	Ordinal Ordinal `json:",omitempty"` // A plain text sortable field that can be used for range queries against secret selectors
-	Claim   Blob    `json:",omitempty"` // 41-byte brainpoolP160t1 recoverable signature
+	Claim   Blob    `json:",omitempty"` // 41-byte brainpoolP160t1 recoverable signature or 96-byte Ed25519 public key and signature
+}
+
+// SelectorTypeFromString converts a canonical string name to a selector type, returning false if not recognized.
+func SelectorTypeFromString(typeString string) (byte, bool) {
+	switch strings.ToLower(strings.TrimSpace(typeString)) {
+	case "", "bp160":
+		return SelectorTypeBP160, true
+	case "ed25519":
+		return SelectorTypeEd25519, true
+	}
+	return 0, false
+}
+
+// selectorClaimSize returns the size of a claim for a given selector type or 0 if the type is not supported.
+func selectorClaimSize(selectorType byte) int {
+	switch selectorType {
+	case SelectorTypeBP160:
+		return 41
+	case SelectorTypeEd25519:
+		return ed25519.PublicKeySize + ed25519.SignatureSize
+	}
+	return 0
+}
+
+// selectorPrivateKey deterministically derives the private key for a selector of a given type from its plain text name.
+// It returns either an *ecdsa.PrivateKey or an ed25519.PrivateKey.
+func selectorPrivateKey(selectorType byte, plainTextName []byte) (interface{}, error) {
+	var prng seededPrng
+	switch selectorType {
+	case SelectorTypeBP160:
+		prng.seed(plainTextName)
+		return ecdsa.GenerateKey(ECCCurveBrainpoolP160T1, &prng)
+	case SelectorTypeEd25519:
+		prng.seed(append([]byte(selectorEd25519SeedPrefix), plainTextName...))
+		var seed [ed25519.SeedSize]byte
+		_, _ = prng.Read(seed[:])
+		return ed25519.NewKeyFromSeed(seed[:]), nil
+	}
+	return nil, ErrInvalidParameter
+}
+
+// selectorKeyHash returns the hash of a selector public key used to build sortable selector keys.
+func selectorKeyHash(pub interface{}) (hb [32]byte, err error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		if k == nil {
+			err = ErrInvalidPublicKey
+			return
+		}
+		return ECDSAHashPublicKey(k)
+	case ed25519.PublicKey:
+		if len(k) != ed25519.PublicKeySize {
+			err = ErrInvalidPublicKey
+			return
+		}
+		s256 := sha256.New()
+		s256.Write(k)
+		s256.Write([]byte("ed25519"))
+		s256.Sum(hb[:0])
+		return
+	}
+	err = ErrInvalidPublicKey
+	return
+}
+
+// selectorClaimHash computes the hash signed by a selector claim.
+func selectorClaimHash(hash []byte, ordinal *Ordinal) []byte {
+	sigHash := sha256.New()
+	sigHash.Write(hash)
+	sigHash.Write(ordinal[:])
+	var sigHashBuf [32]byte
+	return sigHash.Sum(sigHashBuf[:0])
 }
 
 // addOrdinalToHash adds a 128-bit ordinal to a 256-bit hash.
@@ -59,22 +143,38 @@ func addOrdinalToHash(h *[32]byte, ordinal *Ordinal) {
 	binary.BigEndian.PutUint64(h[24:32], d)
 }
 
-// MakeSelectorKey obtains a sortable database/query key from a plain text name and ordinal.
+// MakeSelectorKey obtains a sortable database/query key from a plain text name and ordinal for a BP160 selector.
 func MakeSelectorKey(plainTextName []byte, plainTextOrdinal uint64) []byte {
-	var prng seededPrng
-	prng.seed(plainTextName)
-	privateKey, err := ecdsa.GenerateKey(ECCCurveBrainpoolP160T1, &prng)
+	key, err := MakeSelectorKeyWithType(SelectorTypeBP160, plainTextName, plainTextOrdinal)
 	if err != nil {
 		panic(err)
 	}
+	return key
+}
 
-	key, _ := ECDSAHashPublicKey(&privateKey.PublicKey)
+// MakeSelectorKeyWithType obtains a sortable database/query key from a plain text name and ordinal for a selector of a given type.
+func MakeSelectorKeyWithType(selectorType byte, plainTextName []byte, plainTextOrdinal uint64) ([]byte, error) {
+	priv, err := selectorPrivateKey(selectorType, plainTextName)
+	if err != nil {
+		return nil, err
+	}
+
+	var key [32]byte
+	switch k := priv.(type) {
+	case *ecdsa.PrivateKey:
+		key, err = selectorKeyHash(&k.PublicKey)
+	case ed25519.PrivateKey:
+		key, err = selectorKeyHash(k.Public())
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	var ord Ordinal
 	ord.Set(plainTextOrdinal, plainTextName)
 	addOrdinalToHash(&key, &ord)
 
-	return key[:]
+	return key[:], nil
 }
 
 // NewSelectorFromBytes decodes a byte-serialized selector.
@@ -92,59 +192,102 @@ func (s *Selector) Bytes() []byte {
 }
 
 // claimKey recovers the public key from this selector's claim and the record body hash used to generate it.
-func (s *Selector) claimKey(hash []byte) *ecdsa.PublicKey {
-	sigHash := sha256.New()
-	sigHash.Write(hash)
-	sigHash.Write(s.Ordinal[:])
-	var sigHashBuf [32]byte
-	return ECDSARecover(ECCCurveBrainpoolP160T1, sigHash.Sum(sigHashBuf[:0]), s.Claim)
+// It returns an *ecdsa.PublicKey or an ed25519.PublicKey, or nil if the claim is invalid.
+func (s *Selector) claimKey(hash []byte) interface{} {
+	switch s.Type {
+	case SelectorTypeBP160:
+		pub := ECDSARecover(ECCCurveBrainpoolP160T1, selectorClaimHash(hash, &s.Ordinal), s.Claim)
+		if pub == nil {
+			return nil
+		}
+		return pub
+	case SelectorTypeEd25519:
+		if len(s.Claim) != selectorClaimSize(SelectorTypeEd25519) {
+			return nil
+		}
+		pub := ed25519.PublicKey(s.Claim[0:ed25519.PublicKeySize])
+		if !ed25519.Verify(pub, selectorClaimHash(hash, &s.Ordinal), s.Claim[ed25519.PublicKeySize:]) {
+			return nil
+		}
+		return pub
+	}
+	return nil
 }
 
 // isNamed returns true if this selector's plain text name matches the argument.
 func (s *Selector) isNamed(hash, plainTextName []byte) bool {
-	var prng seededPrng
-	prng.seed(plainTextName)
-	priv, err := ecdsa.GenerateKey(ECCCurveBrainpoolP160T1, &prng)
+	priv, err := selectorPrivateKey(s.Type, plainTextName)
 	if err != nil {
 		return false
 	}
-	pub := s.claimKey(hash)
-	return pub.X.Cmp(priv.PublicKey.X) == 0 && pub.Y.Cmp(priv.PublicKey.Y) == 0
+	switch pub := s.claimKey(hash).(type) {
+	case *ecdsa.PublicKey:
+		if ppriv, ok := priv.(*ecdsa.PrivateKey); ok {
+			return pub.X.Cmp(ppriv.PublicKey.X) == 0 && pub.Y.Cmp(ppriv.PublicKey.Y) == 0
+		}
+	case ed25519.PublicKey:
+		if ppriv, ok := priv.(ed25519.PrivateKey); ok {
+			return bytes.Equal(pub, ppriv.Public().(ed25519.PublicKey))
+		}
+	}
+	return false
 }
 
 // id returns the public key recovered from the claim signature or nil if there is an error.
 func (s *Selector) id(hash []byte) []byte {
-	pub := s.claimKey(hash)
-	if pub != nil {
+	switch pub := s.claimKey(hash).(type) {
+	case *ecdsa.PublicKey:
 		pcomp, _ := ECDSACompressPublicKey(pub)
 		return pcomp
+	case ed25519.PublicKey:
+		return pub
 	}
 	return nil
 }
 
 // key returns the sortable and comparable database key for this selector.
 func (s *Selector) key(hash []byte) []byte {
-	pub := s.claimKey(hash)
-	key, _ := ECDSAHashPublicKey(pub)
+	key, err := selectorKeyHash(s.claimKey(hash))
+	if err != nil {
+		return nil
+	}
 	addOrdinalToHash(&key, &s.Ordinal)
 	return key[:]
 }
 
 func (s *Selector) marshalTo(out io.Writer) error {
-	if len(s.Claim) != 41 {
+	switch s.Type {
+	case SelectorTypeBP160:
+		if len(s.Claim) != 41 {
+			return ErrInvalidObject
+		}
+		// We can pack the last byte of the claim key into the first byte since
+		// it's the key recovery index and will be 0 or 1. This saves one byte.
+		if _, err := out.Write([]byte{SelectorTypeBP160 | (s.Claim[40] << 4)}); err != nil {
+			return err
+		}
+		if _, err := out.Write(s.Ordinal[:]); err != nil {
+			return err
+		}
+		if _, err := out.Write(s.Claim[0:40]); err != nil {
+			return err
+		}
+	case SelectorTypeEd25519:
+		if len(s.Claim) != selectorClaimSize(SelectorTypeEd25519) {
+			return ErrInvalidObject
+		}
+		if _, err := out.Write([]byte{SelectorTypeEd25519}); err != nil {
+			return err
+		}
+		if _, err := out.Write(s.Ordinal[:]); err != nil {
+			return err
+		}
+		if _, err := out.Write(s.Claim); err != nil {
+			return err
+		}
+	default:
 		return ErrInvalidObject
 	}
-	// We can pack the last byte of the claim key into the first byte since
-	// it's the key recovery index and will be 0 or 1. This saves one byte.
-	if _, err := out.Write([]byte{SelectorTypeBP160 | (s.Claim[40] << 4)}); err != nil {
-		return err
-	}
-	if _, err := out.Write(s.Ordinal[:]); err != nil {
-		return err
-	}
-	if _, err := out.Write(s.Claim[0:40]); err != nil {
-		return err
-	}
 	return nil
 }
 
@@ -153,35 +296,55 @@ func (s *Selector) unmarshalFrom(in io.Reader) error {
 	if _, err := io.ReadFull(in, t[:]); err != nil {
 		return err
 	}
-	if (t[0] & 0xf) != SelectorTypeBP160 {
+	s.Type = t[0] & 0xf
+	switch s.Type {
+	case SelectorTypeBP160:
+		if _, err := io.ReadFull(in, s.Ordinal[:]); err != nil {
+			return err
+		}
+		var cl [41]byte
+		if _, err := io.ReadFull(in, cl[0:40]); err != nil {
+			return err
+		}
+		cl[40] = t[0] >> 4
+		s.Claim = cl[:]
+	case SelectorTypeEd25519:
+		if (t[0] >> 4) != 0 {
+			return ErrInvalidObject
+		}
+		if _, err := io.ReadFull(in, s.Ordinal[:]); err != nil {
+			return err
+		}
+		cl := make([]byte, selectorClaimSize(SelectorTypeEd25519))
+		if _, err := io.ReadFull(in, cl); err != nil {
+			return err
+		}
+		s.Claim = cl
+	default:
 		return ErrInvalidObject
 	}
-	if _, err := io.ReadFull(in, s.Ordinal[:]); err != nil {
-		return err
-	}
-	var cl [41]byte
-	if _, err := io.ReadFull(in, cl[0:40]); err != nil {
-		return err
-	}
-	cl[40] = t[0] >> 4
-	s.Claim = cl[:]
 	return nil
 }
 
-func (s *Selector) set(plainTextName []byte, plainTextOrdinal uint64, hash []byte) {
+func (s *Selector) set(selectorType byte, plainTextName []byte, plainTextOrdinal uint64, hash []byte) error {
+	s.Type = selectorType
 	s.Ordinal.Set(plainTextOrdinal, plainTextName)
 
-	var prng seededPrng
-	prng.seed(plainTextName)
-	priv, err := ecdsa.GenerateKey(ECCCurveBrainpoolP160T1, &prng)
-
-	sigHash := sha256.New()
-	sigHash.Write(hash)
-	sigHash.Write(s.Ordinal[:])
-	var sigHashBuf [32]byte
-	cs, err := ECDSASignEmbedRecoveryIndex(priv, sigHash.Sum(sigHashBuf[:0]))
-	if err != nil || len(cs) != 41 { // this would indicate a bug
-		panic("ECDSA signature for selector generation failed!")
+	priv, err := selectorPrivateKey(selectorType, plainTextName)
+	if err != nil {
+		return err
 	}
-	s.Claim = cs
+	switch k := priv.(type) {
+	case *ecdsa.PrivateKey:
+		cs, err := ECDSASignEmbedRecoveryIndex(k, selectorClaimHash(hash, &s.Ordinal))
+		if err != nil || len(cs) != 41 { // this would indicate a bug
+			panic("ECDSA signature for selector generation failed!")
+		}
+		s.Claim = cs
+	case ed25519.PrivateKey:
+		cl := make([]byte, 0, selectorClaimSize(SelectorTypeEd25519))
+		cl = append(cl, k.Public().(ed25519.PublicKey)...)
+		s.Claim = append(cl, ed25519.Sign(k, selectorClaimHash(hash, &s.Ordinal))...)
+	}
+	return nil
 }
@@ -28,6 +28,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -161,35 +162,42 @@ func TestCore(out io.Writer) bool {
 	}
 	_, _ = fmt.Fprintf(out, "OK (%f ms/ordinal)\n", (oend.Sub(ostart).Seconds()*1000.0)/ocount)
 
-	_, _ = fmt.Fprintf(out, "Testing Selector... ")
-	var testSelectors [256]Selector
-	var testSelectorClaimHash [32]byte
-	_, _ = secureRandom.Read(testSelectorClaimHash[:])
-	for k := range testSelectors {
-		testSelectors[k].set([]byte("name"), uint64(k), testSelectorClaimHash[:])
-		ts2, err := NewSelectorFromBytes(testSelectors[k].Bytes())
-		if err != nil || !bytes.Equal(ts2.Ordinal[:], testSelectors[k].Ordinal[:]) || !bytes.Equal(ts2.Claim, testSelectors[k].Claim) {
-			_, _ = fmt.Fprintln(out, "FAILED (marshal/unmarshal)")
-			return false
+	for _, selectorType := range []byte{SelectorTypeBP160, SelectorTypeEd25519} {
+		_, _ = fmt.Fprintf(out, "Testing Selector (type %d)... ", selectorType)
+		var testSelectors [256]Selector
+		var testSelectorClaimHash [32]byte
+		_, _ = secureRandom.Read(testSelectorClaimHash[:])
+		for k := range testSelectors {
+			_ = testSelectors[k].set(selectorType, []byte("name"), uint64(k), testSelectorClaimHash[:])
+			ts2, err := NewSelectorFromBytes(testSelectors[k].Bytes())
+			if err != nil || ts2.Type != selectorType || !bytes.Equal(ts2.Ordinal[:], testSelectors[k].Ordinal[:]) || !bytes.Equal(ts2.Claim, testSelectors[k].Claim) {
+				_, _ = fmt.Fprintln(out, "FAILED (marshal/unmarshal)")
+				return false
+			}
+			if !ts2.isNamed(testSelectorClaimHash[:], []byte("name")) || ts2.isNamed(testSelectorClaimHash[:], []byte("eman")) {
+				_, _ = fmt.Fprintln(out, "FAILED (isNamed)")
+				return false
+			}
 		}
-	}
-	for k := 1; k < len(testSelectors); k++ {
-		sk := testSelectors[k].key(testSelectorClaimHash[:])
-		if bytes.Compare(testSelectors[k-1].key(testSelectorClaimHash[:]), sk) >= 0 {
-			_, _ = fmt.Fprintf(out, "FAILED (compare %d not < %d)\n", k-1, k)
-			return false
+		for k := 1; k < len(testSelectors); k++ {
+			sk := testSelectors[k].key(testSelectorClaimHash[:])
+			if bytes.Compare(testSelectors[k-1].key(testSelectorClaimHash[:]), sk) >= 0 {
+				_, _ = fmt.Fprintf(out, "FAILED (compare %d not < %d)\n", k-1, k)
+				return false
+			}
 		}
-	}
-	for k := 0; k < 32; k++ {
-		rn := rand.Uint64()
-		var selTest Selector
-		selTest.set([]byte("name"), rn, testSelectorClaimHash[:])
-		if !bytes.Equal(MakeSelectorKey([]byte("name"), rn), selTest.key(testSelectorClaimHash[:])) {
-			_, _ = fmt.Fprintf(out, "FAILED (keys from key() vs MakeSelectorKey() are not equal)\n")
-			return false
+		for k := 0; k < 32; k++ {
+			rn := rand.Uint64()
+			var selTest Selector
+			_ = selTest.set(selectorType, []byte("name"), rn, testSelectorClaimHash[:])
+			sk, _ := MakeSelectorKeyWithType(selectorType, []byte("name"), rn)
+			if !bytes.Equal(sk, selTest.key(testSelectorClaimHash[:])) {
+				_, _ = fmt.Fprintf(out, "FAILED (keys from key() vs MakeSelectorKey() are not equal)\n")
+				return false
+			}
 		}
+		_, _ = fmt.Fprintf(out, "OK\n")
 	}
-	_, _ = fmt.Fprintf(out, "OK\n")
 
 	curves := []elliptic.Curve{elliptic.P384(), elliptic.P224(), ECCCurveBrainpoolP160T1}
 	for ci := range curves {
@@ -410,6 +418,51 @@ func TestCore(out io.Writer) bool {
 	}
 	_, _ = fmt.Fprintf(out, "OK\n")
 
+	_, _ = fmt.Fprintf(out, "Testing query value filters and projections... ")
+	filterDoc, _ := decodeQueryValue([]byte(`{"name":"eels","count":3,"tags":["a","b"],"owner":{"emails":["x@example.com"]},"gone":null}`))
+	for _, ft := range []struct {
+		expr  string
+		match bool
+	}{
+		{`$.name == "eels"`, true},
+		{`$.count > 2 && $.count <= 3`, true},
+		{`$.count < 3 || $.tags[1] == "b"`, true},
+		{`!($.name != "eels")`, true},
+		{`$.owner.emails[0] == "x@example.com"`, true},
+		{`$["name"] >= "eel"`, true},
+		{`$.gone`, false},
+		{`$.missing`, false},
+		{`!$.count`, false},
+	} {
+		f, err := compileQueryValueFilter(ft.expr)
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "FAILED (compile %s): %s\n", ft.expr, err.Error())
+			return false
+		}
+		if f.match(filterDoc) != ft.match {
+			_, _ = fmt.Fprintf(out, "FAILED (%s should be %t)\n", ft.expr, ft.match)
+			return false
+		}
+	}
+	for _, expr := range []string{``, `$.name ==`, `($.name`, `$.name == eels`, `name == "eels"`, `$.tags[x]`, strings.Repeat("(", 1000000), strings.Repeat("!", 1000000) + "$", strings.Repeat("(", queryFilterMaxDepth+1) + "$" + strings.Repeat(")", queryFilterMaxDepth+1)} {
+		if _, err := compileQueryValueFilter(expr); err == nil {
+			_, _ = fmt.Fprintf(out, "FAILED (invalid expression %.32q accepted)\n", expr)
+			return false
+		}
+	}
+	if _, err := compileQueryValueFilter(strings.Repeat("(", queryFilterMaxDepth) + "$" + strings.Repeat(")", queryFilterMaxDepth)); err != nil {
+		_, _ = fmt.Fprintf(out, "FAILED (expression at maximum depth rejected)\n")
+		return false
+	}
+	if p, err := compileJSONPath(`$.owner.emails[0]`); err != nil {
+		_, _ = fmt.Fprintf(out, "FAILED (compile path): %s\n", err.Error())
+		return false
+	} else if v, ok := p.get(filterDoc); !ok || v != "x@example.com" {
+		_, _ = fmt.Fprintf(out, "FAILED (projection returned %v)\n", v)
+		return false
+	}
+	_, _ = fmt.Fprintf(out, "OK\n")
+
 	_, _ = fmt.Fprintf(out, "Testing Record with full proof of work (generate, verify)... ")
 	var testLinks [][32]byte
 	for i := 0; i < 3; i++ {
@@ -627,6 +680,98 @@ func TestDatabase(testBasePath string, out io.Writer) bool {
 	}
 	_, _ = fmt.Fprintf(out, "All databases reached the same final state for hashes, weights, and links.\n")
 
+	_, _ = fmt.Fprintf(out, "Testing record export stream, checkpoints, resume, and checksums... ")
+	const testExportCheckpointRecords = 1 // checkpoint wherever no record is waiting for its links
+	var noShutdown uint32
+	var exported bytes.Buffer
+	if _, err = exportRecords(&dbs[0], &exported, 0, testExportCheckpointRecords, &noShutdown); err != nil {
+		_, _ = fmt.Fprintf(out, "FAILED (export: %s)\n", err.Error())
+		return false
+	}
+	var exportedRecords [][]byte
+	var checkpoints []*ExportCheckpoint
+	var checkpointRecordCounts []int // records in stream before each checkpoint
+	exportedHashes := make(map[[32]byte]bool)
+	er := NewExportReader(bytes.NewReader(exported.Bytes()))
+	for done := false; !done; {
+		frameType, data, cp, err := er.Next()
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "FAILED (read: %s)\n", err.Error())
+			return false
+		}
+		switch frameType {
+		case ExportFrameRecord:
+			rec, err := NewRecordFromBytes(data)
+			if err != nil {
+				_, _ = fmt.Fprintf(out, "FAILED (unmarshal: %s)\n", err.Error())
+				return false
+			}
+			for _, l := range rec.Links {
+				if !exportedHashes[l] {
+					_, _ = fmt.Fprintf(out, "FAILED (record exported before one of its links)\n")
+					return false
+				}
+			}
+			exportedHashes[rec.Hash()] = true
+			exportedRecords = append(exportedRecords, data)
+		case ExportFrameCheckpoint:
+			checkpoints = append(checkpoints, cp)
+			checkpointRecordCounts = append(checkpointRecordCounts, len(exportedRecords))
+		case ExportFrameEnd:
+			done = true
+		}
+	}
+	if len(exportedRecords) != testDatabaseRecords {
+		_, _ = fmt.Fprintf(out, "FAILED (got %d records, expected %d)\n", len(exportedRecords), testDatabaseRecords)
+		return false
+	}
+
+	// A stream resumed from a checkpoint must continue exactly where the full stream did.
+	// Records were inserted in random order so how many checkpoints there are before the last varies.
+	cpi := (len(checkpoints) - 1) / 2
+	var resumed bytes.Buffer
+	if _, err = exportRecords(&dbs[0], &resumed, checkpoints[cpi].Offset, testExportCheckpointRecords, &noShutdown); err != nil {
+		_, _ = fmt.Fprintf(out, "FAILED (resumed export: %s)\n", err.Error())
+		return false
+	}
+	ri := checkpointRecordCounts[cpi]
+	er = NewExportReader(&resumed)
+	for done := false; !done; {
+		frameType, data, _, err := er.Next()
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "FAILED (read resumed: %s)\n", err.Error())
+			return false
+		}
+		switch frameType {
+		case ExportFrameRecord:
+			if ri >= len(exportedRecords) || !bytes.Equal(data, exportedRecords[ri]) {
+				_, _ = fmt.Fprintf(out, "FAILED (resumed stream differs from full stream at record %d)\n", ri)
+				return false
+			}
+			ri++
+		case ExportFrameEnd:
+			done = true
+		}
+	}
+	if ri != len(exportedRecords) {
+		_, _ = fmt.Fprintf(out, "FAILED (resumed stream ended at record %d of %d)\n", ri, len(exportedRecords))
+		return false
+	}
+
+	// Corrupting a record must be caught at the next checkpoint.
+	corrupt := append([]byte(nil), exported.Bytes()...)
+	_, lenBytes := binary.Uvarint(corrupt[1:])
+	corrupt[1+lenBytes] ^= 1
+	er = NewExportReader(bytes.NewReader(corrupt))
+	for err = nil; err == nil; {
+		_, _, _, err = er.Next()
+	}
+	if err != ErrExportChecksumMismatch {
+		_, _ = fmt.Fprintf(out, "FAILED (corrupted stream returned %v instead of checksum mismatch)\n", err)
+		return false
+	}
+	_, _ = fmt.Fprintf(out, "OK (%d records, %d checkpoints, resumed from checkpoint %d)\n", len(exportedRecords), len(checkpoints), cpi+1)
+
 	_, _ = fmt.Fprintf(out, "Testing database queries by selector and selector range...\n")
 	var gotRecordCount uint32
 	wg := new(sync.WaitGroup)
@@ -637,7 +782,7 @@ func TestDatabase(testBasePath string, out io.Writer) bool {
 			defer wg.Done()
 			rb := make([]byte, 0, 4096)
 			for ri := 0; ri < testDatabaseRecords; ri++ {
-				err = dbs[dbi].query([][2][]byte{{selectorKeys[ri], selectorKeys[ri]}}, nil, func(ts, weightL, weightH, doff, dlen uint64, localReputation int, key uint64, owner []byte, negativeComments uint) bool {
+				err = dbs[dbi].query([][2][]byte{{selectorKeys[ri], selectorKeys[ri]}}, nil, func(ts, weightL, weightH, doff, dlen uint64, localReputation int, key uint64, owner []byte, negativeOracles, positiveOracles uint64) bool {
 					rdata, err := dbs[dbi].getDataByOffset(doff, uint(dlen), rb[:0])
 					if err != nil {
 						_, _ = fmt.Fprintf(out, "  FAILED to retrieve (selector key: %x) (%s)\n", selectorKeys[ri], err.Error())
@@ -683,7 +828,7 @@ func TestDatabase(testBasePath string, out io.Writer) bool {
 				ptk := []byte(fmt.Sprintf("%.16x%s", oi, selRandom))
 				sk0 := MakeSelectorKey(ptk, 0)
 				sk1 := MakeSelectorKey(ptk, 0xffffffffffffffff)
-				err = dbs[dbi].query([][2][]byte{{sk0, sk1}}, nil, func(ts, weightL, weightH, doff, dlen uint64, localReputation int, key uint64, owner []byte, negativeComments uint) bool {
+				err = dbs[dbi].query([][2][]byte{{sk0, sk1}}, nil, func(ts, weightL, weightH, doff, dlen uint64, localReputation int, key uint64, owner []byte, negativeOracles, positiveOracles uint64) bool {
 					_, err := dbs[dbi].getDataByOffset(doff, uint(dlen), rb[:0])
 					if err != nil {
 						_, _ = fmt.Fprintf(out, "  FAILED to retrieve (selector key range %x-%x) (%s)\n", sk0, sk1, err.Error())
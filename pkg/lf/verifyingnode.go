@@ -0,0 +1,356 @@
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// verifyingNodeOwnerCertTTL is how long a VerifyingNode caches an owner's verified certificates.
+const verifyingNodeOwnerCertTTL = time.Minute * 5
+
+// VerifyingNode wraps another LF implementation and checks query results locally before returning them.
+// Each returned record's signatures, selector claims, proof of work or certificate, and value are checked
+// against the query and against trusted genesis parameters, and results that fail are dropped. Trust and
+// weight depend on the node's view of the DAG and cannot be checked locally, but a node cannot make a
+// record that fails these checks appear in results. Value filters and projections are re-applied to
+// values unmasked locally. Candidates the node says it excluded (see QueryResultExplanation) are removed
+// since there's no way to check why they were excluded. Owner certificates are obtained from the wrapped
+// node via OwnerStatus and cached for five minutes, so revocations are only as current as the node
+// reports them.
+type VerifyingNode struct {
+	LF
+
+	// Rejected is called (if non-nil) for each result that fails verification.
+	Rejected func(*QueryResult, error)
+
+	genesisParameters *GenesisParameters
+	ownerCerts        map[string]verifiedOwnerCerts // verified owner certs by owner (base62)
+	ownerCertsLock    sync.Mutex
+}
+
+// verifiedOwnerCerts is an entry in VerifyingNode's owner certificate cache.
+type verifiedOwnerCerts struct {
+	certs   []*x509.Certificate
+	expires time.Time
+}
+
+// NewVerifyingNode creates a VerifyingNode that checks results from n against trusted genesis parameters.
+// The genesis parameters should come from a trusted source such as genesis.lf, not from n itself.
+func NewVerifyingNode(n LF, gp *GenesisParameters) *VerifyingNode {
+	return &VerifyingNode{
+		LF:                n,
+		genesisParameters: gp,
+		ownerCerts:        make(map[string]verifiedOwnerCerts),
+	}
+}
+
+// GetRecord gets a record and checks that it is valid and has the requested hash.
+func (v *VerifyingNode) GetRecord(hash []byte) (*Record, error) {
+	rec, err := v.LF.GetRecord(hash)
+	if err != nil {
+		return nil, err
+	}
+	if err = rec.Validate(); err != nil {
+		return nil, err
+	}
+	rh := rec.Hash()
+	if !bytes.Equal(rh[:], hash) {
+		return nil, ErrVerifyHashMismatch
+	}
+	return rec, nil
+}
+
+// ExecuteQuery executes a query and drops any results that fail verification.
+func (v *VerifyingNode) ExecuteQuery(q *Query) (QueryResults, error) {
+	q2 := *q
+	q2.OmitRecord = nil // records are needed to verify results
+	qr, err := v.LF.ExecuteQuery(&q2)
+	if err != nil {
+		return nil, err
+	}
+	return v.verifyResults(q, qr)
+}
+
+// ExecuteQueryBatch executes a batch of queries and drops any results that fail verification.
+func (v *VerifyingNode) ExecuteQueryBatch(queries QueryBatch) ([]QueryBatchResult, error) {
+	queries2 := make(QueryBatch, len(queries))
+	copy(queries2, queries)
+	for i := range queries2 {
+		queries2[i].OmitRecord = nil
+	}
+	qbr, err := v.LF.ExecuteQueryBatch(queries2)
+	if err != nil {
+		return nil, err
+	}
+	for i := range qbr {
+		if i < len(queries) && qbr[i].Error == nil {
+			qbr[i].Results, err = v.verifyResults(&queries[i], qbr[i].Results)
+			if err != nil {
+				qbr[i].Results = nil
+				qbr[i].Error = &ErrAPI{Code: http.StatusBadRequest, Message: "query failed: " + err.Error(), ErrTypeName: errTypeName(err)}
+			}
+		}
+	}
+	return qbr, nil
+}
+
+// IsLocal returns false since results are always verified as if they came from elsewhere.
+func (v *VerifyingNode) IsLocal() bool { return false }
+
+// verifyResults removes results that fail verification and then strips records if the query asked for that.
+// An error is returned if the query itself is invalid, since then no result can be verified.
+func (v *VerifyingNode) verifyResults(q *Query, qr QueryResults) (QueryResults, error) {
+	selectorRanges, err := querySelectorKeyRanges(q.Ranges)
+	if err != nil {
+		return nil, err
+	}
+	maskingKey := q.MaskingKey
+	if len(maskingKey) == 0 && len(q.Ranges) > 0 && len(q.Ranges[0].KeyRange) == 0 {
+		maskingKey = q.Ranges[0].Name
+	}
+	var valueFilter queryValueFilter
+	var projectPath jsonPath
+	if len(q.Filter) > 0 {
+		if valueFilter, err = compileQueryValueFilter(q.Filter); err != nil {
+			return nil, err
+		}
+	}
+	if len(q.Project) > 0 {
+		if projectPath, err = compileJSONPath(q.Project); err != nil {
+			return nil, err
+		}
+	}
+	if (valueFilter != nil || projectPath != nil) && len(maskingKey) == 0 {
+		return nil, ErrQueryFilterRequiresMaskingKey
+	}
+
+	verified := make(QueryResults, 0, len(qr))
+	for _, ress := range qr {
+		verifiedSet := make([]QueryResult, 0, len(ress))
+		for i := range ress {
+			if err := v.verifyResult(q, selectorRanges, maskingKey, valueFilter, projectPath, &ress[i]); err != nil {
+				if v.Rejected != nil {
+					v.Rejected(&ress[i], err)
+				}
+				continue
+			}
+			if q.OmitRecord != nil && *q.OmitRecord {
+				ress[i].Record = nil
+			}
+			if ress[i].Explain != nil {
+				ress[i].Explain.Excluded = nil
+			}
+			verifiedSet = append(verifiedSet, ress[i])
+		}
+		if len(verifiedSet) > 0 {
+			verified = append(verified, verifiedSet)
+		}
+	}
+	return verified, nil
+}
+
+// verifyResult checks a single result against the query that produced it.
+func (v *VerifyingNode) verifyResult(q *Query, selectorRanges [][2][]byte, maskingKey []byte, valueFilter queryValueFilter, projectPath jsonPath, res *QueryResult) error {
+	rec := res.Record
+	if rec == nil {
+		return ErrVerifyRecordMissing
+	}
+	if err := rec.Validate(); err != nil {
+		return err
+	}
+	rh := rec.Hash()
+	if !bytes.Equal(rh[:], res.Hash[:]) {
+		return ErrVerifyHashMismatch
+	}
+
+	// Check selectors against query, including plain text names if the query contained them.
+	if len(rec.Selectors) < len(selectorRanges) || (len(rec.Selectors) != len(selectorRanges) && (q.Open == nil || !*q.Open)) {
+		return ErrVerifySelectorMismatch
+	}
+	for i := range selectorRanges {
+		sk := rec.SelectorKey(i)
+		if bytes.Compare(sk, selectorRanges[i][0]) < 0 || bytes.Compare(sk, selectorRanges[i][1]) > 0 {
+			return ErrVerifySelectorMismatch
+		}
+	}
+	ri := 0
+	for _, r := range q.Ranges {
+		if len(r.KeyRange) == 0 {
+			if len(r.Range) > 2 {
+				continue
+			}
+			if !rec.SelectorIs(r.Name, ri) {
+				return ErrVerifySelectorMismatch
+			}
+		} else if len(r.KeyRange) > 2 {
+			continue
+		}
+		ri++
+	}
+
+	if len(q.Owners) > 0 {
+		ownerOk := false
+		for _, o := range q.Owners {
+			if bytes.Equal(o, rec.Owner) {
+				ownerOk = true
+				break
+			}
+		}
+		if !ownerOk {
+			return ErrVerifyOwnerMismatch
+		}
+	}
+	if (len(q.TimeRange) >= 1 && rec.Timestamp < q.TimeRange[0]) || (len(q.TimeRange) == 2 && rec.Timestamp > q.TimeRange[1]) {
+		return ErrVerifyTimestampMismatch
+	}
+
+	// A record must either have an owner certificate chaining to a genesis root or valid proof of work.
+	signed := false
+	if res.Signed || v.genesisParameters.AuthRequired {
+		for _, cert := range v.verifiedOwnerCertificates(rec.Owner) {
+			if rec.Timestamp >= uint64(cert.NotBefore.Unix()) && rec.Timestamp <= uint64(cert.NotAfter.Unix()) {
+				signed = true
+				break
+			}
+		}
+		if res.Signed && !signed {
+			return ErrVerifyCertificateInvalid
+		}
+	}
+	if !signed {
+		if v.genesisParameters.AuthRequired {
+			return ErrRecordCertificateRequired
+		}
+		if !rec.ValidateWork() {
+			return ErrRecordInsufficientWork
+		}
+	}
+
+	// Values unmasked by the node must match what the masking key yields locally.
+	if len(res.Value) > 0 {
+		value, err := rec.GetValue(maskingKey)
+		if err != nil || !bytes.Equal(value, res.Value) {
+			return ErrVerifyValueMismatch
+		}
+	}
+
+	// The value filter and projection are applied again here since the node could skip the filter or
+	// return any projection, and with OmitValue there's no value in the result to compare.
+	if valueFilter != nil || projectPath != nil {
+		value, err := rec.GetValue(maskingKey)
+		if err != nil {
+			return ErrVerifyValueMismatch
+		}
+		doc, isJSON := decodeQueryValue(value)
+		if valueFilter != nil && (!isJSON || !valueFilter.match(doc)) {
+			return ErrVerifyFilterMismatch
+		}
+		var projection interface{}
+		haveProjection := false
+		if projectPath != nil && isJSON {
+			projection, haveProjection = projectPath.get(doc)
+		}
+		if haveProjection {
+			resProjection, ok := decodeQueryValue(res.Projection)
+			if !ok || !reflect.DeepEqual(projection, resProjection) {
+				return ErrVerifyProjectionMismatch
+			}
+		} else if len(res.Projection) > 0 {
+			return ErrVerifyProjectionMismatch
+		}
+	} else if len(res.Projection) > 0 {
+		return ErrVerifyProjectionMismatch
+	}
+
+	return nil
+}
+
+// verifiedOwnerCertificates gets an owner's non-revoked certificates from the wrapped node and
+// returns those that chain to a genesis root CA, either directly or through one intermediate.
+func (v *VerifyingNode) verifiedOwnerCertificates(owner OwnerPublic) []*x509.Certificate {
+	ownerStr := Base62Encode(owner)
+	now := time.Now()
+	v.ownerCertsLock.Lock()
+	cached, haveCached := v.ownerCerts[ownerStr]
+	v.ownerCertsLock.Unlock()
+	if haveCached && now.Before(cached.expires) {
+		return cached.certs
+	}
+
+	var certs []*x509.Certificate
+	roots, _ := v.genesisParameters.GetAuthCertificates()
+	for _, cert := range v.ownerCertificates(owner) {
+		if cert.Subject.SerialNumber != ownerStr {
+			continue
+		}
+		issuer := roots[cert.Issuer.SerialNumber]
+		if issuer == nil {
+			// Look for an intermediate CA issued by a root. Intermediates are owners too, so their
+			// certificates can be obtained the same way using the issuer serial as an owner.
+			for _, intermediate := range v.ownerCertificates(Base62Decode(cert.Issuer.SerialNumber)) {
+				root := roots[intermediate.Issuer.SerialNumber]
+				if intermediate.Subject.SerialNumber == cert.Issuer.SerialNumber && root != nil && verifyCertIssuer(intermediate, root) {
+					issuer = intermediate
+					break
+				}
+			}
+		}
+		if issuer != nil && verifyCertIssuer(cert, issuer) {
+			certs = append(certs, cert)
+		}
+	}
+
+	v.ownerCertsLock.Lock()
+	for o, c := range v.ownerCerts {
+		if !now.Before(c.expires) {
+			delete(v.ownerCerts, o)
+		}
+	}
+	v.ownerCerts[ownerStr] = verifiedOwnerCerts{certs: certs, expires: now.Add(verifyingNodeOwnerCertTTL)}
+	v.ownerCertsLock.Unlock()
+	return certs
+}
+
+// ownerCertificates gets and parses an owner's current certificates from the wrapped node.
+func (v *VerifyingNode) ownerCertificates(owner OwnerPublic) (certs []*x509.Certificate) {
+	if len(owner) == 0 {
+		return
+	}
+	st, err := v.LF.OwnerStatus(owner)
+	if err != nil {
+		return
+	}
+	for _, der := range st.Certificates {
+		cert, err := x509.ParseCertificate(der)
+		if err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return
+}
+
+// verifyCertIssuer checks a certificate's signature and validity period against its issuer in the same way nodes do.
+func verifyCertIssuer(cert, issuer *x509.Certificate) bool {
+	return issuer.IsCA &&
+		(issuer.KeyUsage&x509.KeyUsageCertSign) != 0 &&
+		cert.NotBefore.After(issuer.NotBefore) &&
+		issuer.NotAfter.After(cert.NotBefore) &&
+		cert.CheckSignatureFrom(issuer) == nil
+}
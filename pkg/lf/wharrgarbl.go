@@ -14,18 +14,21 @@
 package lf
 
 import (
+	"bytes"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/sha512"
 	"encoding/binary"
-	"hash/crc32"
-	"io"
 	"io/ioutil"
+	"math"
 	"math/rand"
 	"os"
+	"path"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"lf/third_party/lfmd5"
 )
@@ -36,12 +39,29 @@ const wharrgarblTableSize = 0x8000000
 // wharrgarblTableMask is the mask to constrain a value to 0..wharrgarblTableSize (faster than modulus)
 const wharrgarblTableMask = 0x7ffffff
 
+// wharrgarblTableCRC is the CRC32 (IEEE) of a correctly generated table
+const wharrgarblTableCRC = 0xf25f8b7d
+
+// Cached table files begin with a header containing this magic, a version, and the table size.
+const (
+	wharrgarblTableFileMagic      = "LFWT"
+	wharrgarblTableFileVersion    = 1
+	wharrgarblTableFileHeaderSize = 16
+)
+
 var wharrgarblTable *[wharrgarblTableSize]byte
 var wharrgarblTableLock sync.RWMutex
+var wharrgarblTableInitialized uint32 // set (atomically) once wharrgarblTable is ready for use
 
 // WharrgarblOutputSize is the size of Wharrgarbl's result in bytes
 const WharrgarblOutputSize = 14
 
+// wharrgarblProgressInterval is how often ComputeContext calls its progress callback (if any)
+const wharrgarblProgressInterval = time.Second
+
+// WharrgarblProgressFunc receives search iterations so far and the estimated probability (0..1) that a result would have been found by now.
+type WharrgarblProgressFunc func(iterations uint64, probability float64)
+
 // Wharrgarblr is an instance of the Wharrgarbl proof of work function
 type Wharrgarblr struct {
 	memory      []uint64
@@ -151,14 +171,12 @@ func wharrgarblFrankenhash(md5 *lfmd5.Digest, cipher0, cipher1 cipher.Block, tmp
 }
 
 // WharrgarblInitTable initializes the internal memory table if it's not already.
-// If cacheFilePath is non-empty the table will be cached there for faster startup.
+// If cacheFilePath is non-empty the table will be cached there for faster startup. On Unix-like systems
+// the cached table is memory mapped read-only so that multiple processes on the same system share one copy.
 func WharrgarblInitTable(cacheFilePath string) {
-	wharrgarblTableLock.RLock()
-	if wharrgarblTable != nil {
-		wharrgarblTableLock.RUnlock()
+	if atomic.LoadUint32(&wharrgarblTableInitialized) != 0 {
 		return
 	}
-	wharrgarblTableLock.RUnlock()
 
 	wharrgarblTableLock.Lock()
 	if wharrgarblTable != nil {
@@ -166,38 +184,83 @@ func WharrgarblInitTable(cacheFilePath string) {
 		return
 	}
 
-	wharrgarblTable = new([wharrgarblTableSize]byte)
-
 	if len(cacheFilePath) > 0 {
-		cf, _ := os.Open(cacheFilePath)
-		if cf != nil {
-			_, err := io.ReadFull(cf, wharrgarblTable[:])
-			if err == nil {
-				if crc32.ChecksumIEEE(wharrgarblTable[:]) == 0xf25f8b7d {
-					wharrgarblTableLock.Unlock()
-					return
-				}
-			}
-			for i := 0; i < wharrgarblTableSize; i++ {
-				wharrgarblTable[i] = 0
-			}
+		wharrgarblTable = wharrgarblMapTable(cacheFilePath)
+		if wharrgarblTable != nil {
+			atomic.StoreUint32(&wharrgarblTableInitialized, 1)
+			wharrgarblTableLock.Unlock()
+			return
 		}
 	}
 
-	copy(wharrgarblTable[:], "My hovercraft is full of eels!")
+	table := new([wharrgarblTableSize]byte)
+	copy(table[:], "My hovercraft is full of eels!")
 	for i := 0; i < 4; i++ {
-		h := sha512.Sum512(wharrgarblTable[:])
+		h := sha512.Sum512(table[:])
 		aesCipher, _ := aes.NewCipher(h[0:32])
 		c := cipher.NewCFBEncrypter(aesCipher, h[32:48])
-		c.XORKeyStream(wharrgarblTable[:], wharrgarblTable[:])
-		c.XORKeyStream(wharrgarblTable[:], wharrgarblTable[:])
+		c.XORKeyStream(table[:], table[:])
+		c.XORKeyStream(table[:], table[:])
 	}
+	wharrgarblTable = table
+	atomic.StoreUint32(&wharrgarblTableInitialized, 1)
+	wharrgarblTableLock.Unlock()
 
-	if len(cacheFilePath) > 0 {
-		go ioutil.WriteFile(cacheFilePath, wharrgarblTable[:], 0644)
+	// Writing the cache file (and syncing it) is slow, so it's done after the table is in use. Once it's written
+	// this process switches to the mapped file so that it shares one copy of the table with others.
+	if len(cacheFilePath) > 0 && wharrgarblWriteTable(cacheFilePath, table) == nil && wharrgarblTableMapped {
+		if m := wharrgarblMapTable(cacheFilePath); m != nil {
+			wharrgarblTableLock.Lock()
+			wharrgarblTable = m
+			wharrgarblTableLock.Unlock()
+		}
 	}
+}
 
-	wharrgarblTableLock.Unlock()
+// wharrgarblTableReady returns true if the internal memory table has been initialized.
+// This doesn't take wharrgarblTableLock since that's held for the whole time the table is being generated.
+func wharrgarblTableReady() bool {
+	return atomic.LoadUint32(&wharrgarblTableInitialized) != 0
+}
+
+// wharrgarblTableFileHeaderValid returns true if a cached table file header is for this table version and size.
+func wharrgarblTableFileHeaderValid(hdr []byte) bool {
+	return len(hdr) >= wharrgarblTableFileHeaderSize &&
+		bytes.Equal(hdr[0:4], []byte(wharrgarblTableFileMagic)) &&
+		binary.BigEndian.Uint32(hdr[4:8]) == wharrgarblTableFileVersion &&
+		binary.BigEndian.Uint32(hdr[8:12]) == wharrgarblTableSize
+}
+
+// wharrgarblWriteTable atomically writes a table cache file by writing a temporary file and renaming it.
+func wharrgarblWriteTable(cacheFilePath string, table *[wharrgarblTableSize]byte) error {
+	tf, err := ioutil.TempFile(path.Dir(cacheFilePath), path.Base(cacheFilePath)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tf.Name()
+
+	var hdr [wharrgarblTableFileHeaderSize]byte
+	copy(hdr[0:4], wharrgarblTableFileMagic)
+	binary.BigEndian.PutUint32(hdr[4:8], wharrgarblTableFileVersion)
+	binary.BigEndian.PutUint32(hdr[8:12], wharrgarblTableSize)
+	_, err = tf.Write(hdr[:])
+	if err == nil {
+		_, err = tf.Write(table[:])
+	}
+	if err == nil {
+		err = tf.Sync()
+	}
+	if err == nil {
+		err = tf.Chmod(0644)
+	}
+	_ = tf.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, cacheFilePath)
+	}
+	if err != nil {
+		_ = os.Remove(tmpPath)
+	}
+	return err
 }
 
 // NewWharrgarblr creates a new Wharrgarbl instance with the given memory size (for memory/speed tradeoff).
@@ -228,7 +291,8 @@ func (wg *Wharrgarblr) internalWorkerFunc(mmoCipher0, mmoCipher1 cipher.Block, r
 	for atomic.LoadUint32(&wg.done) == 0 {
 		iter++
 		if (iter & 0xff) == 0 {
-			runtime.Gosched() // this might not be necessary but doesn't seem to hurt and probably makes this coexist better on nodes
+			atomic.AddUint64(iterations, 0x100) // keep shared counter roughly current for progress reporting
+			runtime.Gosched()                   // this might not be necessary but doesn't seem to hurt and probably makes this coexist better on nodes
 		}
 
 		thisCollider++
@@ -278,7 +342,7 @@ func (wg *Wharrgarblr) internalWorkerFunc(mmoCipher0, mmoCipher1 cipher.Block, r
 		*collisionTableEntry = (uint64(thisCollision24) << 40) | thisCollider
 	}
 
-	atomic.AddUint64(iterations, iter)
+	atomic.AddUint64(iterations, iter&0xff)
 	doneWG.Done()
 }
 
@@ -286,11 +350,23 @@ func (wg *Wharrgarblr) internalWorkerFunc(mmoCipher0, mmoCipher1 cipher.Block, r
 // It returns a proof of work and how many total search iterations were required to find it.
 // A single Wharrgarblr can only Compute one PoW at a time and uses all its threads to do so.
 func (wg *Wharrgarblr) Compute(in []byte, difficulty uint32) (out [WharrgarblOutputSize]byte, iterations uint64) {
+	out, iterations, _ = wg.ComputeContext(context.Background(), in, difficulty, nil)
+	return
+}
+
+// ComputeContext is like Compute but can be canceled via a context and can report progress.
+// If progress is non-nil it's called periodically from another goroutine while work is being computed.
+// If the context is canceled or expires before work is found its error is returned and the output should be discarded.
+func (wg *Wharrgarblr) ComputeContext(ctx context.Context, in []byte, difficulty uint32, progress WharrgarblProgressFunc) (out [WharrgarblOutputSize]byte, iterations uint64, err error) {
 	wg.lock.Lock()
 	wharrgarblTableLock.RLock()
 	defer wg.lock.Unlock()
 	defer wharrgarblTableLock.RUnlock()
 
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
 	inHashed := sha512.Sum512(in)
 	mmoCipher0, _ := aes.NewCipher(inHashed[0:32])
 	mmoCipher1, _ := aes.NewCipher(inHashed[32:64])
@@ -301,17 +377,98 @@ func (wg *Wharrgarblr) Compute(in []byte, difficulty uint32) (out [WharrgarblOut
 	var doneWG sync.WaitGroup
 	doneWG.Add(int(wg.threadCount))
 	atomic.StoreUint32(&wg.done, 0)
+
+	// The watcher sets done on cancel and calls progress; it must start after done is reset above.
+	finished := make(chan struct{})
+	watcherDone := make(chan struct{})
+	go func() {
+		defer close(watcherDone)
+		ticker := time.NewTicker(wharrgarblProgressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-finished:
+				return
+			case <-ctx.Done():
+				atomic.StoreUint32(&wg.done, 1)
+				return
+			case <-ticker.C:
+				if progress != nil {
+					iter := atomic.LoadUint64(&iterations)
+					progress(iter, wharrgarblProbability(iter, difficulty, uint(len(wg.memory))))
+				}
+			}
+		}
+	}()
+
 	for c := uint(1); c < wg.threadCount; c++ {
 		go wg.internalWorkerFunc(mmoCipher0, mmoCipher1, runNonce, diff64, &iterations, &outLock, out[:], &doneWG)
 	}
 	wg.internalWorkerFunc(mmoCipher0, mmoCipher1, runNonce, diff64, &iterations, &outLock, out[:], &doneWG)
 	doneWG.Wait()
+	close(finished)
+	<-watcherDone
 
 	binary.BigEndian.PutUint32(out[10:14], difficulty)
 
+	// Work is found if the two colliders differ; otherwise the search was stopped early.
+	if out[0] == 0 && out[1] == 0 && out[2] == 0 && out[3] == 0 && out[4] == 0 && out[5] == 0 && out[6] == 0 && out[7] == 0 && out[8] == 0 && out[9] == 0 {
+		err = ctx.Err()
+		if err == nil {
+			err = ErrWharrgarblFailed
+		}
+	}
+
 	return
 }
 
+// ExpectedIterations returns the approximate number of search iterations after which a result is about 63% likely.
+// This can be used along with a measured iteration rate to estimate how long Compute will take.
+func (wg *Wharrgarblr) ExpectedIterations(difficulty uint32) uint64 {
+	return WharrgarblExpectedIterations(difficulty, uint(len(wg.memory))*8)
+}
+
+// WharrgarblExpectedIterations returns the approximate number of search iterations a Wharrgarblr with a given memory size needs for a difficulty.
+func WharrgarblExpectedIterations(difficulty uint32, memorySize uint) uint64 {
+	if memorySize < 1048576 {
+		memorySize = 1048576
+	}
+	m := float64(memorySize / 8)
+	return uint64((float64((uint64(difficulty)<<29)|0x000000001fffffff) / m) + m)
+}
+
+// WharrgarblBenchmark measures the search rate in iterations per second on this system for a given memory size and thread count.
+// It runs for the given duration using all requested threads. Thread count 0 means the reported CPU/core count.
+func WharrgarblBenchmark(memorySize uint, threadCount int, duration time.Duration) float64 {
+	return NewWharrgarblr(memorySize, threadCount).Benchmark(duration)
+}
+
+// Benchmark measures this Wharrgarblr's search rate in iterations per second by searching for the given duration.
+// It waits for any Compute() already in progress to finish first.
+func (wg *Wharrgarblr) Benchmark(duration time.Duration) float64 {
+	var junk [32]byte
+	_, _ = secureRandom.Read(junk[:])
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+	startTime := time.Now()
+	_, iterations, _ := wg.ComputeContext(ctx, junk[:], 0xffffffff, nil)
+	elapsed := time.Since(startTime).Seconds()
+	if elapsed <= 0.0 {
+		return 0.0
+	}
+	return float64(iterations) / elapsed
+}
+
+// wharrgarblProbability estimates the probability that a collision search has succeeded after a number of iterations.
+// Each iteration checks one table slot, and the table fills as m*(1-e^(-n/m)), so the expected number of collisions is
+// the integral of that divided by the size of the collision space.
+func wharrgarblProbability(iterations uint64, difficulty uint32, memoryEntries uint) float64 {
+	space := float64((uint64(difficulty) << 29) | 0x000000001fffffff)
+	m := float64(memoryEntries)
+	n := float64(iterations)
+	return 1.0 - math.Exp(-((m*n)-(m*m*(1.0-math.Exp(-n/m))))/space)
+}
+
 // Abort aborts the current Compute() currently in process.
 // The return values of Compute() after this call are undefined and should be thrown away.
 func (wg *Wharrgarblr) Abort() {
@@ -0,0 +1,48 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris && !android
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris,!android
+
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// wharrgarblTableMapped is true if cached tables are memory mapped and thus shared between processes.
+const wharrgarblTableMapped = false
+
+// wharrgarblMapTable reads a cached table file into memory, returning nil if it's missing, the wrong version, or corrupt.
+// This is used where memory mapping isn't available, so the cache only saves the time needed to generate the table.
+func wharrgarblMapTable(cacheFilePath string) *[wharrgarblTableSize]byte {
+	cf, err := os.Open(cacheFilePath)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = cf.Close()
+	}()
+
+	var hdr [wharrgarblTableFileHeaderSize]byte
+	if _, err = io.ReadFull(cf, hdr[:]); err != nil || !wharrgarblTableFileHeaderValid(hdr[:]) {
+		return nil
+	}
+	t := new([wharrgarblTableSize]byte)
+	if _, err = io.ReadFull(cf, t[:]); err != nil || crc32.ChecksumIEEE(t[:]) != wharrgarblTableCRC {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,54 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris || android
+// +build darwin dragonfly freebsd linux netbsd openbsd solaris android
+
+/*
+ * Copyright (c)2019 ZeroTier, Inc.
+ *
+ * Use of this software is governed by the Business Source License included
+ * in the LICENSE.TXT file in the project's root directory.
+ *
+ * Change Date: 2023-01-01
+ *
+ * On the date above, in accordance with the Business Source License, use
+ * of this software will be governed by version 2.0 of the Apache License.
+ */
+/****/
+
+package lf
+
+import (
+	"hash/crc32"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// wharrgarblTableMapped is true if cached tables are memory mapped and thus shared between processes.
+const wharrgarblTableMapped = true
+
+// wharrgarblMapTable memory maps a cached table file, returning nil if it's missing, the wrong version, or corrupt.
+func wharrgarblMapTable(cacheFilePath string) *[wharrgarblTableSize]byte {
+	cf, err := os.Open(cacheFilePath)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = cf.Close()
+	}()
+	st, err := cf.Stat()
+	if err != nil || st.Size() != int64(wharrgarblTableFileHeaderSize+wharrgarblTableSize) {
+		return nil
+	}
+
+	m, err := syscall.Mmap(int(cf.Fd()), 0, wharrgarblTableFileHeaderSize+wharrgarblTableSize, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil
+	}
+	if !wharrgarblTableFileHeaderValid(m) || crc32.ChecksumIEEE(m[wharrgarblTableFileHeaderSize:]) != wharrgarblTableCRC {
+		_ = syscall.Munmap(m)
+		return nil
+	}
+
+	// The mapping is never unmapped since the table lives for the life of the process.
+	return (*[wharrgarblTableSize]byte)(unsafe.Pointer(&m[wharrgarblTableFileHeaderSize]))
+}